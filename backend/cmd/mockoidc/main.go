@@ -0,0 +1,43 @@
+// Command mockoidc is a minimal OpenID Connect provider for trying single
+// sign-on locally. It signs in whoever types an email address and must never
+// be exposed outside a development machine.
+//
+//	go run ./cmd/mockoidc
+//
+// then start the backend with OIDC_ISSUER_URL=http://localhost:9000,
+// OIDC_CLIENT_ID=khidmat and
+// OIDC_REDIRECT_URL=http://localhost:8080/api/auth/oidc/callback.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/khidmat/backend/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := os.Getenv("MOCK_OIDC_ADDR")
+	if addr == "" {
+		addr = ":9000"
+	}
+
+	issuer := os.Getenv("MOCK_OIDC_ISSUER")
+	if issuer == "" {
+		issuer = "http://localhost" + addr
+	}
+
+	clientID := os.Getenv("MOCK_OIDC_CLIENT_ID")
+	if clientID == "" {
+		clientID = "khidmat"
+	}
+
+	provider, err := oidctest.New(issuer, clientID)
+	if err != nil {
+		log.Fatal("Failed to generate signing key:", err)
+	}
+
+	log.Printf("Mock OIDC provider %s (client_id %s) listening on %s", issuer, clientID, addr)
+	log.Fatal(http.ListenAndServe(addr, provider.Handler()))
+}
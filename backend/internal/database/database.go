@@ -71,6 +71,33 @@ func RunMigrations(db *sql.DB) error {
 		createMembersTable,
 		createPaymentsTable,
 		createDonationsTable,
+		addUsersTokensValidAfter,
+		createRefreshTokensTable,
+		createRevokedTokensTable,
+		createInvitationsTable,
+		createPasswordResetsTable,
+		createSettingsTable,
+		addUsersTwoFactorColumns,
+		createRecoveryCodesTable,
+		addUsersLockoutColumns,
+		createLoginAttemptsTable,
+		addRevokedTokensMemberID,
+		createMemberOTPsTable,
+		addUsersActiveColumns,
+		createRolesTables,
+		createAPIKeysTables,
+		createOIDCLoginsTable,
+		addMembersSoftDelete,
+		addListIndexes,
+		normalizeMemberMobiles,
+		createMemberTransfersTable,
+		createContributionPlansTables,
+		createMemberStatusHistory,
+		createHouseholdsTable,
+		createMemberCustomFields,
+		addPaymentAmountCheck,
+		createPaymentAllocations,
+		addPaymentModes,
 	}
 
 	for _, migration := range migrations {
@@ -133,6 +160,594 @@ CREATE TABLE IF NOT EXISTS donations (
 );
 `
 
+const addUsersTokensValidAfter = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMP;
+`
 
+const createRefreshTokensTable = `
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    family_id VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    replaced_by INTEGER REFERENCES refresh_tokens(id),
+    user_agent TEXT,
+    ip_address VARCHAR(64),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+`
 
+const createRevokedTokensTable = `
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+`
 
+const createInvitationsTable = `
+CREATE TABLE IF NOT EXISTS invitations (
+    id SERIAL PRIMARY KEY,
+    code_hash VARCHAR(64) UNIQUE NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    user_type VARCHAR(50) NOT NULL CHECK (user_type IN ('master_admin', 'account_admin')),
+    created_by INTEGER NOT NULL REFERENCES users(id),
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    used_by INTEGER REFERENCES users(id),
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+const createPasswordResetsTable = `
+CREATE TABLE IF NOT EXISTS password_resets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    ip_address VARCHAR(64),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets(user_id);
+CREATE INDEX IF NOT EXISTS idx_password_resets_ip ON password_resets(ip_address, created_at);
+`
+
+const createSettingsTable = `
+CREATE TABLE IF NOT EXISTS settings (
+    key VARCHAR(100) PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_by INTEGER REFERENCES users(id),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+const addUsersTwoFactorColumns = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_pending_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+`
+
+const createRecoveryCodesTable = `
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);
+`
+
+const addUsersLockoutColumns = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_login_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_failed_login_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
+`
+
+const createLoginAttemptsTable = `
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id),
+    username VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(64) NOT NULL,
+    user_agent TEXT,
+    success BOOLEAN NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id ON login_attempts(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip_address, created_at);
+`
+
+const addRevokedTokensMemberID = `
+ALTER TABLE revoked_tokens ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE revoked_tokens ADD COLUMN IF NOT EXISTS member_id INTEGER REFERENCES members(id);
+`
+
+const createMemberOTPsTable = `
+CREATE TABLE IF NOT EXISTS member_otps (
+    id SERIAL PRIMARY KEY,
+    mobile_no VARCHAR(20) NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    ip_address VARCHAR(64),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_member_otps_mobile_no ON member_otps(mobile_no, created_at);
+`
+
+const addUsersActiveColumns = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;
+`
+
+// createRolesTables replaces the fixed user_type CHECK constraints with
+// admin-editable roles. The built-in roles are seeded once; master_admin
+// needs no capability rows because it implicitly holds all of them.
+const createRolesTables = `
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    is_system BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_capabilities (
+    role_name VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    capability VARCHAR(100) NOT NULL,
+    PRIMARY KEY (role_name, capability)
+);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM roles WHERE name = 'master_admin') THEN
+        INSERT INTO roles (name, description, is_system)
+        VALUES ('master_admin', 'Full access to everything', true);
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM roles WHERE name = 'account_admin') THEN
+        INSERT INTO roles (name, description, is_system)
+        VALUES ('account_admin', 'Manages their own members, payments and donations', true);
+        INSERT INTO role_capabilities (role_name, capability) VALUES
+            ('account_admin', 'members.read'),
+            ('account_admin', 'members.write'),
+            ('account_admin', 'payments.read'),
+            ('account_admin', 'payments.write'),
+            ('account_admin', 'donations.read'),
+            ('account_admin', 'donations.write'),
+            ('account_admin', 'reports.read');
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_user_type_fkey') THEN
+        ALTER TABLE users DROP CONSTRAINT IF EXISTS users_user_type_check;
+        ALTER TABLE users ADD CONSTRAINT users_user_type_fkey FOREIGN KEY (user_type) REFERENCES roles(name);
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'invitations_user_type_fkey') THEN
+        ALTER TABLE invitations DROP CONSTRAINT IF EXISTS invitations_user_type_check;
+        ALTER TABLE invitations ADD CONSTRAINT invitations_user_type_fkey FOREIGN KEY (user_type) REFERENCES roles(name);
+    END IF;
+END $$;
+`
+
+const createAPIKeysTables = `
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    key_prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) UNIQUE NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    capabilities TEXT[] NOT NULL DEFAULT '{}',
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    last_used_ip VARCHAR(64),
+    created_by INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    id SERIAL PRIMARY KEY,
+    api_key_id INTEGER NOT NULL REFERENCES api_keys(id),
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    status_code INTEGER NOT NULL,
+    ip_address VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_api_key_usage_key_id ON api_key_usage(api_key_id, created_at);
+`
+
+const createOIDCLoginsTable = `
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    id SERIAL PRIMARY KEY,
+    state_hash VARCHAR(64) UNIQUE NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    callback_at TIMESTAMP,
+    user_id INTEGER REFERENCES users(id),
+    login_code_hash VARCHAR(64) UNIQUE,
+    login_code_expires_at TIMESTAMP,
+    redeemed_at TIMESTAMP,
+    ip_address VARCHAR(64),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+// addMembersSoftDelete keeps deleted members (and so their payments) in
+// place, and lets the database maintain members.updated_at on every UPDATE.
+const addMembersSoftDelete = `
+ALTER TABLE members ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE members ADD COLUMN IF NOT EXISTS deleted_by INTEGER REFERENCES users(id);
+
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = CURRENT_TIMESTAMP;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS members_set_updated_at ON members;
+CREATE TRIGGER members_set_updated_at BEFORE UPDATE ON members
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+`
+
+// addListIndexes backs the default sort and date filters of the member,
+// payment and donation lists.
+const addListIndexes = `
+CREATE INDEX IF NOT EXISTS idx_members_created_at ON members(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_members_admin_id ON members(admin_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_payments_created_at ON payments(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_payments_payment_date ON payments(payment_date);
+CREATE INDEX IF NOT EXISTS idx_payments_admin_id ON payments(admin_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_donations_created_at ON donations(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_donations_donation_date ON donations(donation_date);
+CREATE INDEX IF NOT EXISTS idx_donations_admin_id ON donations(admin_id, created_at);
+`
+
+// normalizeMemberMobiles rewrites member mobile numbers that predate E.164
+// normalisation, using the same rules as the application: + or 00 means
+// international, up to ten digits (without a trunk 0) are national to the
+// default_country_code setting. Numbers already in E.164 form are untouched.
+// It also records which member a merged duplicate was folded into.
+const normalizeMemberMobiles = `
+UPDATE members m SET mobile_no = '+' || CASE
+        WHEN btrim(m.mobile_no) LIKE '+%' THEN n.digits
+        WHEN n.digits LIKE '00%' THEN substr(n.digits, 3)
+        WHEN length(ltrim(n.digits, '0')) <= 10 THEN n.country_code || ltrim(n.digits, '0')
+        ELSE n.digits
+    END
+FROM (
+    SELECT id, regexp_replace(mobile_no, '\D', '', 'g') AS digits,
+        COALESCE((SELECT value FROM settings WHERE key = 'default_country_code'), '91') AS country_code
+    FROM members
+) n
+WHERE m.id = n.id AND m.mobile_no !~ '^\+[1-9][0-9]{7,14}$' AND n.digits <> '';
+
+CREATE INDEX IF NOT EXISTS idx_members_mobile_no ON members(mobile_no);
+
+ALTER TABLE members ADD COLUMN IF NOT EXISTS merged_into INTEGER REFERENCES members(id);
+`
+
+// createMemberTransfersTable keeps the history of members.admin_id.
+// member_admin_at returns the admin responsible for a member on a date: the
+// target of the latest transfer effective by then, or else the original
+// admin.
+const createMemberTransfersTable = `
+CREATE TABLE IF NOT EXISTS member_transfers (
+    id SERIAL PRIMARY KEY,
+    member_id INTEGER NOT NULL REFERENCES members(id),
+    from_admin_id INTEGER NOT NULL REFERENCES users(id),
+    to_admin_id INTEGER NOT NULL REFERENCES users(id),
+    effective_date DATE NOT NULL,
+    reason TEXT NOT NULL,
+    transferred_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_member_transfers_member_id ON member_transfers(member_id, effective_date);
+
+CREATE OR REPLACE FUNCTION member_admin_at(p_member_id INTEGER, p_at DATE) RETURNS INTEGER AS $$
+    SELECT COALESCE(
+        (SELECT to_admin_id FROM member_transfers
+         WHERE member_id = p_member_id AND effective_date <= p_at
+         ORDER BY effective_date DESC, id DESC LIMIT 1),
+        (SELECT from_admin_id FROM member_transfers
+         WHERE member_id = p_member_id
+         ORDER BY effective_date, id LIMIT 1),
+        (SELECT admin_id FROM members WHERE id = p_member_id)
+    )
+$$ LANGUAGE sql STABLE;
+`
+
+// createContributionPlansTables replaces the customary Rs 200 a month with
+// contribution plans. A member pays the plan assigned in member_plans or,
+// when none is in force, the organisation default plan in force. Plans and
+// assignments count from the month they start; quarterly and yearly plans
+// fall due every 3 or 12 months from their start month.
+const createContributionPlansTables = `
+CREATE TABLE IF NOT EXISTS contribution_plans (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL CHECK (amount > 0),
+    frequency VARCHAR(20) NOT NULL CHECK (frequency IN ('monthly', 'quarterly', 'yearly')),
+    start_date DATE NOT NULL,
+    end_date DATE CHECK (end_date IS NULL OR end_date >= start_date),
+    is_default BOOLEAN NOT NULL DEFAULT false,
+    created_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO contribution_plans (name, amount, frequency, start_date, is_default)
+SELECT 'Standard monthly', 200, 'monthly', DATE '2000-01-01', true
+WHERE NOT EXISTS (SELECT 1 FROM contribution_plans);
+
+CREATE TABLE IF NOT EXISTS member_plans (
+    id SERIAL PRIMARY KEY,
+    member_id INTEGER NOT NULL REFERENCES members(id),
+    plan_id INTEGER NOT NULL REFERENCES contribution_plans(id),
+    start_date DATE NOT NULL,
+    end_date DATE,
+    assigned_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_member_plans_member_id ON member_plans(member_id, start_date);
+
+CREATE OR REPLACE FUNCTION member_plan_at(p_member_id INTEGER, p_month DATE) RETURNS INTEGER AS $$
+    SELECT COALESCE(
+        (SELECT mp.plan_id FROM member_plans mp
+         INNER JOIN contribution_plans p ON p.id = mp.plan_id
+         WHERE mp.member_id = p_member_id
+            AND date_trunc('month', mp.start_date) <= p_month
+            AND (mp.end_date IS NULL OR mp.end_date >= p_month)
+            AND date_trunc('month', p.start_date) <= p_month
+            AND (p.end_date IS NULL OR p.end_date >= p_month)
+         ORDER BY mp.start_date DESC, mp.id DESC LIMIT 1),
+        (SELECT id FROM contribution_plans
+         WHERE is_default
+            AND date_trunc('month', start_date) <= p_month
+            AND (end_date IS NULL OR end_date >= p_month)
+         ORDER BY start_date DESC, id DESC LIMIT 1)
+    )
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION plan_amount_due(p_plan_id INTEGER, p_month DATE) RETURNS NUMERIC AS $$
+    SELECT COALESCE((
+        SELECT p.amount FROM contribution_plans p
+        WHERE p.id = p_plan_id
+            AND date_trunc('month', p.start_date) <= p_month
+            AND (p.end_date IS NULL OR p.end_date >= p_month)
+            AND ((EXTRACT(YEAR FROM p_month) * 12 + EXTRACT(MONTH FROM p_month))
+                - (EXTRACT(YEAR FROM p.start_date) * 12 + EXTRACT(MONTH FROM p.start_date)))::integer
+                % CASE p.frequency WHEN 'quarterly' THEN 3 WHEN 'yearly' THEN 12 ELSE 1 END = 0
+    ), 0)
+$$ LANGUAGE sql STABLE;
+
+-- member_expected_amount is what a member is expected to pay in the month
+-- starting p_month, from the month they joined until they were deleted.
+CREATE OR REPLACE FUNCTION member_expected_amount(p_member_id INTEGER, p_month DATE) RETURNS NUMERIC AS $$
+    SELECT CASE
+        WHEN date_trunc('month', m.created_at) > p_month OR m.deleted_at < p_month THEN 0
+        ELSE plan_amount_due(member_plan_at(m.id, p_month), p_month)
+    END
+    FROM members m
+    WHERE m.id = p_member_id
+$$ LANGUAGE sql STABLE;
+`
+
+// createMemberStatusHistory replaces the is_active toggle with lifecycle
+// states. Every change is recorded with its effective date and reason, and
+// members.is_active is kept equal to status = 'active' for existing queries.
+// Like transfers, a change counts from the month of its effective date, and
+// only active members are expected to contribute. Members that were inactive
+// before history was recorded are taken to have paused on their last update.
+const createMemberStatusHistory = `
+ALTER TABLE members ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'paused', 'exited', 'deceased'));
+
+UPDATE members SET status = 'paused' WHERE NOT is_active AND status = 'active' AND deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS member_status_history (
+    id SERIAL PRIMARY KEY,
+    member_id INTEGER NOT NULL REFERENCES members(id),
+    status VARCHAR(20) NOT NULL CHECK (status IN ('active', 'paused', 'exited', 'deceased')),
+    effective_date DATE NOT NULL,
+    reason TEXT NOT NULL,
+    changed_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_member_status_history_member_id ON member_status_history(member_id, effective_date);
+
+INSERT INTO member_status_history (member_id, status, effective_date, reason)
+SELECT m.id, 'active', m.created_at::date, 'Joined'
+FROM members m
+WHERE NOT EXISTS (SELECT 1 FROM member_status_history h WHERE h.member_id = m.id);
+
+INSERT INTO member_status_history (member_id, status, effective_date, reason)
+SELECT m.id, m.status, GREATEST(m.updated_at, m.created_at)::date, 'Inactive before status history was recorded'
+FROM members m
+WHERE m.status <> 'active'
+    AND NOT EXISTS (SELECT 1 FROM member_status_history h WHERE h.member_id = m.id AND h.status <> 'active');
+
+CREATE OR REPLACE FUNCTION record_member_joined() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO member_status_history (member_id, status, effective_date, reason)
+    VALUES (NEW.id, NEW.status, NEW.created_at::date, 'Joined');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS members_record_joined ON members;
+CREATE TRIGGER members_record_joined AFTER INSERT ON members
+    FOR EACH ROW EXECUTE FUNCTION record_member_joined();
+
+CREATE OR REPLACE FUNCTION member_status_at(p_member_id INTEGER, p_at DATE) RETURNS VARCHAR AS $$
+    SELECT status FROM member_status_history
+    WHERE member_id = p_member_id AND effective_date <= p_at
+    ORDER BY effective_date DESC, id DESC LIMIT 1
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION member_expected_amount(p_member_id INTEGER, p_month DATE) RETURNS NUMERIC AS $$
+    SELECT CASE
+        WHEN date_trunc('month', m.created_at) > p_month OR m.deleted_at < p_month THEN 0
+        WHEN COALESCE(member_status_at(m.id, (p_month + INTERVAL '1 month' - INTERVAL '1 day')::date), 'active') <> 'active' THEN 0
+        ELSE plan_amount_due(member_plan_at(m.id, p_month), p_month)
+    END
+    FROM members m
+    WHERE m.id = p_member_id
+$$ LANGUAGE sql STABLE;
+`
+
+// createHouseholdsTable groups members into households with one member who
+// pays for the others. A payment covering several members is stored as one
+// payments row per covered member, all with the same payer and with
+// split_group_id set to the id of the first row.
+const createHouseholdsTable = `
+CREATE TABLE IF NOT EXISTS households (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    payer_member_id INTEGER NOT NULL REFERENCES members(id),
+    created_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+DROP TRIGGER IF EXISTS households_set_updated_at ON households;
+CREATE TRIGGER households_set_updated_at BEFORE UPDATE ON households
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+ALTER TABLE members ADD COLUMN IF NOT EXISTS household_id INTEGER REFERENCES households(id);
+CREATE INDEX IF NOT EXISTS idx_members_household_id ON members(household_id);
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS payer_member_id INTEGER REFERENCES members(id);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS payer_name VARCHAR(255);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS split_group_id INTEGER REFERENCES payments(id);
+
+UPDATE payments SET payer_member_id = member_id, payer_name = member_name WHERE payer_member_id IS NULL;
+
+ALTER TABLE payments ALTER COLUMN payer_member_id SET NOT NULL;
+ALTER TABLE payments ALTER COLUMN payer_name SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_payments_payer_member_id ON payments(payer_member_id);
+CREATE INDEX IF NOT EXISTS idx_payments_split_group_id ON payments(split_group_id);
+`
+
+// createMemberCustomFields lets the organisation define extra member fields
+// (text, number, date or a fixed list of options) and tag members.
+const createMemberCustomFields = `
+CREATE TABLE IF NOT EXISTS member_field_definitions (
+    id SERIAL PRIMARY KEY,
+    key VARCHAR(50) UNIQUE NOT NULL,
+    label VARCHAR(100) NOT NULL,
+    field_type VARCHAR(20) NOT NULL CHECK (field_type IN ('text', 'number', 'date', 'enum')),
+    options TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+DROP TRIGGER IF EXISTS member_field_definitions_set_updated_at ON member_field_definitions;
+CREATE TRIGGER member_field_definitions_set_updated_at BEFORE UPDATE ON member_field_definitions
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE IF NOT EXISTS member_field_values (
+    member_id INTEGER NOT NULL REFERENCES members(id),
+    field_id INTEGER NOT NULL REFERENCES member_field_definitions(id) ON DELETE CASCADE,
+    value TEXT NOT NULL,
+    PRIMARY KEY (member_id, field_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_member_field_values_field_id ON member_field_values(field_id, value);
+
+CREATE TABLE IF NOT EXISTS member_tags (
+    member_id INTEGER NOT NULL REFERENCES members(id),
+    tag VARCHAR(50) NOT NULL,
+    PRIMARY KEY (member_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_member_tags_tag ON member_tags(tag);
+`
+
+// addPaymentAmountCheck rejects non-positive payment amounts. Earlier rows
+// are not checked, so a database holding such payments still migrates.
+const addPaymentAmountCheck = `
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'payments_amount_positive') THEN
+        ALTER TABLE payments ADD CONSTRAINT payments_amount_positive CHECK (amount > 0) NOT VALID;
+    END IF;
+END $$;
+`
+
+// createPaymentAllocations records which contribution months each payment
+// pays for, so arrears and advance payments count towards the months they
+// cover rather than the month they were received. Payments made before
+// allocations existed are counted towards the month they were received.
+const createPaymentAllocations = `
+CREATE TABLE IF NOT EXISTS payment_allocations (
+    id SERIAL PRIMARY KEY,
+    payment_id INTEGER NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    period DATE NOT NULL CHECK (EXTRACT(DAY FROM period) = 1),
+    amount DECIMAL(10, 2) NOT NULL CHECK (amount > 0),
+    UNIQUE (payment_id, period)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_period ON payment_allocations(period);
+
+INSERT INTO payment_allocations (payment_id, period, amount)
+SELECT p.id, date_trunc('month', p.payment_date)::date, p.amount
+FROM payments p
+WHERE p.amount > 0 AND NOT EXISTS (SELECT 1 FROM payment_allocations a WHERE a.payment_id = p.id);
+`
+
+// addPaymentModes records how each payment arrived. Existing payments were
+// cash. Non-cash payments carry a transaction reference, unique per mode
+// except that the rows of one split share their first row's reference, and
+// cheques move through received, deposited and then cleared or bounced.
+const addPaymentModes = `
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS payment_mode VARCHAR(20) NOT NULL DEFAULT 'cash';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS reference_no VARCHAR(100);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS cheque_status VARCHAR(20);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS cheque_status_at TIMESTAMP;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'payments_payment_mode_check') THEN
+        ALTER TABLE payments ADD CONSTRAINT payments_payment_mode_check
+            CHECK (payment_mode IN ('cash', 'upi', 'bank_transfer', 'cheque'));
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'payments_cheque_status_check') THEN
+        ALTER TABLE payments ADD CONSTRAINT payments_cheque_status_check
+            CHECK ((payment_mode = 'cheque') = (cheque_status IS NOT NULL)
+                AND cheque_status IN ('received', 'deposited', 'cleared', 'bounced'));
+    END IF;
+END $$;
+
+-- A bounced cheque can be handed in again under the same number.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'idx_payments_reference_no' AND indexdef NOT LIKE '%bounced%') THEN
+        DROP INDEX idx_payments_reference_no;
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_reference_no ON payments(payment_mode, reference_no)
+    WHERE reference_no IS NOT NULL AND (split_group_id IS NULL OR split_group_id = id)
+        AND cheque_status IS DISTINCT FROM 'bounced';
+CREATE INDEX IF NOT EXISTS idx_payments_cheque_status ON payments(cheque_status) WHERE cheque_status IS NOT NULL;
+`
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
+)
+
+// CreateAPIKey mints a key for machine-to-machine integrations. The key acts
+// as user_id (the caller by default) with only the listed capabilities, which
+// both the caller and that user must hold. The plaintext key is only
+// returned in this response.
+func (h *Handlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name          string   `json:"name"`
+		UserID        int      `json:"user_id"`
+		Capabilities  []string `json:"capabilities"`
+		AllowedIPs    []string `json:"allowed_ips"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		sendJSONError(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Capabilities) == 0 {
+		sendJSONError(w, "At least one capability is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.ExpiresInDays < 0 {
+		sendJSONError(w, "expires_in_days cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	callerID := getUserIDFromRequest(r)
+	if req.UserID == 0 {
+		req.UserID = callerID
+	}
+
+	var role string
+	var active bool
+	err := h.DB.QueryRow("SELECT user_type, is_active FROM users WHERE id = $1", req.UserID).Scan(&role, &active)
+	if err == sql.ErrNoRows || (err == nil && !active) {
+		sendJSONError(w, "User not found or disabled", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching API key user: %v", err)
+		sendJSONError(w, "Failed to create API key. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if req.UserID != callerID {
+		if !middleware.HasCapability(r, middleware.CapUsersManage) {
+			sendJSONError(w, "You can only create API keys for yourself", http.StatusForbidden)
+			return
+		}
+		if !h.authorizeUserManagement(w, r, role) {
+			return
+		}
+	}
+
+	capabilities, ok := validateRoleCapabilities(w, r, req.Capabilities, nil)
+	if !ok {
+		return
+	}
+
+	roleCapabilities, err := middleware.CapabilitiesForRole(h.DB, role)
+	if err != nil {
+		log.Printf("Error loading role capabilities: %v", err)
+		sendJSONError(w, "Failed to create API key. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	for _, c := range capabilities {
+		if !containsString(roleCapabilities, c) {
+			sendJSONError(w, "The key's user does not have capability: "+c, http.StatusBadRequest)
+			return
+		}
+	}
+
+	allowedIPs := make([]string, 0, len(req.AllowedIPs))
+	for _, entry := range req.AllowedIPs {
+		normalized, ok := normalizeIPAllowlistEntry(entry)
+		if !ok {
+			sendJSONError(w, "Invalid IP address or CIDR range: "+entry, http.StatusBadRequest)
+			return
+		}
+		allowedIPs = append(allowedIPs, normalized)
+	}
+
+	secret, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		sendJSONError(w, "Failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+
+	key := models.APIKey{
+		Name:         req.Name,
+		Key:          middleware.APIKeyPrefix + secret,
+		UserID:       req.UserID,
+		Capabilities: capabilities,
+		AllowedIPs:   allowedIPs,
+		CreatedBy:    callerID,
+	}
+	key.KeyPrefix = key.Key[:len(middleware.APIKeyPrefix)+8]
+
+	var expiresIn interface{}
+	if req.ExpiresInDays > 0 {
+		expiresIn = int((time.Duration(req.ExpiresInDays) * 24 * time.Hour).Seconds())
+	}
+
+	err = h.DB.QueryRow(
+		`INSERT INTO api_keys (name, key_prefix, key_hash, user_id, capabilities, allowed_ips, expires_at, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP + $7::integer * INTERVAL '1 second', $8)
+		 RETURNING id, expires_at, created_at`,
+		key.Name, key.KeyPrefix, middleware.HashToken(key.Key), key.UserID,
+		pq.Array(key.Capabilities), pq.Array(key.AllowedIPs), expiresIn, key.CreatedBy,
+	).Scan(&key.ID, &key.ExpiresAt, &key.CreatedAt)
+
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		sendJSONError(w, "Failed to create API key. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, key, http.StatusCreated)
+}
+
+func (h *Handlers) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT k.id, k.name, k.key_prefix, k.user_id, u.username, k.capabilities, k.allowed_ips,
+			k.expires_at, k.revoked_at, k.last_used_at, k.last_used_ip, k.created_by, k.created_at
+		FROM api_keys k
+		LEFT JOIN users u ON k.user_id = u.id
+		ORDER BY k.created_at DESC
+	`
+
+	rows, err := h.DB.Query(query)
+	if err != nil {
+		log.Printf("Error fetching API keys: %v", err)
+		sendJSONError(w, "Failed to fetch API keys. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		var k models.APIKey
+		err := rows.Scan(
+			&k.ID, &k.Name, &k.KeyPrefix, &k.UserID, &k.Username, pq.Array(&k.Capabilities), pq.Array(&k.AllowedIPs),
+			&k.ExpiresAt, &k.RevokedAt, &k.LastUsedAt, &k.LastUsedIP, &k.CreatedBy, &k.CreatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	sendJSONResponse(w, keys, http.StatusOK)
+}
+
+// RevokeAPIKey disables a key immediately.
+func (h *Handlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.DB.Exec(
+		"UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL",
+		keyID,
+	)
+	if err != nil {
+		log.Printf("Error revoking API key: %v", err)
+		sendJSONError(w, "Failed to revoke API key. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		sendJSONError(w, "API key not found or already revoked", http.StatusNotFound)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"id":      keyID,
+		"revoked": true,
+	}, http.StatusOK)
+}
+
+// GetAPIKeyUsage lists the most recent requests made with a key.
+func (h *Handlers) GetAPIKeyUsage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	query := `
+		SELECT id, method, path, status_code, ip_address, created_at
+		FROM api_key_usage
+		WHERE api_key_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := h.DB.Query(query, keyID, limit)
+	if err != nil {
+		log.Printf("Error fetching API key usage: %v", err)
+		sendJSONError(w, "Failed to fetch API key usage. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var usage []models.APIKeyUsage
+	for rows.Next() {
+		var u models.APIKeyUsage
+		if err := rows.Scan(&u.ID, &u.Method, &u.Path, &u.StatusCode, &u.IPAddress, &u.CreatedAt); err != nil {
+			continue
+		}
+		usage = append(usage, u)
+	}
+
+	sendJSONResponse(w, usage, http.StatusOK)
+}
+
+// normalizeIPAllowlistEntry accepts an IP address or CIDR range and returns
+// it in canonical form.
+func normalizeIPAllowlistEntry(entry string) (string, bool) {
+	entry = strings.TrimSpace(entry)
+	if _, network, err := net.ParseCIDR(entry); err == nil {
+		return network.String(), true
+	}
+	if ip := net.ParseIP(entry); ip != nil {
+		return ip.String(), true
+	}
+	return "", false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 
@@ -27,47 +29,68 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 	var userID int
 	var userType string
 	var passwordHash string
+	var totpEnabled bool
 
 	err := h.DB.QueryRow(
-		"SELECT id, user_type, password_hash FROM users WHERE username = $1",
+		"SELECT id, user_type, password_hash, totp_enabled FROM users WHERE username = $1",
 		req.Username,
-	).Scan(&userID, &userType, &passwordHash)
+	).Scan(&userID, &userType, &passwordHash, &totpEnabled)
 
-	if err == sql.ErrNoRows {
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error during login: %v", err)
 		sendJSONError(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	if err != nil {
-		log.Printf("Error during login: %v", err)
+	if h.rejectThrottledLogin(w, r, userID, req.Username) {
+		return
+	}
+
+	if err == sql.ErrNoRows {
+		h.recordLoginAttempt(r, 0, req.Username, false, loginReasonUnknownUser)
 		sendJSONError(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+		h.registerLoginFailure(userID)
+		h.recordLoginAttempt(r, userID, req.Username, false, loginReasonInvalidPassword)
 		sendJSONError(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	token, err := middleware.GenerateToken(userID, userType)
+	// Accounts with two-factor authentication get a challenge token instead
+	// of a session; see VerifyTwoFactor.
+	challenge, err := h.loginChallenge(userID, userType, totpEnabled)
 	if err != nil {
+		log.Printf("Error creating login challenge: %v", err)
+		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	if challenge != nil {
+		sendJSONResponse(w, challenge, http.StatusOK)
+		return
+	}
+
+	h.resetLoginFailures(userID)
+	h.recordLoginAttempt(r, userID, req.Username, true, loginReasonSuccess)
+
+	session, err := h.issueSession(r, userID, userType)
+	if err != nil {
+		log.Printf("Error issuing session: %v", err)
 		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	sendJSONResponse(w, map[string]interface{}{
-		"token":     token,
-		"user_id":   userID,
-		"user_type": userType,
-	}, http.StatusOK)
+	sendJSONResponse(w, session, http.StatusOK)
 }
 
 func (h *Handlers) Signup(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-		UserType string `json:"user_type"`
+		Username       string `json:"username"`
+		Email          string `json:"email"`
+		Password       string `json:"password"`
+		InvitationCode string `json:"invitation_code"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -75,8 +98,18 @@ func (h *Handlers) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.UserType != "master_admin" && req.UserType != "account_admin" {
-		sendJSONError(w, "Invalid user type", http.StatusBadRequest)
+	if req.Username == "" || req.Email == "" || req.Password == "" {
+		sendJSONError(w, "Username, email and password are required", http.StatusBadRequest)
+		return
+	}
+
+	if req.InvitationCode == "" {
+		sendJSONError(w, "Invitation code is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePassword(req.Password); err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -86,47 +119,208 @@ func (h *Handlers) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var userID int
-	err = h.DB.QueryRow(
-		"INSERT INTO users (username, email, password_hash, user_type) VALUES ($1, $2, $3, $4) RETURNING id",
-		req.Username, req.Email, string(passwordHash), req.UserType,
-	).Scan(&userID)
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting signup transaction: %v", err)
+		sendJSONError(w, "Failed to create account. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the invitation so two signups cannot redeem the same code.
+	var invitationID int
+	var invitedEmail, userType string
+	var used, expired bool
+	err = tx.QueryRow(`
+		SELECT id, email, user_type, used_at IS NOT NULL OR revoked_at IS NOT NULL, expires_at < CURRENT_TIMESTAMP
+		FROM invitations
+		WHERE code_hash = $1
+		FOR UPDATE
+	`, middleware.HashToken(req.InvitationCode)).Scan(&invitationID, &invitedEmail, &userType, &used, &expired)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Invalid invitation code", http.StatusBadRequest)
+		return
+	}
 
 	if err != nil {
-		// Log the actual error for debugging
-		log.Printf("Error creating user: %v", err)
-
-		// Check for PostgreSQL unique constraint violation
-		if pqErr, ok := err.(*pq.Error); ok {
-			if pqErr.Code == "23505" { // unique_violation
-				// Check which constraint was violated
-				constraintName := pqErr.Constraint
-				if strings.Contains(constraintName, "username") {
-					sendJSONError(w, "Username already exists. Please choose a different username.", http.StatusConflict)
-					return
-				} else if strings.Contains(constraintName, "email") {
-					sendJSONError(w, "Email already exists. Please use a different email address.", http.StatusConflict)
-					return
-				}
-			}
-		}
+		log.Printf("Error fetching invitation: %v", err)
+		sendJSONError(w, "Failed to create account. Please try again later.", http.StatusInternalServerError)
+		return
+	}
 
-		// Generic error for other database issues
+	if used {
+		sendJSONError(w, "Invitation code is no longer valid", http.StatusBadRequest)
+		return
+	}
+
+	if expired {
+		sendJSONError(w, "Invitation code has expired", http.StatusBadRequest)
+		return
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(req.Email), invitedEmail) {
+		sendJSONError(w, "Invitation code was issued for a different email address", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := insertUser(tx, req.Username, req.Email, string(passwordHash), userType)
+	if err != nil {
+		sendUserCreateError(w, err)
+		return
+	}
+
+	_, err = tx.Exec(
+		"UPDATE invitations SET used_at = CURRENT_TIMESTAMP, used_by = $1 WHERE id = $2",
+		userID, invitationID,
+	)
+	if err != nil {
+		log.Printf("Error redeeming invitation: %v", err)
+		sendJSONError(w, "Failed to create account. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing signup: %v", err)
 		sendJSONError(w, "Failed to create account. Please try again later.", http.StatusInternalServerError)
 		return
 	}
 
-	token, err := middleware.GenerateToken(userID, req.UserType)
+	session, err := h.issueSession(r, userID, userType)
 	if err != nil {
+		log.Printf("Error issuing session: %v", err)
 		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	sendJSONResponse(w, map[string]interface{}{
-		"token":     token,
-		"user_id":   userID,
-		"user_type": req.UserType,
-	}, http.StatusOK)
+	sendJSONResponse(w, session, http.StatusOK)
+}
+
+// Bootstrap creates the very first master admin. It is only enabled when the
+// BOOTSTRAP_TOKEN environment variable is set, requires that token in the
+// request, and refuses to run once any master admin exists.
+func (h *Handlers) Bootstrap(w http.ResponseWriter, r *http.Request) {
+	bootstrapToken := os.Getenv("BOOTSTRAP_TOKEN")
+	if bootstrapToken == "" {
+		sendJSONError(w, "Bootstrap is disabled", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Username       string `json:"username"`
+		Email          string `json:"email"`
+		Password       string `json:"password"`
+		BootstrapToken string `json:"bootstrap_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(req.BootstrapToken), []byte(bootstrapToken)) != 1 {
+		sendJSONError(w, "Invalid bootstrap token", http.StatusForbidden)
+		return
+	}
+
+	if req.Username == "" || req.Email == "" || req.Password == "" {
+		sendJSONError(w, "Username, email and password are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePassword(req.Password); err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		sendJSONError(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting bootstrap transaction: %v", err)
+		sendJSONError(w, "Failed to create account. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Serialise concurrent bootstrap attempts.
+	if _, err := tx.Exec("LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		log.Printf("Error locking users table: %v", err)
+		sendJSONError(w, "Failed to create account. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	var masterAdmins int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE user_type = $1", middleware.RoleMasterAdmin).Scan(&masterAdmins); err != nil {
+		log.Printf("Error counting master admins: %v", err)
+		sendJSONError(w, "Failed to create account. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if masterAdmins > 0 {
+		sendJSONError(w, "Bootstrap has already been completed", http.StatusConflict)
+		return
+	}
+
+	userID, err := insertUser(tx, req.Username, req.Email, string(passwordHash), middleware.RoleMasterAdmin)
+	if err != nil {
+		sendUserCreateError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing bootstrap: %v", err)
+		sendJSONError(w, "Failed to create account. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Bootstrap master admin %q created", req.Username)
+
+	session, err := h.issueSession(r, userID, middleware.RoleMasterAdmin)
+	if err != nil {
+		log.Printf("Error issuing session: %v", err)
+		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, session, http.StatusOK)
+}
+
+func insertUser(q queryRower, username, email, passwordHash, userType string) (int, error) {
+	var userID int
+	err := q.QueryRow(
+		"INSERT INTO users (username, email, password_hash, user_type) VALUES ($1, $2, $3, $4) RETURNING id",
+		username, strings.TrimSpace(email), passwordHash, userType,
+	).Scan(&userID)
+	return userID, err
+}
+
+// sendUserCreateError maps a failed users INSERT to a response.
+func sendUserCreateError(w http.ResponseWriter, err error) {
+	// Log the actual error for debugging
+	log.Printf("Error creating user: %v", err)
+
+	// Check for PostgreSQL unique constraint violation
+	if pqErr, ok := err.(*pq.Error); ok {
+		if pqErr.Code == "23505" { // unique_violation
+			// Check which constraint was violated
+			constraintName := pqErr.Constraint
+			if strings.Contains(constraintName, "username") {
+				sendJSONError(w, "Username already exists. Please choose a different username.", http.StatusConflict)
+				return
+			} else if strings.Contains(constraintName, "email") {
+				sendJSONError(w, "Email already exists. Please use a different email address.", http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	// Generic error for other database issues
+	sendJSONError(w, "Failed to create account. Please try again later.", http.StatusInternalServerError)
 }
 
 func getUserIDFromRequest(r *http.Request) int {
@@ -141,3 +335,12 @@ func getUserIDFromRequest(r *http.Request) int {
 func getUserTypeFromRequest(r *http.Request) string {
 	return r.Header.Get("X-User-Type")
 }
+
+func getMemberIDFromRequest(r *http.Request) int {
+	memberID, _ := strconv.Atoi(r.Header.Get("X-Member-ID"))
+	return memberID
+}
+
+func getTokenIDFromRequest(r *http.Request) string {
+	return r.Header.Get("X-Token-ID")
+}
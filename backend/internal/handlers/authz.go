@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/khidmat/backend/internal/middleware"
+)
+
+// scopeAdminID returns the admin ID that queries must be restricted to, or 0
+// when the caller's role grants access to every admin's records. Queries use
+// it as `($n = 0 OR admin_id = $n)`.
+func scopeAdminID(r *http.Request) int {
+	if middleware.HasCapability(r, middleware.CapRecordsAll) {
+		return 0
+	}
+
+	adminID := getUserIDFromRequest(r)
+	if adminID == 0 {
+		// Never fall back to global access for an unidentified caller.
+		return -1
+	}
+	return adminID
+}
+
+// authorizeMember checks that the member exists, has not been deleted and
+// that the caller may act on it. On failure it writes the error response and
+// returns false.
+func (h *Handlers) authorizeMember(w http.ResponseWriter, r *http.Request, memberID int) bool {
+	var adminID int
+	err := h.DB.QueryRow("SELECT admin_id FROM members WHERE id = $1 AND deleted_at IS NULL", memberID).Scan(&adminID)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Member not found", http.StatusNotFound)
+		return false
+	}
+
+	if err != nil {
+		log.Printf("Error fetching member for authorization: %v", err)
+		sendJSONError(w, "Failed to fetch member. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+
+	// Foreign members are reported as missing so their existence is not leaked.
+	if scope := scopeAdminID(r); scope != 0 && scope != adminID {
+		sendJSONError(w, "Member not found", http.StatusNotFound)
+		return false
+	}
+
+	return true
+}
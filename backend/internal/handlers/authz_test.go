@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/khidmat/backend/internal/middleware"
+)
+
+func TestScopeAdminID(t *testing.T) {
+	tests := []struct {
+		name         string
+		userID       string
+		capabilities string
+		want         int
+	}{
+		{"records.all", "2", middleware.CapMembersRead + " " + middleware.CapRecordsAll, 0},
+		{"own records", "2", middleware.CapMembersRead, 2},
+		{"unidentified caller", "", middleware.CapMembersRead, -1},
+		{"records.all without an id", "", middleware.CapRecordsAll, 0},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/members", nil)
+		r.Header.Set("X-User-ID", tt.userID)
+		r.Header.Set("X-Capabilities", tt.capabilities)
+		if got := scopeAdminID(r); got != tt.want {
+			t.Errorf("%s: scopeAdminID = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorizeMember(t *testing.T) {
+	tests := []struct {
+		name         string
+		userID       string
+		capabilities string
+		owner        int
+		lookupErr    error
+		want         bool
+		wantCode     int
+	}{
+		{"own member", "2", middleware.CapMembersWrite, 2, nil, true, http.StatusOK},
+		{"another admin's member", "3", middleware.CapMembersWrite, 2, nil, false, http.StatusNotFound},
+		{"another admin's member with records.all", "3", middleware.CapRecordsAll, 2, nil, true, http.StatusOK},
+		{"unidentified caller", "", middleware.CapMembersWrite, 2, nil, false, http.StatusNotFound},
+		{"missing or deleted member", "2", middleware.CapRecordsAll, 0, sql.ErrNoRows, false, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			query := mock.ExpectQuery("SELECT admin_id FROM members WHERE id = \\$1 AND deleted_at IS NULL").WithArgs(7)
+			if tt.lookupErr != nil {
+				query.WillReturnError(tt.lookupErr)
+			} else {
+				query.WillReturnRows(sqlmock.NewRows([]string{"admin_id"}).AddRow(tt.owner))
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/members/7", nil)
+			r.Header.Set("X-User-ID", tt.userID)
+			r.Header.Set("X-Capabilities", tt.capabilities)
+			w := httptest.NewRecorder()
+
+			if got := (&Handlers{DB: db}).authorizeMember(w, r, 7); got != tt.want || w.Code != tt.wantCode {
+				t.Errorf("authorizeMember = %v with status %d, want %v with status %d", got, w.Code, tt.want, tt.wantCode)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
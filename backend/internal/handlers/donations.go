@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -41,33 +42,55 @@ func (h *Handlers) CreateDonation(w http.ResponseWriter, r *http.Request) {
 	sendJSONResponse(w, donation, http.StatusCreated)
 }
 
-func (h *Handlers) GetDonations(w http.ResponseWriter, r *http.Request) {
-	query := `
-		SELECT d.id, d.beneficiary_name, d.contact_no, d.amount, d.admin_id, u.username, d.donation_date, d.created_at
-		FROM donations d
-		LEFT JOIN users u ON d.admin_id = u.id
-		ORDER BY d.created_at DESC
-	`
+var donationListSpec = listSpec{
+	from:          `donations d LEFT JOIN users u ON d.admin_id = u.id`,
+	columns:       `d.id, d.beneficiary_name, d.contact_no, d.amount, d.admin_id, u.username, d.donation_date, d.created_at`,
+	idColumn:      "d.id",
+	searchColumns: []string{"d.beneficiary_name", "d.contact_no", "u.username"},
+	adminColumn:   "d.admin_id",
+	dateColumn:    "d.donation_date",
+	amountColumn:  "d.amount",
+	sortFields: map[string]listSortField{
+		"beneficiary_name": {"d.beneficiary_name", "text"},
+		"amount":           {"d.amount", "numeric"},
+		"donation_date":    {"d.donation_date", "timestamp"},
+		"created_at":       {"d.created_at", "timestamp"},
+	},
+	defaultSort: "-created_at",
+}
 
-	rows, err := h.DB.Query(query)
+// GetDonations lists donations a page at a time; parseListQuery documents the
+// parameters.
+func (h *Handlers) GetDonations(w http.ResponseWriter, r *http.Request) {
+	lq, err := parseListQuery(r, &donationListSpec)
 	if err != nil {
-		log.Printf("Error fetching donations: %v", err)
-		sendJSONError(w, "Failed to fetch donations. Please try again later.", http.StatusInternalServerError)
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
 
-	var donations []models.Donation
-	for rows.Next() {
+	scope := lq.arg(scopeAdminID(r))
+	lq.where("(" + scope + " = 0 OR d.admin_id = " + scope + ")")
+
+	donations := []models.Donation{}
+	total, next, err := lq.fetch(h.DB, func(row rowScanner) (int, error) {
 		var d models.Donation
-		err := rows.Scan(
-			&d.ID, &d.BeneficiaryName, &d.ContactNo, &d.Amount, &d.AdminID, &d.AdminName, &d.DonationDate, &d.CreatedAt,
+		var adminName sql.NullString
+		err := row.Scan(
+			&d.ID, &d.BeneficiaryName, &d.ContactNo, &d.Amount, &d.AdminID, &adminName, &d.DonationDate, &d.CreatedAt,
 		)
 		if err != nil {
-			continue
+			return 0, err
 		}
+		d.AdminName = adminName.String
 		donations = append(donations, d)
+		return d.ID, nil
+	})
+
+	if err != nil {
+		log.Printf("Error fetching donations: %v", err)
+		sendJSONError(w, "Failed to fetch donations. Please try again later.", http.StatusInternalServerError)
+		return
 	}
 
-	sendJSONResponse(w, donations, http.StatusOK)
+	sendJSONResponse(w, listPage{Items: donations, Total: total, NextCursor: next}, http.StatusOK)
 }
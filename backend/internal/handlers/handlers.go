@@ -1,15 +1,21 @@
 package handlers
 
-import "database/sql"
+import (
+	"database/sql"
+
+	"github.com/khidmat/backend/internal/notify"
+	"github.com/khidmat/backend/internal/oidc"
+)
 
 type Handlers struct {
-	DB *sql.DB
-}
+	DB       *sql.DB
+	Notifier notify.Notifier
+	SMS      notify.SMSSender
 
-func NewHandlers(db *sql.DB) *Handlers {
-	return &Handlers{DB: db}
+	// OIDC is nil when single sign-on is not configured.
+	OIDC *oidc.Provider
 }
 
-
-
-
+func NewHandlers(db *sql.DB, notifier notify.Notifier, sms notify.SMSSender, oidcProvider *oidc.Provider) *Handlers {
+	return &Handlers{DB: db, Notifier: notifier, SMS: sms, OIDC: oidcProvider}
+}
@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
+)
+
+const maxHouseholdMembers = 50
+
+type householdRequest struct {
+	Name          *string `json:"name"`
+	PayerMemberID *int    `json:"payer_member_id"`
+	MemberIDs     []int   `json:"member_ids"`
+}
+
+// GetHouseholds lists the households whose payer the caller can see, with
+// their members.
+func (h *Handlers) GetHouseholds(w http.ResponseWriter, r *http.Request) {
+	households, err := h.fetchHouseholds(scopeAdminID(r), 0)
+	if err != nil {
+		log.Printf("Error fetching households: %v", err)
+		sendJSONError(w, "Failed to fetch households. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, households, http.StatusOK)
+}
+
+func (h *Handlers) GetHousehold(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	householdID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid household ID", http.StatusBadRequest)
+		return
+	}
+
+	h.sendHousehold(w, r, householdID, http.StatusOK)
+}
+
+// CreateHousehold groups member_ids under name, with payer_member_id paying
+// for them. The payer is always a member of the household.
+func (h *Handlers) CreateHousehold(w http.ResponseWriter, r *http.Request) {
+	var req householdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		sendJSONError(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	if req.PayerMemberID == nil {
+		sendJSONError(w, "payer_member_id is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting household creation: %v", err)
+		sendJSONError(w, "Failed to create household. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var householdID int
+	err = tx.QueryRow(
+		"INSERT INTO households (name, payer_member_id, created_by) VALUES ($1, $2, $3) RETURNING id",
+		strings.TrimSpace(*req.Name), *req.PayerMemberID, getUserIDFromRequest(r),
+	).Scan(&householdID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			sendJSONError(w, "Member "+strconv.Itoa(*req.PayerMemberID)+" not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error creating household: %v", err)
+		sendJSONError(w, "Failed to create household. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.setHouseholdMembers(w, r, tx, householdID, *req.PayerMemberID, req.MemberIDs) {
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error creating household: %v", err)
+		sendJSONError(w, "Failed to create household. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	h.sendHousehold(w, r, householdID, http.StatusCreated)
+}
+
+// UpdateHousehold renames a household, changes its payer and/or replaces its
+// members with member_ids.
+func (h *Handlers) UpdateHousehold(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	householdID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid household ID", http.StatusBadRequest)
+		return
+	}
+
+	var req householdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		sendJSONError(w, "Name cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting household update: %v", err)
+		sendJSONError(w, "Failed to update household. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	payerID, ok := h.lockHousehold(w, r, tx, householdID)
+	if !ok {
+		return
+	}
+	if req.PayerMemberID != nil {
+		payerID = *req.PayerMemberID
+	}
+
+	memberIDs := req.MemberIDs
+	if memberIDs == nil {
+		rows, err := tx.Query("SELECT id FROM members WHERE household_id = $1", householdID)
+		if err != nil {
+			log.Printf("Error fetching household members: %v", err)
+			sendJSONError(w, "Failed to update household. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err == nil {
+				memberIDs = append(memberIDs, id)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			log.Printf("Error fetching household members: %v", err)
+			sendJSONError(w, "Failed to update household. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var name interface{}
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+	}
+	_, err = tx.Exec(
+		"UPDATE households SET name = COALESCE($1, name), payer_member_id = $2 WHERE id = $3",
+		name, payerID, householdID,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			sendJSONError(w, "Member "+strconv.Itoa(payerID)+" not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error updating household: %v", err)
+		sendJSONError(w, "Failed to update household. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.setHouseholdMembers(w, r, tx, householdID, payerID, memberIDs) {
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error updating household: %v", err)
+		sendJSONError(w, "Failed to update household. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	h.sendHousehold(w, r, householdID, http.StatusOK)
+}
+
+// DeleteHousehold ungroups a household's members. Their payments, including
+// those made by the payer on their behalf, are kept.
+func (h *Handlers) DeleteHousehold(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	householdID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid household ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting household deletion: %v", err)
+		sendJSONError(w, "Failed to delete household. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, ok := h.lockHousehold(w, r, tx, householdID); !ok {
+		return
+	}
+
+	_, err = tx.Exec("UPDATE members SET household_id = NULL WHERE household_id = $1", householdID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM households WHERE id = $1", householdID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error deleting household: %v", err)
+		sendJSONError(w, "Failed to delete household. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"id":      householdID,
+		"deleted": true,
+	}, http.StatusOK)
+}
+
+// lockHousehold locks a household the caller can see and returns its payer.
+// On failure it writes the error response and returns false.
+func (h *Handlers) lockHousehold(w http.ResponseWriter, r *http.Request, tx *sql.Tx, householdID int) (int, bool) {
+	var payerID int
+	err := tx.QueryRow(
+		`SELECT hh.payer_member_id
+		 FROM households hh
+		 INNER JOIN members p ON p.id = hh.payer_member_id
+		 WHERE hh.id = $1 AND ($2 = 0 OR p.admin_id = $2)
+		 FOR UPDATE OF hh`,
+		householdID, scopeAdminID(r),
+	).Scan(&payerID)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Household not found", http.StatusNotFound)
+		return 0, false
+	}
+
+	if err != nil {
+		log.Printf("Error fetching household: %v", err)
+		sendJSONError(w, "Failed to fetch household. Please try again later.", http.StatusInternalServerError)
+		return 0, false
+	}
+
+	return payerID, true
+}
+
+// setHouseholdMembers makes memberIDs, plus the payer, the members of the
+// household. Each must be a member the caller can see and not already in
+// another household. On failure it writes the error response and returns
+// false.
+func (h *Handlers) setHouseholdMembers(w http.ResponseWriter, r *http.Request, tx *sql.Tx, householdID, payerID int, memberIDs []int) bool {
+	seen := map[int]bool{payerID: true}
+	ids := []int{payerID}
+	for _, id := range memberIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxHouseholdMembers {
+		sendJSONError(w, "A household can have at most "+strconv.Itoa(maxHouseholdMembers)+" members", http.StatusBadRequest)
+		return false
+	}
+
+	rows, err := tx.Query(
+		`SELECT id, COALESCE(household_id, 0) FROM members
+		 WHERE id = ANY($1) AND deleted_at IS NULL AND ($2 = 0 OR admin_id = $2)
+		 ORDER BY id
+		 FOR UPDATE`,
+		pq.Array(ids), scopeAdminID(r),
+	)
+	if err != nil {
+		log.Printf("Error locking household members: %v", err)
+		sendJSONError(w, "Failed to save household. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+
+	current := make(map[int]int, len(ids))
+	for rows.Next() {
+		var id, household int
+		if err := rows.Scan(&id, &household); err != nil {
+			continue
+		}
+		current[id] = household
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		log.Printf("Error fetching household members: %v", err)
+		sendJSONError(w, "Failed to save household. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+
+	for _, id := range ids {
+		household, ok := current[id]
+		if !ok {
+			sendJSONError(w, "Member "+strconv.Itoa(id)+" not found", http.StatusNotFound)
+			return false
+		}
+		if household != 0 && household != householdID {
+			sendJSONError(w, "Member "+strconv.Itoa(id)+" already belongs to another household", http.StatusConflict)
+			return false
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE members SET household_id = NULL WHERE household_id = $1 AND NOT (id = ANY($2))",
+		householdID, pq.Array(ids),
+	)
+	if err == nil {
+		_, err = tx.Exec("UPDATE members SET household_id = $1 WHERE id = ANY($2)", householdID, pq.Array(ids))
+	}
+	if err != nil {
+		log.Printf("Error saving household members: %v", err)
+		sendJSONError(w, "Failed to save household. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+func (h *Handlers) sendHousehold(w http.ResponseWriter, r *http.Request, householdID, status int) {
+	households, err := h.fetchHouseholds(scopeAdminID(r), householdID)
+	if err != nil {
+		log.Printf("Error fetching household: %v", err)
+		sendJSONError(w, "Failed to fetch household. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if len(households) == 0 {
+		sendJSONError(w, "Household not found", http.StatusNotFound)
+		return
+	}
+
+	sendJSONResponse(w, households[0], status)
+}
+
+// fetchHouseholds returns the households whose payer is within scope (all
+// for 0), or only householdID when it is not 0, with their members.
+func (h *Handlers) fetchHouseholds(scope, householdID int) ([]models.Household, error) {
+	rows, err := h.DB.Query(`
+		SELECT hh.id, hh.name, hh.payer_member_id, p.name, hh.created_at, hh.updated_at
+		FROM households hh
+		INNER JOIN members p ON p.id = hh.payer_member_id
+		WHERE ($1 = 0 OR p.admin_id = $1) AND ($2 = 0 OR hh.id = $2)
+		ORDER BY hh.name, hh.id
+	`, scope, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	households := []models.Household{}
+	index := make(map[int]int)
+	var ids []int
+	for rows.Next() {
+		hh := models.Household{Members: []models.Member{}}
+		if err := rows.Scan(&hh.ID, &hh.Name, &hh.PayerMemberID, &hh.PayerName, &hh.CreatedAt, &hh.UpdatedAt); err != nil {
+			continue
+		}
+		index[hh.ID] = len(households)
+		ids = append(ids, hh.ID)
+		households = append(households, hh)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return households, nil
+	}
+
+	memberRows, err := h.DB.Query(
+		"SELECT "+memberColumns+" FROM "+memberFrom+" WHERE m.household_id = ANY($1) AND m.deleted_at IS NULL ORDER BY m.name, m.id",
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+
+	for memberRows.Next() {
+		var m models.Member
+		if err := scanMember(memberRows, &m); err != nil || m.HouseholdID == nil {
+			continue
+		}
+		hh := &households[index[*m.HouseholdID]]
+		hh.Members = append(hh.Members, m)
+	}
+
+	return households, memberRows.Err()
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/models"
+)
+
+const (
+	defaultInvitationTTL = 7 * 24 * time.Hour
+	maxInvitationTTL     = 30 * 24 * time.Hour
+)
+
+// CreateInvitation issues a single-use signup code bound to an email and a
+// role. The plaintext code is only returned in this response.
+func (h *Handlers) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email          string `json:"email"`
+		UserType       string `json:"user_type"`
+		ExpiresInHours int    `json:"expires_in_hours"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" || !strings.Contains(req.Email, "@") {
+		sendJSONError(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeRoleAssignment(w, r, req.UserType) {
+		return
+	}
+
+	ttl := defaultInvitationTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if ttl > maxInvitationTTL {
+		sendJSONError(w, "Invitations can be valid for at most 30 days", http.StatusBadRequest)
+		return
+	}
+
+	code, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		sendJSONError(w, "Failed to generate invitation code", http.StatusInternalServerError)
+		return
+	}
+
+	invitation := models.Invitation{
+		Email:     req.Email,
+		UserType:  req.UserType,
+		Code:      code,
+		CreatedBy: getUserIDFromRequest(r),
+	}
+
+	err = h.DB.QueryRow(
+		`INSERT INTO invitations (code_hash, email, user_type, created_by, expires_at)
+		 VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second')
+		 RETURNING id, expires_at, created_at`,
+		middleware.HashToken(code), invitation.Email, invitation.UserType, invitation.CreatedBy, int(ttl.Seconds()),
+	).Scan(&invitation.ID, &invitation.ExpiresAt, &invitation.CreatedAt)
+
+	if err != nil {
+		log.Printf("Error creating invitation: %v", err)
+		sendJSONError(w, "Failed to create invitation. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, invitation, http.StatusCreated)
+}
+
+func (h *Handlers) GetInvitations(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT i.id, i.email, i.user_type, i.created_by, u.username, i.expires_at, i.used_at, i.used_by, i.revoked_at, i.created_at
+		FROM invitations i
+		LEFT JOIN users u ON i.created_by = u.id
+		ORDER BY i.created_at DESC
+	`
+
+	rows, err := h.DB.Query(query)
+	if err != nil {
+		log.Printf("Error fetching invitations: %v", err)
+		sendJSONError(w, "Failed to fetch invitations. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var invitations []models.Invitation
+	for rows.Next() {
+		var i models.Invitation
+		err := rows.Scan(
+			&i.ID, &i.Email, &i.UserType, &i.CreatedBy, &i.CreatedByName, &i.ExpiresAt, &i.UsedAt, &i.UsedBy, &i.RevokedAt, &i.CreatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		invitations = append(invitations, i)
+	}
+
+	sendJSONResponse(w, invitations, http.StatusOK)
+}
+
+// RevokeInvitation invalidates an invitation that has not been used yet.
+func (h *Handlers) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	invitationID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.DB.Exec(
+		"UPDATE invitations SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL",
+		invitationID,
+	)
+	if err != nil {
+		log.Printf("Error revoking invitation: %v", err)
+		sendJSONError(w, "Failed to revoke invitation. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		sendJSONError(w, "Invitation not found or already used", http.StatusNotFound)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"id":      invitationID,
+		"revoked": true,
+	}, http.StatusOK)
+}
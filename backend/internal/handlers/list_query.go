@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// listSortField is a sortable column and the SQL type its cursor value is
+// cast back to.
+type listSortField struct {
+	expr    string
+	sqlType string
+}
+
+// listSpec describes what one list endpoint can filter and sort on. Empty
+// columns mean the filter is not supported.
+type listSpec struct {
+	from          string
+	columns       string
+	idColumn      string
+	searchColumns []string
+	adminColumn   string
+	statusColumn  string
+	dateColumn    string
+	amountColumn  string
+	sortFields    map[string]listSortField
+	defaultSort   string
+}
+
+type listQuery struct {
+	spec       *listSpec
+	conditions []string
+	args       []interface{}
+	sort       string
+	sortField  listSortField
+	desc       bool
+	limit      int
+	cursor     *listCursor
+}
+
+type listCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// listPage is the response body of every list endpoint. Total counts every
+// row matching the filters, not just this page.
+type listPage struct {
+	Items      interface{} `json:"items"`
+	Total      int         `json:"total"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// parseListQuery validates the request's list parameters against spec.
+// Errors are meant for the client. List endpoints share one vocabulary:
+//
+//	q                      free-text search
+//	admin_id               records owned by one admin
+//	status                 active or inactive
+//	from, to               date range (YYYY-MM-DD, inclusive, or RFC 3339)
+//	min_amount, max_amount amount range
+//	sort                   a sortable field, prefixed with "-" for descending
+//	limit, cursor          page size and the next_cursor of the previous page
+//
+// Pagination is keyset based, so pages stay stable while rows are added.
+func parseListQuery(r *http.Request, spec *listSpec) (*listQuery, error) {
+	params := r.URL.Query()
+	q := &listQuery{spec: spec, limit: defaultListLimit}
+
+	if raw := params.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		q.limit = limit
+	}
+
+	sort := params.Get("sort")
+	if sort == "" {
+		sort = spec.defaultSort
+	}
+	q.desc = strings.HasPrefix(sort, "-")
+	q.sort = strings.TrimPrefix(sort, "-")
+	field, ok := spec.sortFields[q.sort]
+	if !ok {
+		return nil, fmt.Errorf("Cannot sort by %q", q.sort)
+	}
+	q.sortField = field
+
+	if search := strings.TrimSpace(params.Get("q")); search != "" {
+		if len(spec.searchColumns) == 0 {
+			return nil, errors.New("Search is not supported here")
+		}
+		p := q.arg("%" + escapeLike(search) + "%")
+		matches := make([]string, len(spec.searchColumns))
+		for i, column := range spec.searchColumns {
+			matches[i] = column + " ILIKE " + p
+		}
+		q.where("(" + strings.Join(matches, " OR ") + ")")
+	}
+
+	if raw := params.Get("admin_id"); raw != "" {
+		adminID, err := strconv.Atoi(raw)
+		if err != nil || spec.adminColumn == "" {
+			return nil, errors.New("Invalid admin_id filter")
+		}
+		q.where(spec.adminColumn + " = " + q.arg(adminID))
+	}
+
+	if status := params.Get("status"); status != "" {
+		if spec.statusColumn == "" || (status != "active" && status != "inactive") {
+			return nil, errors.New("status must be active or inactive")
+		}
+		q.where(spec.statusColumn + " = " + q.arg(status == "active"))
+	}
+
+	for _, bound := range []struct {
+		param string
+		op    string
+	}{{"from", ">="}, {"to", "<"}} {
+		raw := params.Get(bound.param)
+		if raw == "" {
+			continue
+		}
+		if spec.dateColumn == "" {
+			return nil, fmt.Errorf("The %s filter is not supported here", bound.param)
+		}
+		t, dateOnly, err := parseListTime(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a date (YYYY-MM-DD) or an RFC 3339 timestamp", bound.param)
+		}
+		// A date-only upper bound includes the whole day.
+		if bound.param == "to" && dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		if bound.param == "to" && !dateOnly {
+			bound.op = "<="
+		}
+		q.where(spec.dateColumn + " " + bound.op + " " + q.arg(t))
+	}
+
+	for _, bound := range []struct {
+		param string
+		op    string
+	}{{"min_amount", ">="}, {"max_amount", "<="}} {
+		raw := params.Get(bound.param)
+		if raw == "" {
+			continue
+		}
+		amount, err := strconv.ParseFloat(raw, 64)
+		if err != nil || spec.amountColumn == "" {
+			return nil, fmt.Errorf("Invalid %s filter", bound.param)
+		}
+		q.where(spec.amountColumn + " " + bound.op + " " + q.arg(amount))
+	}
+
+	if raw := params.Get("cursor"); raw != "" {
+		cursor, err := decodeListCursor(raw)
+		if err != nil || cursor.Sort != q.sort || cursor.Desc != q.desc {
+			return nil, errors.New("Invalid cursor for this sort order")
+		}
+		q.cursor = cursor
+	}
+
+	return q, nil
+}
+
+// arg adds a query argument and returns its placeholder.
+func (q *listQuery) arg(value interface{}) string {
+	q.args = append(q.args, value)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// where adds a condition; all conditions must hold.
+func (q *listQuery) where(condition string) {
+	q.conditions = append(q.conditions, condition)
+}
+
+func (q *listQuery) whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// fetch counts the matching rows and reads one page. scan is called for
+// each row of the page with a row holding spec.columns and returns the
+// row's id.
+func (q *listQuery) fetch(db *sql.DB, scan func(row rowScanner) (int, error)) (total int, nextCursor string, err error) {
+	err = db.QueryRow("SELECT COUNT(*) FROM "+q.spec.from+q.whereClause(q.conditions), q.args...).Scan(&total)
+	if err != nil {
+		return 0, "", err
+	}
+
+	conditions := q.conditions
+	direction, comparison := "ASC", ">"
+	if q.desc {
+		direction, comparison = "DESC", "<"
+	}
+	if q.cursor != nil {
+		conditions = append(conditions[:len(conditions):len(conditions)], fmt.Sprintf(
+			"(%s, %s) %s (%s::%s, %s)",
+			q.sortField.expr, q.spec.idColumn, comparison,
+			q.arg(q.cursor.Value), q.sortField.sqlType, q.arg(q.cursor.ID),
+		))
+	}
+
+	query := fmt.Sprintf(
+		"SELECT %s, (%s)::text FROM %s%s ORDER BY %s %s, %s %s LIMIT %d",
+		q.spec.columns, q.sortField.expr, q.spec.from, q.whereClause(conditions),
+		q.sortField.expr, direction, q.spec.idColumn, direction, q.limit+1,
+	)
+
+	rows, err := db.Query(query, q.args...)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rows.Close()
+
+	var last *listCursor
+	count := 0
+	for rows.Next() {
+		if count == q.limit {
+			if last != nil {
+				nextCursor = last.encode()
+			}
+			break
+		}
+		count++
+
+		var sortValue string
+		id, err := scan(sortKeyScanner{rows: rows, sortValue: &sortValue})
+		if err != nil {
+			continue
+		}
+		last = &listCursor{Sort: q.sort, Desc: q.desc, Value: sortValue, ID: id}
+	}
+
+	return total, nextCursor, rows.Err()
+}
+
+// fetchAll reads every matching row in sort order, ignoring limit and
+// cursor. scan is called for each row with a row holding spec.columns.
+func (q *listQuery) fetchAll(db *sql.DB, scan func(row rowScanner)) error {
+	direction := "ASC"
+	if q.desc {
+		direction = "DESC"
+	}
+
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s%s ORDER BY %s %s, %s %s",
+		q.spec.columns, q.spec.from, q.whereClause(q.conditions),
+		q.sortField.expr, direction, q.spec.idColumn, direction,
+	)
+
+	rows, err := db.Query(query, q.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		scan(rows)
+	}
+	return rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// sortKeyScanner reads the sort value fetch appends to every row.
+type sortKeyScanner struct {
+	rows      *sql.Rows
+	sortValue *string
+}
+
+func (s sortKeyScanner) Scan(dest ...interface{}) error {
+	return s.rows.Scan(append(dest, s.sortValue)...)
+}
+
+func (c *listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(raw string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+func parseListTime(raw string) (t time.Time, dateOnly bool, err error) {
+	if t, err := time.Parse("2006-01-02", raw); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, raw)
+	return t, false, err
+}
+
+// escapeLike escapes the LIKE wildcards in a search term.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var testListSpec = listSpec{
+	from:          "things t",
+	columns:       "t.id, t.name",
+	idColumn:      "t.id",
+	searchColumns: []string{"t.name", "t.notes"},
+	adminColumn:   "t.admin_id",
+	statusColumn:  "t.is_active",
+	dateColumn:    "t.created_at",
+	amountColumn:  "t.amount",
+	sortFields: map[string]listSortField{
+		"name":       {expr: "t.name", sqlType: "text"},
+		"created_at": {expr: "t.created_at", sqlType: "timestamp"},
+	},
+	defaultSort: "-created_at",
+}
+
+func TestParseListQuery(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		wantSort       string
+		wantDesc       bool
+		wantLimit      int
+		wantConditions []string
+		wantArgs       []interface{}
+	}{
+		{
+			name:      "defaults",
+			wantSort:  "created_at",
+			wantDesc:  true,
+			wantLimit: defaultListLimit,
+		},
+		{
+			name:           "search escapes wildcards",
+			query:          "q=50%25_off&sort=name&limit=10",
+			wantSort:       "name",
+			wantLimit:      10,
+			wantConditions: []string{"(t.name ILIKE $1 OR t.notes ILIKE $1)"},
+			wantArgs:       []interface{}{`%50\%\_off%`},
+		},
+		{
+			name:           "filters",
+			query:          "admin_id=3&status=inactive&min_amount=10.5&max_amount=99",
+			wantSort:       "created_at",
+			wantDesc:       true,
+			wantLimit:      defaultListLimit,
+			wantConditions: []string{"t.admin_id = $1", "t.is_active = $2", "t.amount >= $3", "t.amount <= $4"},
+			wantArgs:       []interface{}{3, false, 10.5, 99.0},
+		},
+		{
+			name:           "date-only upper bound includes the day",
+			query:          "from=2024-01-01&to=2024-01-31",
+			wantSort:       "created_at",
+			wantDesc:       true,
+			wantLimit:      defaultListLimit,
+			wantConditions: []string{"t.created_at >= $1", "t.created_at < $2"},
+			wantArgs:       []interface{}{day("2024-01-01"), day("2024-02-01")},
+		},
+		{
+			name:           "timestamp upper bound is inclusive",
+			query:          "to=2024-01-31T10:00:00Z",
+			wantSort:       "created_at",
+			wantDesc:       true,
+			wantLimit:      defaultListLimit,
+			wantConditions: []string{"t.created_at <= $1"},
+			wantArgs:       []interface{}{time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := parseListQuery(httptest.NewRequest(http.MethodGet, "/api/things?"+tt.query, nil), &testListSpec)
+			if err != nil {
+				t.Fatalf("parseListQuery: %v", err)
+			}
+			if q.sort != tt.wantSort || q.desc != tt.wantDesc || q.limit != tt.wantLimit {
+				t.Errorf("sort %q desc %v limit %d, want %q %v %d", q.sort, q.desc, q.limit, tt.wantSort, tt.wantDesc, tt.wantLimit)
+			}
+			if !reflect.DeepEqual(q.conditions, tt.wantConditions) {
+				t.Errorf("conditions = %q, want %q", q.conditions, tt.wantConditions)
+			}
+			if !reflect.DeepEqual(q.args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", q.args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseListQueryErrors(t *testing.T) {
+	noFilters := listSpec{
+		sortFields:  testListSpec.sortFields,
+		defaultSort: "name",
+	}
+	otherSort := (&listCursor{Sort: "name", Value: "a", ID: 1}).encode()
+
+	tests := []struct {
+		name  string
+		spec  *listSpec
+		query string
+	}{
+		{"limit too large", &testListSpec, "limit=201"},
+		{"limit zero", &testListSpec, "limit=0"},
+		{"unknown sort", &testListSpec, "sort=password"},
+		{"bad status", &testListSpec, "status=paused"},
+		{"bad admin_id", &testListSpec, "admin_id=me"},
+		{"bad date", &testListSpec, "from=01/02/2024"},
+		{"bad amount", &testListSpec, "min_amount=ten"},
+		{"search not supported", &noFilters, "q=x"},
+		{"date filter not supported", &noFilters, "from=2024-01-01"},
+		{"cursor for another sort", &testListSpec, "cursor=" + otherSort},
+		{"malformed cursor", &testListSpec, "cursor=not*base64"},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseListQuery(httptest.NewRequest(http.MethodGet, "/api/things?"+tt.query, nil), tt.spec); err == nil {
+			t.Errorf("%s: parseListQuery(%q) succeeded, want an error", tt.name, tt.query)
+		}
+	}
+}
+
+func TestListQueryCursorRoundTrip(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	scan := func(row rowScanner) (int, error) {
+		var id int
+		var name string
+		return id, row.Scan(&id, &name)
+	}
+
+	// The first page holds two rows; the third only tells fetch there is more.
+	q, err := parseListQuery(httptest.NewRequest(http.MethodGet, "/api/things?sort=name&limit=2", nil), &testListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("ORDER BY t.name ASC, t.id ASC LIMIT 3").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "sort"}).
+			AddRow(4, "Aisha", "Aisha").AddRow(9, "Bilal", "Bilal").AddRow(2, "Zainab", "Zainab"))
+
+	total, next, err := q.fetch(db, scan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || next == "" {
+		t.Fatalf("total %d next %q, want 3 and a cursor", total, next)
+	}
+
+	// The cursor resumes after the last row of the first page.
+	q, err = parseListQuery(httptest.NewRequest(http.MethodGet, "/api/things?sort=name&limit=2&cursor="+next, nil), &testListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (listCursor{Sort: "name", Value: "Bilal", ID: 9}); q.cursor == nil || *q.cursor != want {
+		t.Fatalf("cursor = %+v, want %+v", q.cursor, want)
+	}
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE (t.name, t.id) > ($1::text, $2)")).
+		WithArgs("Bilal", 9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "sort"}).AddRow(2, "Zainab", "Zainab"))
+
+	if _, next, err = q.fetch(db, scan); err != nil || next != "" {
+		t.Errorf("last page: next %q, err %v, want no cursor", next, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Brute-force protection for Login and the second login step. Failures per
+// account back off exponentially and lock the account after
+// loginLockoutThreshold consecutive failures; failures per IP address are
+// capped within a sliding window.
+const (
+	loginBackoffThreshold = 3
+	loginMaxBackoff       = 5 * time.Minute
+	loginLockoutThreshold = 10
+	loginLockoutDuration  = 30 * time.Minute
+	ipFailureWindow       = 15 * time.Minute
+	ipMaxFailures         = 20
+)
+
+// Reasons stored in login_attempts.
+const (
+	loginReasonSuccess         = "success"
+	loginReasonUnknownUser     = "unknown_user"
+	loginReasonInvalidPassword = "invalid_password"
+	loginReasonInvalidCode     = "invalid_2fa_code"
+	loginReasonLocked          = "locked"
+	loginReasonThrottled       = "throttled"
+	loginReasonDisabled        = "disabled"
+	loginReasonSSO             = "sso"
+)
+
+// loginBackoff returns how long an account must wait after its last failure
+// before another attempt is evaluated.
+func loginBackoff(failures int) time.Duration {
+	if failures < loginBackoffThreshold {
+		return 0
+	}
+
+	exponent := failures - loginBackoffThreshold
+	if exponent > 16 {
+		return loginMaxBackoff
+	}
+	backoff := time.Duration(math.Pow(2, float64(exponent))) * time.Second
+	if backoff > loginMaxBackoff {
+		return loginMaxBackoff
+	}
+	return backoff
+}
+
+// rejectThrottledLogin checks the IP and account limits, and that the account
+// is not disabled, before credentials are evaluated. When the attempt must be
+// refused it records it, writes the response and returns true. userID may be
+// 0 for unknown usernames.
+func (h *Handlers) rejectThrottledLogin(w http.ResponseWriter, r *http.Request, userID int, username string) bool {
+	ip := clientIP(r)
+
+	var ipFailures int
+	err := h.DB.QueryRow(
+		`SELECT COUNT(*) FROM login_attempts
+		 WHERE ip_address = $1 AND reason IN ($2, $3, $4)
+			AND created_at > CURRENT_TIMESTAMP - $5 * INTERVAL '1 second'`,
+		ip, loginReasonUnknownUser, loginReasonInvalidPassword, loginReasonInvalidCode, int(ipFailureWindow.Seconds()),
+	).Scan(&ipFailures)
+	if err != nil {
+		log.Printf("Error counting failed logins for IP: %v", err)
+		sendJSONError(w, "Login is temporarily unavailable. Please try again later.", http.StatusInternalServerError)
+		return true
+	}
+
+	if ipFailures >= ipMaxFailures {
+		h.recordLoginAttempt(r, userID, username, false, loginReasonThrottled)
+		sendRetryAfter(w, ipFailureWindow, "Too many failed login attempts from this address. Please try again later.")
+		return true
+	}
+
+	if userID == 0 {
+		return false
+	}
+
+	var failures int
+	var active bool
+	var lockedFor, sinceFailure sql.NullFloat64
+	err = h.DB.QueryRow(
+		`SELECT failed_login_count, is_active,
+			EXTRACT(EPOCH FROM (locked_until - CURRENT_TIMESTAMP)),
+			EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - last_failed_login_at))
+		 FROM users WHERE id = $1`,
+		userID,
+	).Scan(&failures, &active, &lockedFor, &sinceFailure)
+	if err != nil {
+		log.Printf("Error fetching login state: %v", err)
+		sendJSONError(w, "Login is temporarily unavailable. Please try again later.", http.StatusInternalServerError)
+		return true
+	}
+
+	if !active {
+		h.recordLoginAttempt(r, userID, username, false, loginReasonDisabled)
+		sendJSONError(w, "This account has been disabled. Contact a master admin.", http.StatusForbidden)
+		return true
+	}
+
+	if lockedFor.Valid && lockedFor.Float64 > 0 {
+		h.recordLoginAttempt(r, userID, username, false, loginReasonLocked)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedFor.Float64))))
+		sendJSONError(w, "Account is temporarily locked after too many failed attempts. Contact a master admin to unlock it.", http.StatusLocked)
+		return true
+	}
+
+	// Once a lock has passed the account starts counting failures afresh, so a
+	// single mistake does not lock it again.
+	if lockedFor.Valid {
+		_, err = h.DB.Exec(
+			"UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = $1 AND locked_until <= CURRENT_TIMESTAMP",
+			userID,
+		)
+		if err != nil {
+			log.Printf("Error clearing expired login lock: %v", err)
+			sendJSONError(w, "Login is temporarily unavailable. Please try again later.", http.StatusInternalServerError)
+			return true
+		}
+		failures = 0
+	}
+
+	backoff := loginBackoff(failures).Seconds()
+	if sinceFailure.Valid && sinceFailure.Float64 < backoff {
+		h.recordLoginAttempt(r, userID, username, false, loginReasonThrottled)
+		wait := time.Duration((backoff - sinceFailure.Float64) * float64(time.Second))
+		sendRetryAfter(w, wait, fmt.Sprintf("Too many failed attempts. Try again in %d seconds.", int(math.Ceil(wait.Seconds()))))
+		return true
+	}
+
+	return false
+}
+
+func sendRetryAfter(w http.ResponseWriter, wait time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	sendJSONError(w, message, http.StatusTooManyRequests)
+}
+
+// registerLoginFailure counts a failed password or code for the account and
+// locks it once the threshold is reached.
+func (h *Handlers) registerLoginFailure(userID int) {
+	_, err := h.DB.Exec(
+		`UPDATE users SET
+			failed_login_count = failed_login_count + 1,
+			last_failed_login_at = CURRENT_TIMESTAMP,
+			locked_until = CASE
+				WHEN failed_login_count + 1 >= $2 THEN CURRENT_TIMESTAMP + $3 * INTERVAL '1 second'
+				ELSE locked_until
+			END
+		 WHERE id = $1`,
+		userID, loginLockoutThreshold, int(loginLockoutDuration.Seconds()),
+	)
+	if err != nil {
+		log.Printf("Error registering login failure: %v", err)
+	}
+}
+
+// resetLoginFailures clears the failure counter after a successful login.
+func (h *Handlers) resetLoginFailures(userID int) {
+	_, err := h.DB.Exec(
+		"UPDATE users SET failed_login_count = 0, last_failed_login_at = NULL, locked_until = NULL WHERE id = $1",
+		userID,
+	)
+	if err != nil {
+		log.Printf("Error resetting login failures: %v", err)
+	}
+}
+
+// recordLoginAttempt appends to the login history. userID is 0 when the
+// username does not exist; username may be empty when only the ID is known.
+func (h *Handlers) recordLoginAttempt(r *http.Request, userID int, username string, success bool, reason string) {
+	_, err := h.DB.Exec(
+		`INSERT INTO login_attempts (user_id, username, ip_address, user_agent, success, reason)
+		 VALUES (NULLIF($1, 0), COALESCE(NULLIF($2, ''), (SELECT username FROM users WHERE id = $1), ''), $3, $4, $5, $6)`,
+		userID, username, clientIP(r), r.UserAgent(), success, reason,
+	)
+	if err != nil {
+		log.Printf("Error recording login attempt: %v", err)
+	}
+}
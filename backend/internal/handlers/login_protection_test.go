@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{loginBackoffThreshold - 1, 0},
+		{loginBackoffThreshold, time.Second},
+		{loginBackoffThreshold + 1, 2 * time.Second},
+		{loginBackoffThreshold + 2, 4 * time.Second},
+		{loginBackoffThreshold + 5, 32 * time.Second},
+		{loginBackoffThreshold + 8, 256 * time.Second},
+		{loginBackoffThreshold + 9, loginMaxBackoff},
+		{loginBackoffThreshold + 16, loginMaxBackoff},
+		{loginBackoffThreshold + 17, loginMaxBackoff},
+		{1000, loginMaxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := loginBackoff(tt.failures); got != tt.want {
+			t.Errorf("loginBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRejectThrottledLoginAfterLock(t *testing.T) {
+	tests := []struct {
+		name      string
+		lockedFor float64
+		wantCode  int
+	}{
+		{"still locked", 60, http.StatusLocked},
+		{"lock passed", -60, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("FROM login_attempts").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			// The account was locked after loginLockoutThreshold failures,
+			// the last of them just before the lock.
+			mock.ExpectQuery("SELECT failed_login_count").WithArgs(3).
+				WillReturnRows(sqlmock.NewRows([]string{"failed_login_count", "is_active", "locked_for", "since_failure"}).
+					AddRow(loginLockoutThreshold, true, tt.lockedFor, loginLockoutDuration.Seconds()-tt.lockedFor))
+			if tt.wantCode == http.StatusLocked {
+				mock.ExpectExec("INSERT INTO login_attempts").WillReturnResult(sqlmock.NewResult(1, 1))
+			} else {
+				mock.ExpectExec("UPDATE users SET failed_login_count = 0").WithArgs(3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			w := httptest.NewRecorder()
+			rejected := (&Handlers{DB: db}).rejectThrottledLogin(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", nil), 3, "aisha")
+
+			if rejected != (tt.wantCode != http.StatusOK) || w.Code != tt.wantCode {
+				t.Errorf("rejectThrottledLogin = %v with status %d, want status %d", rejected, w.Code, tt.wantCode)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/models"
+)
+
+type duplicateGroupMember struct {
+	models.Member
+	PaymentCount int `json:"payment_count"`
+}
+
+type duplicateGroup struct {
+	MobileNo string                 `json:"mobile_no"`
+	Members  []duplicateGroupMember `json:"members"`
+}
+
+// GetMemberDuplicates lists groups of members registered with the same
+// mobile number, across all admins, oldest member first.
+func (h *Handlers) GetMemberDuplicates(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT ` + memberColumns + `,
+			(SELECT COUNT(*) FROM payments p WHERE p.member_id = m.id)
+		FROM ` + memberFrom + `
+		WHERE m.deleted_at IS NULL AND m.mobile_no IN (
+			SELECT mobile_no FROM members
+			WHERE deleted_at IS NULL
+			GROUP BY mobile_no
+			HAVING COUNT(*) > 1
+		)
+		ORDER BY m.mobile_no, m.created_at, m.id
+	`
+
+	rows, err := h.DB.Query(query)
+	if err != nil {
+		log.Printf("Error fetching duplicate members: %v", err)
+		sendJSONError(w, "Failed to fetch duplicate members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	groups := []duplicateGroup{}
+	for rows.Next() {
+		var m duplicateGroupMember
+		if err := scanMember(rows, &m.Member, &m.PaymentCount); err != nil {
+			continue
+		}
+
+		if len(groups) == 0 || groups[len(groups)-1].MobileNo != m.MobileNo {
+			groups = append(groups, duplicateGroup{MobileNo: m.MobileNo})
+		}
+		last := &groups[len(groups)-1]
+		last.Members = append(last.Members, m)
+	}
+
+	sendJSONResponse(w, groups, http.StatusOK)
+}
+
+// MergeMembers folds the member duplicate_id into the member in the path:
+// the duplicate's payments and household are re-pointed to the surviving
+// member, its tags and custom field values are copied where the survivor has
+// none, and the duplicate is soft-deleted with merged_into recorded. A
+// duplicate with plans, transfers or status changes since it joined is not
+// merged, as its history cannot be folded into the survivor's.
+func (h *Handlers) MergeMembers(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	survivorID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		DuplicateID int `json:"duplicate_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DuplicateID == 0 {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.DuplicateID == survivorID {
+		sendJSONError(w, "A member cannot be merged into itself", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting member merge: %v", err)
+		sendJSONError(w, "Failed to merge members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var found, households int
+	err = tx.QueryRow(
+		`SELECT COUNT(*), COUNT(DISTINCT household_id) FROM (
+			SELECT id, household_id FROM members WHERE id IN ($1, $2) AND deleted_at IS NULL ORDER BY id FOR UPDATE
+		) locked`,
+		survivorID, req.DuplicateID,
+	).Scan(&found, &households)
+	if err != nil {
+		log.Printf("Error locking members for merge: %v", err)
+		sendJSONError(w, "Failed to merge members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	if found != 2 {
+		sendJSONError(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if households > 1 {
+		sendJSONError(w, "These members belong to different households", http.StatusConflict)
+		return
+	}
+
+	var hasHistory bool
+	err = tx.QueryRow(
+		// Every member has the status history row recorded when they joined.
+		`SELECT EXISTS (SELECT 1 FROM member_plans WHERE member_id = $1)
+			OR EXISTS (SELECT 1 FROM member_transfers WHERE member_id = $1)
+			OR EXISTS (SELECT 1 FROM member_status_history h WHERE h.member_id = $1
+				AND (h.status <> 'active' OR h.id > (SELECT MIN(id) FROM member_status_history WHERE member_id = $1)))`,
+		req.DuplicateID,
+	).Scan(&hasHistory)
+	if err != nil {
+		log.Printf("Error checking member history for merge: %v", err)
+		sendJSONError(w, "Failed to merge members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	if hasHistory {
+		sendJSONError(w, "The duplicate has its own plan, status or transfer history and cannot be merged", http.StatusConflict)
+		return
+	}
+
+	result, err := tx.Exec("UPDATE payments SET member_id = $1 WHERE member_id = $2", survivorID, req.DuplicateID)
+	if err != nil {
+		log.Printf("Error moving payments for merge: %v", err)
+		sendJSONError(w, "Failed to merge members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	moved, _ := result.RowsAffected()
+
+	_, err = tx.Exec("UPDATE payments SET payer_member_id = $1 WHERE payer_member_id = $2", survivorID, req.DuplicateID)
+	if err == nil {
+		_, err = tx.Exec("UPDATE households SET payer_member_id = $1 WHERE payer_member_id = $2", survivorID, req.DuplicateID)
+	}
+	if err == nil {
+		_, err = tx.Exec(
+			"UPDATE members SET household_id = (SELECT household_id FROM members WHERE id = $2) WHERE id = $1 AND household_id IS NULL",
+			survivorID, req.DuplicateID,
+		)
+	}
+	if err == nil {
+		_, err = tx.Exec(
+			"INSERT INTO member_tags (member_id, tag) SELECT $1, tag FROM member_tags WHERE member_id = $2 ON CONFLICT DO NOTHING",
+			survivorID, req.DuplicateID,
+		)
+	}
+	if err == nil {
+		// The surviving member's own custom field values win.
+		_, err = tx.Exec(
+			`INSERT INTO member_field_values (member_id, field_id, value)
+			 SELECT $1, field_id, value FROM member_field_values WHERE member_id = $2
+			 ON CONFLICT DO NOTHING`,
+			survivorID, req.DuplicateID,
+		)
+	}
+	if err == nil {
+		_, err = tx.Exec(
+			`UPDATE members SET merged_into = $1, deleted_at = CURRENT_TIMESTAMP, deleted_by = $3, is_active = false, household_id = NULL
+			 WHERE id = $2`,
+			survivorID, req.DuplicateID, getUserIDFromRequest(r),
+		)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error merging members: %v", err)
+		sendJSONError(w, "Failed to merge members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Merged member %d into %d (%d payments moved)", req.DuplicateID, survivorID, moved)
+
+	sendJSONResponse(w, map[string]interface{}{
+		"id":             survivorID,
+		"merged_id":      req.DuplicateID,
+		"payments_moved": moved,
+	}, http.StatusOK)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// mergeRequest asks to merge member 2 into member 1.
+func mergeRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/members/1/merge", strings.NewReader(`{"duplicate_id": 2}`))
+	r.Header.Set("X-User-ID", "7")
+	return mux.SetURLVars(r, map[string]string{"id": "1"})
+}
+
+// expectMergeChecks expects the member lock and the history check, which
+// finds history when hasHistory is set.
+func expectMergeChecks(mock sqlmock.Sqlmock, hasHistory bool) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM members WHERE id IN").WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "households"}).AddRow(2, 1))
+	mock.ExpectQuery("FROM member_plans").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(hasHistory))
+}
+
+func TestMergeMembers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Freshly created members have nothing but the status row recorded when
+	// they joined.
+	expectMergeChecks(mock, false)
+	mock.ExpectExec("UPDATE payments SET member_id").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("UPDATE payments SET payer_member_id").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE households SET payer_member_id").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE members SET household_id").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO member_tags").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO member_field_values").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE members SET merged_into").WithArgs(1, 2, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	(&Handlers{DB: db}).MergeMembers(w, mergeRequest())
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var body struct {
+		ID            int `json:"id"`
+		MergedID      int `json:"merged_id"`
+		PaymentsMoved int `json:"payments_moved"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.ID != 1 || body.MergedID != 2 || body.PaymentsMoved != 3 {
+		t.Errorf("response = %+v, want id 1, merged_id 2, payments_moved 3", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMergeMembersWithHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expectMergeChecks(mock, true)
+	mock.ExpectRollback()
+
+	w := httptest.NewRecorder()
+	(&Handlers{DB: db}).MergeMembers(w, mergeRequest())
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
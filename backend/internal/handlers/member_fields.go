@@ -0,0 +1,533 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
+)
+
+const (
+	maxFieldLabelLength = 100
+	maxFieldValueLength = 500
+	maxTagLength        = 50
+	maxMemberTags       = 20
+)
+
+var memberFieldTypes = map[string]bool{"text": true, "number": true, "date": true, "enum": true}
+
+var memberFieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// memberAttributeColumns selects a member's custom field values as a JSON
+// object keyed by field key, and its tags in order.
+const memberAttributeColumns = `COALESCE((SELECT json_object_agg(d.key, v.value) FROM member_field_values v
+			INNER JOIN member_field_definitions d ON d.id = v.field_id WHERE v.member_id = m.id), '{}'),
+		ARRAY(SELECT t.tag FROM member_tags t WHERE t.member_id = m.id ORDER BY t.tag)`
+
+const memberFieldColumns = `id, key, label, field_type, options, created_at, updated_at`
+
+func scanMemberField(row interface{ Scan(...interface{}) error }, f *models.MemberField) error {
+	return row.Scan(&f.ID, &f.Key, &f.Label, &f.FieldType, pq.Array(&f.Options), &f.CreatedAt, &f.UpdatedAt)
+}
+
+// decodeMemberAttributes unpacks the memberAttributeColumns of a row.
+func decodeMemberAttributes(customFields []byte, fields *map[string]string, tags *[]string) error {
+	if *tags == nil {
+		*tags = []string{}
+	}
+	return json.Unmarshal(customFields, fields)
+}
+
+func (h *Handlers) GetMemberFields(w http.ResponseWriter, r *http.Request) {
+	fields, err := loadMemberFields(h.DB)
+	if err != nil {
+		log.Printf("Error fetching member fields: %v", err)
+		sendJSONError(w, "Failed to fetch member fields. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, fields, http.StatusOK)
+}
+
+// loadMemberFields returns the custom field definitions ordered by label.
+func loadMemberFields(q queryer) ([]models.MemberField, error) {
+	rows, err := q.Query("SELECT " + memberFieldColumns + " FROM member_field_definitions ORDER BY label, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fields := []models.MemberField{}
+	for rows.Next() {
+		var f models.MemberField
+		if err := scanMemberField(rows, &f); err != nil {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields, rows.Err()
+}
+
+// CreateMemberField defines a custom member field. The key names it in
+// member payloads and filters and cannot be changed later; enum fields need
+// at least one option.
+func (h *Handlers) CreateMemberField(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key       string   `json:"key"`
+		Label     string   `json:"label"`
+		FieldType string   `json:"field_type"`
+		Options   []string `json:"options"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !memberFieldKeyPattern.MatchString(req.Key) {
+		sendJSONError(w, "key must start with a lowercase letter and contain only lowercase letters, digits and underscores (at most 50)", http.StatusBadRequest)
+		return
+	}
+
+	if !memberFieldTypes[req.FieldType] {
+		sendJSONError(w, "field_type must be text, number, date or enum", http.StatusBadRequest)
+		return
+	}
+
+	label, options, err := validateMemberField(req.Label, req.FieldType, req.Options)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var field models.MemberField
+	err = scanMemberField(h.DB.QueryRow(
+		`INSERT INTO member_field_definitions (key, label, field_type, options)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING `+memberFieldColumns,
+		req.Key, label, req.FieldType, pq.Array(options),
+	), &field)
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			sendJSONError(w, "A field with this key already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Error creating member field: %v", err)
+		sendJSONError(w, "Failed to create member field. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, field, http.StatusCreated)
+}
+
+// UpdateMemberField changes a field's label or, for enum fields, its
+// options. An option still held by a member cannot be removed.
+func (h *Handlers) UpdateMemberField(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fieldID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid field ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Label   *string   `json:"label"`
+		Options *[]string `json:"options"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting member field update: %v", err)
+		sendJSONError(w, "Failed to update member field. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var field models.MemberField
+	err = scanMemberField(tx.QueryRow("SELECT "+memberFieldColumns+" FROM member_field_definitions WHERE id = $1 FOR UPDATE", fieldID), &field)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Field not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching member field for update: %v", err)
+		sendJSONError(w, "Failed to update member field. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	label, options := field.Label, field.Options
+	if req.Label != nil {
+		label = *req.Label
+	}
+	if req.Options != nil {
+		options = *req.Options
+	}
+	label, options, err = validateMemberField(label, field.FieldType, options)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if field.FieldType == "enum" {
+		var inUse []string
+		err = tx.QueryRow(
+			`SELECT ARRAY(SELECT DISTINCT value FROM member_field_values
+			 WHERE field_id = $1 AND NOT value = ANY($2) ORDER BY value)`,
+			fieldID, pq.Array(options),
+		).Scan(pq.Array(&inUse))
+		if err != nil {
+			log.Printf("Error checking member field options: %v", err)
+			sendJSONError(w, "Failed to update member field. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+		if len(inUse) > 0 {
+			sendJSONError(w, "Options still used by members cannot be removed: "+strings.Join(inUse, ", "), http.StatusConflict)
+			return
+		}
+	}
+
+	err = scanMemberField(tx.QueryRow(
+		`UPDATE member_field_definitions SET label = $1, options = $2 WHERE id = $3
+		 RETURNING `+memberFieldColumns,
+		label, pq.Array(options), fieldID,
+	), &field)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error updating member field: %v", err)
+		sendJSONError(w, "Failed to update member field. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, field, http.StatusOK)
+}
+
+// DeleteMemberField removes a field definition together with every
+// member's value for it.
+func (h *Handlers) DeleteMemberField(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fieldID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid field ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.DB.Exec("DELETE FROM member_field_definitions WHERE id = $1", fieldID)
+	if err != nil {
+		log.Printf("Error deleting member field: %v", err)
+		sendJSONError(w, "Failed to delete member field. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		sendJSONError(w, "Field not found", http.StatusNotFound)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"id":      fieldID,
+		"deleted": true,
+	}, http.StatusOK)
+}
+
+// validateMemberField trims a field's label and options and checks them.
+func validateMemberField(label, fieldType string, options []string) (string, []string, error) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return "", nil, errors.New("Label is required")
+	}
+	if len(label) > maxFieldLabelLength {
+		return "", nil, errors.New("Label is too long")
+	}
+
+	if fieldType != "enum" {
+		if len(options) > 0 {
+			return "", nil, errors.New("Only enum fields have options")
+		}
+		return label, []string{}, nil
+	}
+
+	cleaned := make([]string, 0, len(options))
+	for _, option := range options {
+		option = strings.TrimSpace(option)
+		if option == "" || len(option) > maxFieldValueLength {
+			return "", nil, errors.New("Options must be non-empty and at most 500 characters")
+		}
+		if containsString(cleaned, option) {
+			return "", nil, fmt.Errorf("Option %q is listed twice", option)
+		}
+		cleaned = append(cleaned, option)
+	}
+	if len(cleaned) == 0 {
+		return "", nil, errors.New("Enum fields need at least one option")
+	}
+	return label, cleaned, nil
+}
+
+// memberFieldsByKey indexes field definitions by key.
+func memberFieldsByKey(fields []models.MemberField) map[string]models.MemberField {
+	byKey := make(map[string]models.MemberField, len(fields))
+	for _, f := range fields {
+		byKey[f.Key] = f
+	}
+	return byKey
+}
+
+// normalizeFieldValue checks a value against its field's type and returns
+// the form it is stored in. Numbers and dates are stored canonically so
+// they can be cast when filtering.
+func normalizeFieldValue(f models.MemberField, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	switch f.FieldType {
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			return "", fmt.Errorf("%s must be a number", f.Label)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case "date":
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a date (YYYY-MM-DD)", f.Label)
+		}
+		return t.Format("2006-01-02"), nil
+	case "enum":
+		if !containsString(f.Options, value) {
+			return "", fmt.Errorf("%s must be one of: %s", f.Label, strings.Join(f.Options, ", "))
+		}
+	}
+
+	if len(value) > maxFieldValueLength {
+		return "", fmt.Errorf("%s is too long", f.Label)
+	}
+	return value, nil
+}
+
+// normalizeTag lower-cases and trims a tag.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// normalizeTags cleans a member's tags, dropping blanks and duplicates.
+func normalizeTags(tags []string) ([]string, error) {
+	cleaned := []string{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || containsString(cleaned, tag) {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("Tags can be at most %d characters", maxTagLength)
+		}
+		cleaned = append(cleaned, tag)
+	}
+	if len(cleaned) > maxMemberTags {
+		return nil, fmt.Errorf("A member can have at most %d tags", maxMemberTags)
+	}
+	sort.Strings(cleaned)
+	return cleaned, nil
+}
+
+// memberAttributeChanges are the custom field values and tags sent with a
+// member. An empty value clears that field; nil tags leave them unchanged.
+type memberAttributeChanges struct {
+	values map[int]string
+	tags   []string
+}
+
+// validateMemberAttributes checks custom field values, keyed by field key,
+// and tags sent with a member against the field definitions.
+func validateMemberAttributes(fields []models.MemberField, customFields map[string]string, tags []string) (*memberAttributeChanges, error) {
+	changes := &memberAttributeChanges{values: map[int]string{}}
+
+	byKey := memberFieldsByKey(fields)
+	for key, value := range customFields {
+		f, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("Unknown custom field %q", key)
+		}
+		normalized, err := normalizeFieldValue(f, value)
+		if err != nil {
+			return nil, err
+		}
+		changes.values[f.ID] = normalized
+	}
+
+	if tags != nil {
+		cleaned, err := normalizeTags(tags)
+		if err != nil {
+			return nil, err
+		}
+		changes.tags = cleaned
+	}
+
+	return changes, nil
+}
+
+// save writes the changes for memberID.
+func (c *memberAttributeChanges) save(tx *sql.Tx, memberID int) error {
+	for fieldID, value := range c.values {
+		var err error
+		if value == "" {
+			_, err = tx.Exec("DELETE FROM member_field_values WHERE member_id = $1 AND field_id = $2", memberID, fieldID)
+		} else {
+			_, err = tx.Exec(
+				`INSERT INTO member_field_values (member_id, field_id, value) VALUES ($1, $2, $3)
+				 ON CONFLICT (member_id, field_id) DO UPDATE SET value = EXCLUDED.value`,
+				memberID, fieldID, value,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.tags == nil {
+		return nil
+	}
+	if _, err := tx.Exec("DELETE FROM member_tags WHERE member_id = $1", memberID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(
+		"INSERT INTO member_tags (member_id, tag) SELECT $1, unnest($2::text[])",
+		memberID, pq.Array(c.tags),
+	)
+	return err
+}
+
+// memberAttributeFilter reads the tag and custom field filters of a request
+// on members aliased m:
+//
+//	tag                    members with this tag; repeat to require several
+//	field.<key>            value matches (text fields: contains, case-insensitively)
+//	field.<key>.min, .max  inclusive range for number and date fields
+//
+// It returns the conditions, adding their arguments with arg. When the
+// filters are invalid it writes an error response and returns false.
+func (h *Handlers) memberAttributeFilter(w http.ResponseWriter, r *http.Request, arg func(interface{}) string) ([]string, bool) {
+	params := r.URL.Query()
+
+	var byKey map[string]models.MemberField
+	for name := range params {
+		if strings.HasPrefix(name, "field.") {
+			fields, err := loadMemberFields(h.DB)
+			if err != nil {
+				log.Printf("Error fetching member fields for filter: %v", err)
+				sendJSONError(w, "Failed to fetch members. Please try again later.", http.StatusInternalServerError)
+				return nil, false
+			}
+			byKey = memberFieldsByKey(fields)
+			break
+		}
+	}
+
+	conditions, err := memberAttributeConditions(params, byKey, arg)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return conditions, true
+}
+
+func memberAttributeConditions(params url.Values, byKey map[string]models.MemberField, arg func(interface{}) string) ([]string, error) {
+	var conditions []string
+
+	for _, tag := range params["tag"] {
+		if tag = normalizeTag(tag); tag == "" {
+			continue
+		}
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM member_tags t WHERE t.member_id = m.id AND t.tag = "+arg(tag)+")")
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if strings.HasPrefix(name, "field.") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		raw := strings.TrimSpace(params.Get(name))
+		if raw == "" {
+			continue
+		}
+
+		key, bound := strings.TrimPrefix(name, "field."), ""
+		if strings.HasSuffix(key, ".min") || strings.HasSuffix(key, ".max") {
+			key, bound = key[:len(key)-4], key[len(key)-3:]
+		}
+		f, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("Unknown custom field %q", key)
+		}
+
+		// Values of other fields are never cast, so a text value cannot
+		// break a numeric comparison.
+		value, sqlType := "v.value", ""
+		switch f.FieldType {
+		case "number":
+			sqlType = "numeric"
+		case "date":
+			sqlType = "date"
+		}
+		if sqlType != "" {
+			value = "CASE WHEN v.field_id = " + arg(f.ID) + " THEN v.value::" + sqlType + " END"
+		}
+
+		var condition string
+		switch {
+		case bound != "" && sqlType == "":
+			return nil, fmt.Errorf("%s filters only apply to number and date fields", name)
+		case sqlType != "":
+			normalized, err := normalizeFieldValue(f, raw)
+			if err != nil {
+				return nil, err
+			}
+			op := "="
+			if bound == "min" {
+				op = ">="
+			} else if bound == "max" {
+				op = "<="
+			}
+			condition = value + " " + op + " " + arg(normalized) + "::" + sqlType
+		case f.FieldType == "text":
+			condition = "v.value ILIKE " + arg("%"+escapeLike(raw)+"%")
+		default:
+			condition = "v.value = " + arg(raw)
+		}
+
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM member_field_values v WHERE v.member_id = m.id AND v.field_id = %s AND %s)",
+			arg(f.ID), condition,
+		))
+	}
+
+	return conditions, nil
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/khidmat/backend/internal/models"
+)
+
+var testMemberFields = map[string]models.MemberField{
+	"city":   {ID: 1, Key: "city", Label: "City", FieldType: "text"},
+	"plot":   {ID: 2, Key: "plot", Label: "Plot", FieldType: "number"},
+	"joined": {ID: 3, Key: "joined", Label: "Joined", FieldType: "date"},
+	"gender": {ID: 4, Key: "gender", Label: "Gender", FieldType: "enum", Options: []string{"female", "male"}},
+}
+
+func TestMemberAttributeConditions(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		wantConditions []string
+		wantArgs       []interface{}
+	}{
+		{
+			name:  "tags",
+			query: "tag=+Volunteer+&tag=&tag=elder",
+			wantConditions: []string{
+				"EXISTS (SELECT 1 FROM member_tags t WHERE t.member_id = m.id AND t.tag = $1)",
+				"EXISTS (SELECT 1 FROM member_tags t WHERE t.member_id = m.id AND t.tag = $2)",
+			},
+			wantArgs: []interface{}{"volunteer", "elder"},
+		},
+		{
+			name:  "text matches part of the value",
+			query: "field.city=New_",
+			wantConditions: []string{
+				"EXISTS (SELECT 1 FROM member_field_values v WHERE v.member_id = m.id AND v.field_id = $2 AND v.value ILIKE $1)",
+			},
+			wantArgs: []interface{}{`%New\_%`, 1},
+		},
+		{
+			name:  "enum matches exactly",
+			query: "field.gender=female",
+			wantConditions: []string{
+				"EXISTS (SELECT 1 FROM member_field_values v WHERE v.member_id = m.id AND v.field_id = $2 AND v.value = $1)",
+			},
+			wantArgs: []interface{}{"female", 4},
+		},
+		{
+			name:  "number range casts only the field's own values",
+			query: "field.plot.min=10&field.plot.max=20.50",
+			wantConditions: []string{
+				"EXISTS (SELECT 1 FROM member_field_values v WHERE v.member_id = m.id AND v.field_id = $3 AND " +
+					"CASE WHEN v.field_id = $1 THEN v.value::numeric END <= $2::numeric)",
+				"EXISTS (SELECT 1 FROM member_field_values v WHERE v.member_id = m.id AND v.field_id = $6 AND " +
+					"CASE WHEN v.field_id = $4 THEN v.value::numeric END >= $5::numeric)",
+			},
+			wantArgs: []interface{}{2, "20.5", 2, 2, "10", 2},
+		},
+		{
+			name:  "date",
+			query: "field.joined=2024-03-01&field.city=",
+			wantConditions: []string{
+				"EXISTS (SELECT 1 FROM member_field_values v WHERE v.member_id = m.id AND v.field_id = $3 AND " +
+					"CASE WHEN v.field_id = $1 THEN v.value::date END = $2::date)",
+			},
+			wantArgs: []interface{}{3, "2024-03-01", 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var args []interface{}
+			arg := func(v interface{}) string {
+				args = append(args, v)
+				return "$" + strconv.Itoa(len(args))
+			}
+
+			conditions, err := memberAttributeConditions(params, testMemberFields, arg)
+			if err != nil {
+				t.Fatalf("memberAttributeConditions: %v", err)
+			}
+			if !reflect.DeepEqual(conditions, tt.wantConditions) {
+				t.Errorf("conditions = %q, want %q", conditions, tt.wantConditions)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestMemberAttributeConditionsErrors(t *testing.T) {
+	for _, query := range []string{
+		"field.unknown=x",
+		"field.city.min=a",
+		"field.gender.max=male",
+		"field.plot=ten",
+		"field.joined.min=01/03/2024",
+	} {
+		params, _ := url.ParseQuery(query)
+		arg := func(interface{}) string { return "$1" }
+		if _, err := memberAttributeConditions(params, testMemberFields, arg); err == nil {
+			t.Errorf("memberAttributeConditions(%q) succeeded, want an error", query)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := normalizeTags([]string{" Volunteer", "elder", "", "VOLUNTEER "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"elder", "volunteer"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeTags = %q, want %q", got, want)
+	}
+}
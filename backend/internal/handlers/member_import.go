@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/models"
+	"github.com/khidmat/backend/internal/spreadsheet"
+)
+
+const (
+	maxImportFileSize = 5 << 20
+	maxImportRows     = 5000
+)
+
+// importColumnAliases maps header cells, lower-cased and with underscores
+// read as spaces, to member fields.
+var importColumnAliases = map[string]string{
+	"name":          "name",
+	"member name":   "name",
+	"full name":     "name",
+	"mobile no":     "mobile_no",
+	"mobile":        "mobile_no",
+	"mobile number": "mobile_no",
+	"phone":         "mobile_no",
+	"contact no":    "mobile_no",
+	"address":       "address",
+}
+
+type memberImportRowError struct {
+	Row    int      `json:"row"`
+	Name   string   `json:"name,omitempty"`
+	Errors []string `json:"errors"`
+}
+
+type memberImportReport struct {
+	DryRun      bool                   `json:"dry_run"`
+	AdminID     int                    `json:"admin_id"`
+	TotalRows   int                    `json:"total_rows"`
+	ValidRows   int                    `json:"valid_rows"`
+	InvalidRows int                    `json:"invalid_rows"`
+	Imported    int                    `json:"imported"`
+	Errors      []memberImportRowError `json:"errors"`
+}
+
+type memberImportRow struct {
+	line   int
+	member models.Member
+}
+
+// ImportMembers bulk-creates members from an uploaded CSV or XLSX file
+// (multipart field "file") with name, mobile_no and address columns. With
+// dry_run=true (the default) it only reports per-row problems; otherwise it
+// inserts every valid row in one transaction, owned by admin_id (the caller
+// by default) and skips the rest.
+func (h *Handlers) ImportMembers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportFileSize+(1<<20))
+	if err := r.ParseMultipartForm(maxImportFileSize); err != nil {
+		sendJSONError(w, "Upload a CSV or XLSX file of at most 5 MB in the \"file\" field", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		sendJSONError(w, "Upload a CSV or XLSX file of at most 5 MB in the \"file\" field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxImportFileSize+1))
+	if err != nil || len(data) > maxImportFileSize {
+		sendJSONError(w, "Upload a CSV or XLSX file of at most 5 MB in the \"file\" field", http.StatusBadRequest)
+		return
+	}
+
+	dryRun := true
+	if raw := r.FormValue("dry_run"); raw != "" {
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			sendJSONError(w, "dry_run must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	adminID := getUserIDFromRequest(r)
+	if raw := r.FormValue("admin_id"); raw != "" {
+		if adminID, err = strconv.Atoi(raw); err != nil {
+			sendJSONError(w, "Invalid admin ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if !h.authorizeMemberOwner(w, r, adminID) {
+		return
+	}
+
+	rows, err := spreadsheet.Read(header.Filename, data)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	policy, err := h.getMobilePolicy()
+	if err != nil {
+		log.Printf("Error reading mobile number settings: %v", err)
+		sendJSONError(w, "Failed to import members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	report := memberImportReport{DryRun: dryRun, AdminID: adminID, Errors: []memberImportRowError{}}
+	candidates, err := parseMemberImportRows(rows, policy, &report)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting member import: %v", err)
+		sendJSONError(w, "Failed to import members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Keep the duplicate check valid until the rows are inserted.
+	if !dryRun {
+		if _, err := tx.Exec("LOCK TABLE members IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			log.Printf("Error locking members for import: %v", err)
+			sendJSONError(w, "Failed to import members. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	valid, err := rejectExistingMembers(tx, candidates, policy, &report)
+	if err != nil {
+		log.Printf("Error checking members for import: %v", err)
+		sendJSONError(w, "Failed to import members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	report.ValidRows = len(valid)
+	report.InvalidRows = len(report.Errors)
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Row < report.Errors[j].Row })
+
+	if dryRun || len(valid) == 0 {
+		sendJSONResponse(w, report, http.StatusOK)
+		return
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO members (name, mobile_no, address, admin_id) VALUES ($1, $2, $3, $4)")
+	if err == nil {
+		for _, row := range valid {
+			if _, err = stmt.Exec(row.member.Name, row.member.MobileNo, row.member.Address, adminID); err != nil {
+				break
+			}
+		}
+		stmt.Close()
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error importing members: %v", err)
+		sendJSONError(w, "Failed to import members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	report.Imported = len(valid)
+	log.Printf("Imported %d members for admin %d from %q", report.Imported, adminID, header.Filename)
+
+	sendJSONResponse(w, report, http.StatusCreated)
+}
+
+// authorizeMemberOwner checks that adminID may own members and that the
+// caller may create members for them. On failure it writes the error
+// response and returns false.
+func (h *Handlers) authorizeMemberOwner(w http.ResponseWriter, r *http.Request, adminID int) bool {
+	if scope := scopeAdminID(r); scope != 0 && scope != adminID {
+		sendJSONError(w, "You can only add members to your own account", http.StatusForbidden)
+		return false
+	}
+
+	var role string
+	var active bool
+	err := h.DB.QueryRow("SELECT user_type, is_active FROM users WHERE id = $1", adminID).Scan(&role, &active)
+	if err == sql.ErrNoRows || (err == nil && !active) {
+		sendJSONError(w, "Admin not found or disabled", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		log.Printf("Error fetching member owner: %v", err)
+		sendJSONError(w, "Failed to fetch admin. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+
+	capabilities, err := middleware.CapabilitiesForRole(h.DB, role)
+	if err != nil {
+		log.Printf("Error loading role capabilities: %v", err)
+		sendJSONError(w, "Failed to fetch admin. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+	if !containsString(capabilities, middleware.CapMembersWrite) {
+		sendJSONError(w, "This user cannot own members", http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// memberImportKey identifies duplicates: members with the same mobile number
+// when the policy blocks duplicates, else the same name and mobile number.
+func memberImportKey(m models.Member, policy mobilePolicy) string {
+	if policy.blockDuplicates {
+		return m.MobileNo
+	}
+	return strings.ToLower(m.Name) + "\x00" + m.MobileNo
+}
+
+// parseMemberImportRows maps the header row to member fields and validates
+// every data row, recording failures in report. Blank rows are skipped.
+func parseMemberImportRows(rows [][]string, policy mobilePolicy, report *memberImportReport) ([]memberImportRow, error) {
+	if len(rows) == 0 {
+		return nil, errors.New("The file is empty")
+	}
+
+	columns := make(map[string]int)
+	for i, cell := range rows[0] {
+		key := strings.Join(strings.Fields(strings.ToLower(strings.ReplaceAll(cell, "_", " "))), " ")
+		if field, ok := importColumnAliases[key]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+	for _, field := range []string{"name", "mobile_no", "address"} {
+		if _, ok := columns[field]; !ok {
+			return nil, errors.New("The first row must be a header with name, mobile_no and address columns")
+		}
+	}
+
+	cell := func(row []string, field string) string {
+		if i := columns[field]; i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	firstLine := make(map[string]int)
+
+	var candidates []memberImportRow
+	for i, row := range rows[1:] {
+		line := i + 2
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+
+		report.TotalRows++
+		if report.TotalRows > maxImportRows {
+			return nil, errors.New("Import at most " + strconv.Itoa(maxImportRows) + " members per file")
+		}
+
+		m := models.Member{Name: cell(row, "name"), MobileNo: cell(row, "mobile_no"), Address: cell(row, "address")}
+		if err := validateMember(&m, policy.countryCode); err != nil {
+			report.Errors = append(report.Errors, memberImportRowError{Row: line, Name: m.Name, Errors: []string{err.Error()}})
+			continue
+		}
+
+		key := memberImportKey(m, policy)
+		if first, ok := firstLine[key]; ok {
+			report.Errors = append(report.Errors, memberImportRowError{
+				Row: line, Name: m.Name, Errors: []string{"Duplicate of row " + strconv.Itoa(first)},
+			})
+			continue
+		}
+		firstLine[key] = line
+
+		candidates = append(candidates, memberImportRow{line: line, member: m})
+	}
+
+	return candidates, nil
+}
+
+// rejectExistingMembers drops candidates that duplicate a member already on
+// file, recording them in report.
+func rejectExistingMembers(tx *sql.Tx, candidates []memberImportRow, policy mobilePolicy, report *memberImportReport) ([]memberImportRow, error) {
+	rows, err := tx.Query("SELECT name, mobile_no FROM members WHERE deleted_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var m models.Member
+		if err := rows.Scan(&m.Name, &m.MobileNo); err != nil {
+			continue
+		}
+		existing[memberImportKey(m, policy)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var valid []memberImportRow
+	for _, c := range candidates {
+		if existing[memberImportKey(c.member, policy)] {
+			message := "A member with this name and mobile number already exists"
+			if policy.blockDuplicates {
+				message = "A member with this mobile number already exists"
+			}
+			report.Errors = append(report.Errors, memberImportRowError{Row: c.line, Name: c.member.Name, Errors: []string{message}})
+			continue
+		}
+		valid = append(valid, c)
+	}
+
+	return valid, nil
+}
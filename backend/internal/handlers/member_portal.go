@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/models"
+)
+
+// Member portal login: a one-time code is sent by SMS to members.mobile_no.
+const (
+	memberOTPTTL             = 5 * time.Minute
+	memberOTPMaxAttempts     = 5
+	memberOTPRequestsPerHour = 5
+)
+
+// RequestMemberOTP sends a login code to the mobile number if an active
+// member is registered with it. The response never reveals whether one is.
+func (h *Handlers) RequestMemberOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MobileNo string `json:"mobile_no"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	mobile, err := h.normalizeMemberMobile(req.MobileNo)
+	if err == errInvalidMobile {
+		sendJSONError(w, "A valid mobile number is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading mobile number settings: %v", err)
+		sendJSONError(w, "Failed to send login code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]string{
+		"message": "If this number is registered, a login code has been sent.",
+	}
+
+	var recentRequests int
+	err = h.DB.QueryRow(
+		"SELECT COUNT(*) FROM member_otps WHERE mobile_no = $1 AND created_at > CURRENT_TIMESTAMP - INTERVAL '1 hour'",
+		mobile,
+	).Scan(&recentRequests)
+	if err != nil {
+		log.Printf("Error counting member OTP requests: %v", err)
+		sendJSONError(w, "Failed to send login code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if recentRequests >= memberOTPRequestsPerHour {
+		sendRetryAfter(w, time.Hour, "Too many login codes requested. Please try again later.")
+		return
+	}
+
+	var storedMobile string
+	err = h.DB.QueryRow(
+		"SELECT mobile_no FROM members WHERE is_active = true AND mobile_no = $1 LIMIT 1",
+		mobile,
+	).Scan(&storedMobile)
+
+	if err == sql.ErrNoRows {
+		sendJSONResponse(w, response, http.StatusOK)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching member for OTP: %v", err)
+		sendJSONError(w, "Failed to send login code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	code, err := generateNumericCode(6)
+	if err != nil {
+		sendJSONError(w, "Failed to send login code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting member OTP transaction: %v", err)
+		sendJSONError(w, "Failed to send login code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Only the latest code for a number is valid.
+	_, err = tx.Exec("UPDATE member_otps SET used_at = CURRENT_TIMESTAMP WHERE mobile_no = $1 AND used_at IS NULL", mobile)
+	if err == nil {
+		_, err = tx.Exec(
+			`INSERT INTO member_otps (mobile_no, code_hash, expires_at, ip_address)
+			 VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second', $4)`,
+			mobile, memberOTPHash(mobile, code), int(memberOTPTTL.Seconds()), clientIP(r),
+		)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error storing member OTP: %v", err)
+		sendJSONError(w, "Failed to send login code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	text := fmt.Sprintf("Your Khidmat login code is %s. It expires in %d minutes.", code, int(memberOTPTTL.Minutes()))
+	go func() {
+		if err := h.SMS.SendSMS(storedMobile, text); err != nil {
+			log.Printf("Error sending member OTP: %v", err)
+		}
+	}()
+
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+// VerifyMemberOTP exchanges a valid login code for a member session. When
+// several members share the number, the caller must pick one via member_id.
+func (h *Handlers) VerifyMemberOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MobileNo string `json:"mobile_no"`
+		Code     string `json:"code"`
+		MemberID int    `json:"member_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	mobile, err := h.normalizeMemberMobile(req.MobileNo)
+	if err == errInvalidMobile {
+		sendJSONError(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading mobile number settings: %v", err)
+		sendJSONError(w, "Failed to verify code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting member OTP verification: %v", err)
+		sendJSONError(w, "Failed to verify code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var otpID, attempts int
+	var codeHash string
+	var expired bool
+	err = tx.QueryRow(`
+		SELECT id, code_hash, attempts, expires_at < CURRENT_TIMESTAMP
+		FROM member_otps
+		WHERE mobile_no = $1 AND used_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT 1
+		FOR UPDATE
+	`, mobile).Scan(&otpID, &codeHash, &attempts, &expired)
+
+	if err == sql.ErrNoRows || (err == nil && expired) {
+		sendJSONError(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching member OTP: %v", err)
+		sendJSONError(w, "Failed to verify code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(codeHash), []byte(memberOTPHash(mobile, req.Code))) != 1 {
+		// Burn the code once it has been guessed at too often.
+		_, err = tx.Exec(
+			`UPDATE member_otps SET attempts = attempts + 1,
+				used_at = CASE WHEN attempts + 1 >= $2 THEN CURRENT_TIMESTAMP ELSE used_at END
+			 WHERE id = $1`,
+			otpID, memberOTPMaxAttempts,
+		)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Error recording member OTP attempt: %v", err)
+		}
+		sendJSONError(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := tx.Query(
+		"SELECT id, name FROM members WHERE is_active = true AND mobile_no = $1 ORDER BY id",
+		mobile,
+	)
+	if err != nil {
+		log.Printf("Error fetching members for OTP login: %v", err)
+		sendJSONError(w, "Failed to verify code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	type memberChoice struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	var choices []memberChoice
+	for rows.Next() {
+		var c memberChoice
+		if err := rows.Scan(&c.ID, &c.Name); err != nil {
+			continue
+		}
+		choices = append(choices, c)
+	}
+	rows.Close()
+
+	if len(choices) == 0 {
+		sendJSONError(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+
+	memberID := choices[0].ID
+	memberName := choices[0].Name
+	if len(choices) > 1 {
+		memberID = 0
+		for _, c := range choices {
+			if c.ID == req.MemberID {
+				memberID, memberName = c.ID, c.Name
+			}
+		}
+		if memberID == 0 {
+			// The code stays valid so the caller can resubmit with a choice.
+			sendJSONResponse(w, map[string]interface{}{
+				"error":   "Several members share this mobile number. Choose one and submit the code again.",
+				"members": choices,
+			}, http.StatusConflict)
+			return
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE member_otps SET used_at = CURRENT_TIMESTAMP WHERE id = $1", otpID); err != nil {
+		log.Printf("Error consuming member OTP: %v", err)
+		sendJSONError(w, "Failed to verify code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing member OTP verification: %v", err)
+		sendJSONError(w, "Failed to verify code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := middleware.GenerateMemberToken(memberID)
+	if err != nil {
+		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"token":       token,
+		"expires_in":  int(middleware.MemberTokenTTL.Seconds()),
+		"member_id":   memberID,
+		"member_name": memberName,
+		"user_type":   middleware.RoleMember,
+	}, http.StatusOK)
+}
+
+// MemberLogout revokes the member's current access token.
+func (h *Handlers) MemberLogout(w http.ResponseWriter, r *http.Request) {
+	_, err := h.DB.Exec(
+		`INSERT INTO revoked_tokens (jti, member_id, expires_at)
+		 VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second')
+		 ON CONFLICT (jti) DO NOTHING`,
+		getTokenIDFromRequest(r), getMemberIDFromRequest(r), int(middleware.MemberTokenTTL.Seconds()),
+	)
+	if err != nil {
+		log.Printf("Error revoking member token: %v", err)
+		sendJSONError(w, "Failed to log out. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]string{"message": "Logged out"}, http.StatusOK)
+}
+
+func (h *Handlers) GetMyProfile(w http.ResponseWriter, r *http.Request) {
+	var m models.Member
+	err := scanMember(h.DB.QueryRow(
+		"SELECT "+memberColumns+" FROM "+memberFrom+" WHERE m.id = $1", getMemberIDFromRequest(r),
+	), &m)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching member profile: %v", err)
+		sendJSONError(w, "Failed to fetch profile. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, m, http.StatusOK)
+}
+
+func (h *Handlers) GetMyPayments(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT p.id, p.member_id, p.member_name, p.contact_no, p.amount, p.payer_member_id, p.payer_name, p.split_group_id,
+			` + paymentModeColumns + `, p.admin_id, u.username, p.payment_date, p.created_at, ` + paymentAllocationsColumn + `
+		FROM payments p
+		LEFT JOIN users u ON p.admin_id = u.id
+		WHERE p.member_id = $1
+		ORDER BY p.payment_date DESC
+	`
+
+	rows, err := h.DB.Query(query, getMemberIDFromRequest(r))
+	if err != nil {
+		log.Printf("Error fetching member payments: %v", err)
+		sendJSONError(w, "Failed to fetch payments. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var payments []models.Payment
+	for rows.Next() {
+		var p models.Payment
+		var allocations []byte
+		err := rows.Scan(
+			&p.ID, &p.MemberID, &p.MemberName, &p.ContactNo, &p.Amount, &p.PayerMemberID, &p.PayerName, &p.SplitGroupID,
+			&p.PaymentMode, &p.ReferenceNo, &p.ChequeStatus, &p.AdminID, &p.AdminName, &p.PaymentDate, &p.CreatedAt, &allocations,
+		)
+		if err == nil {
+			err = decodeAllocations(allocations, &p.Allocations)
+		}
+		if err != nil {
+			continue
+		}
+		payments = append(payments, p)
+	}
+
+	sendJSONResponse(w, payments, http.StatusOK)
+}
+
+// GetMyDues compares, month by month since the member joined, what their
+// contribution plan expected with what was allocated to that month.
+func (h *Handlers) GetMyDues(w http.ResponseWriter, r *http.Request) {
+	memberID := getMemberIDFromRequest(r)
+
+	query := `
+		SELECT TO_CHAR(month, 'YYYY-MM'), expected, received
+		FROM (
+			SELECT month,
+				member_expected_amount(m.id, month::date) as expected,
+				(SELECT COALESCE(SUM(a.amount), 0) FROM payment_allocations a
+				 INNER JOIN payments p ON p.id = a.payment_id
+				 WHERE p.member_id = m.id AND a.period = month::date AND ` + paymentCounts + `) as received
+			FROM members m,
+				generate_series(date_trunc('month', m.created_at), date_trunc('month', CURRENT_TIMESTAMP), INTERVAL '1 month') AS month
+			WHERE m.id = $1
+		) months
+		ORDER BY month
+	`
+
+	rows, err := h.DB.Query(query, memberID)
+	if err != nil {
+		log.Printf("Error fetching member dues: %v", err)
+		sendJSONError(w, "Failed to fetch dues. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	dues := models.MemberDues{
+		MemberID:     memberID,
+		UnpaidMonths: []string{},
+		Months:       []models.DuesMonth{},
+	}
+	for rows.Next() {
+		var month models.DuesMonth
+		if err := rows.Scan(&month.Month, &month.Expected, &month.Received); err != nil {
+			continue
+		}
+		if month.Received < month.Expected {
+			month.Due = month.Expected - month.Received
+			dues.UnpaidMonths = append(dues.UnpaidMonths, month.Month)
+			dues.TotalDue += month.Due
+		}
+		dues.Months = append(dues.Months, month)
+	}
+
+	dues.Plan, err = h.currentMemberPlan(memberID)
+	if err != nil {
+		log.Printf("Error fetching member plan: %v", err)
+		sendJSONError(w, "Failed to fetch dues. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, dues, http.StatusOK)
+}
+
+// GetMyReceipt returns the receipt for a payment made for or by the member.
+func (h *Handlers) GetMyReceipt(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	paymentID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	memberID := getMemberIDFromRequest(r)
+	h.sendReceipt(w, paymentID, "(p.member_id = $2 OR p.payer_member_id = $2)", memberID)
+}
+
+// sendReceipt writes the receipt for paymentID if it matches condition, in
+// which $2 is arg. A payment covering several members has one receipt
+// listing each share.
+func (h *Handlers) sendReceipt(w http.ResponseWriter, paymentID int, condition string, arg interface{}) {
+	var receipt models.PaymentReceipt
+	var groupID int
+	err := h.DB.QueryRow(`
+		SELECT p.id, p.member_id, p.member_name, p.contact_no, p.amount, p.payer_member_id, p.payer_name,
+			`+paymentModeColumns+`, COALESCE(p.split_group_id, p.id), u.username, p.payment_date
+		FROM payments p
+		LEFT JOIN users u ON p.admin_id = u.id
+		WHERE p.id = $1 AND `+condition,
+		paymentID, arg,
+	).Scan(
+		&receipt.PaymentID, &receipt.MemberID, &receipt.MemberName, &receipt.ContactNo, &receipt.Amount,
+		&receipt.PayerMemberID, &receipt.PayerName, &receipt.PaymentMode, &receipt.ReferenceNo, &receipt.ChequeStatus,
+		&groupID, &receipt.ReceivedBy, &receipt.PaymentDate,
+	)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching receipt: %v", err)
+		sendJSONError(w, "Failed to fetch receipt. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	receipt.CoveredMembers, err = coveredMembers(h.DB, groupID)
+	if err != nil {
+		log.Printf("Error fetching receipt: %v", err)
+		sendJSONError(w, "Failed to fetch receipt. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	for _, c := range receipt.CoveredMembers {
+		receipt.TotalAmount += c.Amount
+	}
+
+	receipt.ReceiptNumber = receiptNumber(groupID)
+	sendJSONResponse(w, receipt, http.StatusOK)
+}
+
+// coveredMembers lists the members covered by the payment groupID: every
+// row of a split, or the single payment itself.
+func coveredMembers(q queryer, groupID int) ([]models.CoveredMember, error) {
+	rows, err := q.Query(
+		"SELECT p.id, p.member_id, p.member_name, p.amount, "+paymentAllocationsColumn+
+			" FROM payments p WHERE p.id = $1 OR p.split_group_id = $1 ORDER BY p.id",
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	covered := []models.CoveredMember{}
+	for rows.Next() {
+		var c models.CoveredMember
+		var allocations []byte
+		if err := rows.Scan(&c.PaymentID, &c.MemberID, &c.MemberName, &c.Amount, &allocations); err != nil {
+			continue
+		}
+		if err := decodeAllocations(allocations, &c.Allocations); err != nil {
+			continue
+		}
+		covered = append(covered, c)
+	}
+	return covered, rows.Err()
+}
+
+func receiptNumber(paymentID int) string {
+	return fmt.Sprintf("KH-%06d", paymentID)
+}
+
+func memberOTPHash(mobile, code string) string {
+	return middleware.HashToken(mobile + ":" + code)
+}
+
+// generateNumericCode returns a random code of n decimal digits.
+func generateNumericCode(n int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < n; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, v), nil
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/models"
+	"github.com/khidmat/backend/internal/pdf"
+)
+
+const maxStatementMonths = 240
+
+// GetMemberStatement returns a member's ledger for ?from=YYYY-MM to
+// ?to=YYYY-MM (default: the month they joined to this month): the dues their
+// contribution plan generated, the payments received and the running
+// balance, with the arrears or advance credit at the end. ?format=pdf
+// returns it as a printable PDF.
+func (h *Handlers) GetMemberStatement(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	memberID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "pdf" {
+		sendJSONError(w, "format must be json or pdf", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeMember(w, r, memberID) {
+		return
+	}
+
+	statement := models.MemberStatement{MemberID: memberID, Entries: []models.StatementEntry{}}
+	var joined time.Time
+	err = h.DB.QueryRow(
+		"SELECT name, mobile_no, date_trunc('month', created_at) FROM members WHERE id = $1", memberID,
+	).Scan(&statement.MemberName, &statement.MobileNo, &joined)
+	if err != nil {
+		log.Printf("Error fetching member for statement: %v", err)
+		sendJSONError(w, "Failed to fetch statement. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	from, to := joined, firstOfMonth()
+	if raw := r.URL.Query().Get("from"); raw != "" {
+		if from, err = time.Parse("2006-01", raw); err != nil {
+			sendJSONError(w, "from must be in YYYY-MM format", http.StatusBadRequest)
+			return
+		}
+	}
+	if raw := r.URL.Query().Get("to"); raw != "" {
+		if to, err = time.Parse("2006-01", raw); err != nil {
+			sendJSONError(w, "to must be in YYYY-MM format", http.StatusBadRequest)
+			return
+		}
+	}
+	if to.Before(from) {
+		sendJSONError(w, "to must not be before from", http.StatusBadRequest)
+		return
+	}
+	if to.After(from.AddDate(0, maxStatementMonths-1, 0)) {
+		sendJSONError(w, "A statement can cover at most "+strconv.Itoa(maxStatementMonths)+" months", http.StatusBadRequest)
+		return
+	}
+	statement.From = from.Format("2006-01")
+	statement.To = to.Format("2006-01")
+
+	if err := h.buildMemberStatement(&statement, from, to); err != nil {
+		log.Printf("Error building member statement: %v", err)
+		sendJSONError(w, "Failed to fetch statement. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if format == "pdf" {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="statement-%d-%s-%s.pdf"`, memberID, statement.From, statement.To))
+		w.WriteHeader(http.StatusOK)
+		w.Write(renderMemberStatement(statement))
+		return
+	}
+
+	sendJSONResponse(w, statement, http.StatusOK)
+}
+
+// buildMemberStatement fills in the ledger for the months from to to. The
+// opening balance carries forward everything due and paid before from.
+func (h *Handlers) buildMemberStatement(statement *models.MemberStatement, from, to time.Time) error {
+	end := to.AddDate(0, 1, 0)
+
+	err := h.DB.QueryRow(`
+		SELECT
+			COALESCE((SELECT SUM(member_expected_amount(m.id, month::date))
+				FROM generate_series(date_trunc('month', m.created_at), $2::date - INTERVAL '1 month', INTERVAL '1 month') AS month), 0)
+			- COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.member_id = m.id AND p.payment_date < $2 AND `+paymentCounts+`), 0)
+		FROM members m
+		WHERE m.id = $1
+	`, statement.MemberID, from).Scan(&statement.OpeningBalance)
+	if err != nil {
+		return err
+	}
+
+	var dues []models.StatementEntry
+	rows, err := h.DB.Query(`
+		SELECT TO_CHAR(month, 'YYYY-MM-DD'), TO_CHAR(month, 'YYYY-MM'), member_expected_amount($1, month::date), COALESCE(cp.name, '')
+		FROM generate_series($2::date, $3::date, INTERVAL '1 month') AS month
+		LEFT JOIN contribution_plans cp ON cp.id = member_plan_at($1, month::date)
+		ORDER BY month
+	`, statement.MemberID, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.StatementEntry
+		var month, plan string
+		if err := rows.Scan(&e.Date, &month, &e.Debit, &plan); err != nil {
+			continue
+		}
+		if e.Debit <= 0 {
+			continue
+		}
+		e.Type = "due"
+		e.Description = "Contribution for " + month
+		if plan != "" {
+			e.Description += " (" + plan + ")"
+		}
+		dues = append(dues, e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var payments []models.StatementEntry
+	rows, err = h.DB.Query(`
+		SELECT p.id, TO_CHAR(p.payment_date, 'YYYY-MM-DD'), p.amount, COALESCE(u.username, ''),
+			p.payer_member_id, p.payer_name, COALESCE(p.split_group_id, p.id),
+			(SELECT COUNT(*) FROM payments s WHERE s.split_group_id = p.split_group_id),
+			COALESCE((SELECT string_agg(TO_CHAR(a.period, 'YYYY-MM'), ', ' ORDER BY a.period)
+				FROM payment_allocations a WHERE a.payment_id = p.id), '')
+		FROM payments p
+		LEFT JOIN users u ON p.admin_id = u.id
+		WHERE p.member_id = $1 AND p.payment_date >= $2 AND p.payment_date < $3 AND `+paymentCounts+`
+		ORDER BY p.payment_date, p.id
+	`, statement.MemberID, from, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.StatementEntry
+		var receivedBy, payerName, periods string
+		var payerID, groupID, covered int
+		if err := rows.Scan(&e.PaymentID, &e.Date, &e.Credit, &receivedBy, &payerID, &payerName, &groupID, &covered, &periods); err != nil {
+			continue
+		}
+		e.Type = "payment"
+		e.Description = "Payment " + receiptNumber(groupID)
+		if periods != "" {
+			e.Description += " for " + periods
+		}
+		if payerID != statement.MemberID {
+			e.PaidBy = payerName
+			e.Description += " paid by " + payerName
+		}
+		if covered > 1 {
+			e.Description += " (shared by " + strconv.Itoa(covered) + " members)"
+		}
+		if receivedBy != "" {
+			e.Description += ", received by " + receivedBy
+		}
+		payments = append(payments, e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Merge by date; a month's due comes before payments made on its first day.
+	balance := statement.OpeningBalance
+	for len(dues) > 0 || len(payments) > 0 {
+		var e models.StatementEntry
+		if len(payments) == 0 || (len(dues) > 0 && dues[0].Date <= payments[0].Date) {
+			e, dues = dues[0], dues[1:]
+		} else {
+			e, payments = payments[0], payments[1:]
+		}
+		balance = roundAmount(balance + e.Debit - e.Credit)
+		e.Balance = balance
+		statement.TotalDue += e.Debit
+		statement.TotalReceived += e.Credit
+		statement.Entries = append(statement.Entries, e)
+	}
+
+	statement.OpeningBalance = roundAmount(statement.OpeningBalance)
+	statement.TotalDue = roundAmount(statement.TotalDue)
+	statement.TotalReceived = roundAmount(statement.TotalReceived)
+	statement.ClosingBalance = roundAmount(balance)
+	if statement.ClosingBalance > 0 {
+		statement.Arrears = statement.ClosingBalance
+	} else {
+		statement.AdvanceCredit = -statement.ClosingBalance
+	}
+
+	return nil
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// renderMemberStatement lays the statement out as an A4 PDF.
+func renderMemberStatement(s models.MemberStatement) []byte {
+	const (
+		left       = 40.0
+		right      = pdf.PageWidth - 40
+		dueX       = 380.0
+		receivedX  = 460.0
+		bottom     = 60.0
+		lineHeight = 16.0
+	)
+
+	doc := pdf.New()
+	y := pdf.PageHeight - 60
+
+	doc.Text(left, y, pdf.HelveticaBold, 16, "Member Statement")
+	y -= 24
+	doc.Text(left, y, pdf.Helvetica, 10, s.MemberName+"  ("+s.MobileNo+")")
+	y -= 14
+	doc.Text(left, y, pdf.Helvetica, 10, "Period: "+s.From+" to "+s.To)
+	doc.TextRight(right, y, pdf.Helvetica, 10, "Generated "+time.Now().Format("2006-01-02"))
+	y -= 24
+
+	header := func() {
+		doc.Text(left, y, pdf.HelveticaBold, 10, "Date")
+		doc.Text(left+70, y, pdf.HelveticaBold, 10, "Description")
+		doc.TextRight(dueX, y, pdf.HelveticaBold, 10, "Due")
+		doc.TextRight(receivedX, y, pdf.HelveticaBold, 10, "Received")
+		doc.TextRight(right, y, pdf.HelveticaBold, 10, "Balance")
+		doc.Line(left, y-5, right, y-5)
+		y -= lineHeight + 4
+	}
+	row := func(date, description string, due, received, balance float64) {
+		if y < bottom {
+			doc.AddPage()
+			y = pdf.PageHeight - 60
+			header()
+		}
+		doc.Text(left, y, pdf.Helvetica, 9, date)
+		doc.Text(left+70, y, pdf.Helvetica, 9, description)
+		if due != 0 {
+			doc.TextRight(dueX, y, pdf.Helvetica, 9, formatAmount(due))
+		}
+		if received != 0 {
+			doc.TextRight(receivedX, y, pdf.Helvetica, 9, formatAmount(received))
+		}
+		doc.TextRight(right, y, pdf.Helvetica, 9, formatAmount(balance))
+		y -= lineHeight
+	}
+
+	header()
+	row(s.From+"-01", "Opening balance", 0, 0, s.OpeningBalance)
+	for _, e := range s.Entries {
+		row(e.Date, e.Description, e.Debit, e.Credit, e.Balance)
+	}
+
+	if y < bottom+5*lineHeight {
+		doc.AddPage()
+		y = pdf.PageHeight - 60
+	}
+	doc.Line(left, y+lineHeight-5, right, y+lineHeight-5)
+	y -= 4
+	for _, total := range []struct {
+		label  string
+		amount float64
+	}{
+		{"Total due", s.TotalDue},
+		{"Total received", s.TotalReceived},
+		{"Arrears", s.Arrears},
+		{"Advance credit", s.AdvanceCredit},
+	} {
+		doc.Text(receivedX-120, y, pdf.HelveticaBold, 10, total.label)
+		doc.TextRight(right, y, pdf.HelveticaBold, 10, formatAmount(total.amount))
+		y -= lineHeight
+	}
+	y -= 8
+	doc.Text(left, y, pdf.Helvetica, 8, "Amounts in Rs. A negative balance is advance credit.")
+
+	return doc.Bytes()
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/models"
+)
+
+// Member lifecycle states. Only active members are expected to contribute.
+const (
+	memberStatusActive   = "active"
+	memberStatusPaused   = "paused"
+	memberStatusExited   = "exited"
+	memberStatusDeceased = "deceased"
+)
+
+var memberStatuses = map[string]bool{
+	memberStatusActive:   true,
+	memberStatusPaused:   true,
+	memberStatusExited:   true,
+	memberStatusDeceased: true,
+}
+
+type memberStatusRequest struct {
+	Status        string `json:"status"`
+	EffectiveDate string `json:"effective_date"`
+	Reason        string `json:"reason"`
+}
+
+// ChangeMemberStatus moves a member to another lifecycle state from
+// effective_date (default today), recording the reason.
+func (h *Handlers) ChangeMemberStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	memberID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	var req memberStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !memberStatuses[req.Status] {
+		sendJSONError(w, "status must be active, paused, exited or deceased", http.StatusBadRequest)
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		sendJSONError(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+
+	h.changeMemberStatus(w, r, memberID, req)
+}
+
+// ToggleMemberStatus pauses an active member or reactivates a paused one,
+// effective today. Exited and deceased members must be changed explicitly.
+func (h *Handlers) ToggleMemberStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	memberID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	h.changeMemberStatus(w, r, memberID, memberStatusRequest{Reason: "Status toggled"})
+}
+
+// changeMemberStatus records a transition to req.Status, or for an empty
+// status toggles between active and paused. The member is locked while its
+// current state is read, so concurrent changes are applied one at a time.
+func (h *Handlers) changeMemberStatus(w http.ResponseWriter, r *http.Request, memberID int, req memberStatusRequest) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	effective := today
+	if req.EffectiveDate != "" {
+		var err error
+		effective, err = time.Parse("2006-01-02", req.EffectiveDate)
+		if err != nil {
+			sendJSONError(w, "effective_date must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		if effective.After(today) {
+			sendJSONError(w, "effective_date cannot be in the future", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting member status change: %v", err)
+		sendJSONError(w, "Failed to update member status. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var current string
+	var lastChange sql.NullTime
+	err = tx.QueryRow(
+		`SELECT m.status, (SELECT MAX(s.effective_date) FROM member_status_history s WHERE s.member_id = m.id)
+		 FROM members m
+		 WHERE m.id = $1 AND m.deleted_at IS NULL AND ($2 = 0 OR m.admin_id = $2)
+		 FOR UPDATE OF m`,
+		memberID, scopeAdminID(r),
+	).Scan(&current, &lastChange)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching member status: %v", err)
+		sendJSONError(w, "Failed to update member status. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if current == memberStatusDeceased {
+		sendJSONError(w, "The status of a deceased member cannot be changed", http.StatusConflict)
+		return
+	}
+
+	if req.Status == "" {
+		if current == memberStatusExited {
+			sendJSONError(w, "Exited members must be reactivated with a reason", http.StatusConflict)
+			return
+		}
+		req.Status = memberStatusActive
+		if current == memberStatusActive {
+			req.Status = memberStatusPaused
+		}
+	}
+
+	if req.Status == current {
+		sendJSONError(w, "Member is already "+current, http.StatusBadRequest)
+		return
+	}
+
+	if lastChange.Valid && effective.Before(lastChange.Time) {
+		sendJSONError(w, "effective_date is before the member's previous status change", http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO member_status_history (member_id, status, effective_date, reason, changed_by)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		memberID, req.Status, effective, req.Reason, getUserIDFromRequest(r),
+	)
+	if err == nil {
+		_, err = tx.Exec("UPDATE members SET status = $1, is_active = $2 WHERE id = $3", req.Status, req.Status == memberStatusActive, memberID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error updating member status: %v", err)
+		sendJSONError(w, "Failed to update member status. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"id":             memberID,
+		"status":         req.Status,
+		"is_active":      req.Status == memberStatusActive,
+		"effective_date": effective.Format("2006-01-02"),
+	}, http.StatusOK)
+}
+
+// GetMemberStatusHistory lists a member's lifecycle changes, oldest first.
+func (h *Handlers) GetMemberStatusHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	memberID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeMember(w, r, memberID) {
+		return
+	}
+
+	query := `
+		SELECT s.id, s.member_id, s.status, s.effective_date, s.reason,
+			COALESCE(s.changed_by, 0), COALESCE(u.username, ''), s.created_at
+		FROM member_status_history s
+		LEFT JOIN users u ON s.changed_by = u.id
+		WHERE s.member_id = $1
+		ORDER BY s.effective_date, s.id
+	`
+
+	rows, err := h.DB.Query(query, memberID)
+	if err != nil {
+		log.Printf("Error fetching member status history: %v", err)
+		sendJSONError(w, "Failed to fetch member status history. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []models.MemberStatusChange{}
+	for rows.Next() {
+		var c models.MemberStatusChange
+		err := rows.Scan(&c.ID, &c.MemberID, &c.Status, &c.EffectiveDate, &c.Reason, &c.ChangedBy, &c.ChangedByName, &c.CreatedAt)
+		if err != nil {
+			continue
+		}
+		history = append(history, c)
+	}
+
+	sendJSONResponse(w, history, http.StatusOK)
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
+)
+
+const maxBulkTransferMembers = 1000
+
+type memberTransferRequest struct {
+	MemberIDs     []int  `json:"member_ids"`
+	ToAdminID     int    `json:"to_admin_id"`
+	EffectiveDate string `json:"effective_date"`
+	Reason        string `json:"reason"`
+}
+
+// TransferMember reassigns one member to another account admin.
+func (h *Handlers) TransferMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	memberID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	var req memberTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.MemberIDs = []int{memberID}
+
+	h.transferMembers(w, r, req)
+}
+
+// TransferMembers reassigns several members to another account admin, all or
+// none of them.
+func (h *Handlers) TransferMembers(w http.ResponseWriter, r *http.Request) {
+	var req memberTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.MemberIDs) == 0 || len(req.MemberIDs) > maxBulkTransferMembers {
+		sendJSONError(w, "member_ids must list between 1 and "+strconv.Itoa(maxBulkTransferMembers)+" members", http.StatusBadRequest)
+		return
+	}
+
+	h.transferMembers(w, r, req)
+}
+
+// transferMembers moves req.MemberIDs to req.ToAdminID and records the
+// transfers. The effective date defaults to today and may be backdated, but
+// not before a member's previous transfer or into the future.
+func (h *Handlers) transferMembers(w http.ResponseWriter, r *http.Request, req memberTransferRequest) {
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		sendJSONError(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	effective := today
+	if req.EffectiveDate != "" {
+		var err error
+		effective, err = time.Parse("2006-01-02", req.EffectiveDate)
+		if err != nil {
+			sendJSONError(w, "effective_date must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		if effective.After(today) {
+			sendJSONError(w, "effective_date cannot be in the future", http.StatusBadRequest)
+			return
+		}
+	}
+
+	seen := make(map[int]bool, len(req.MemberIDs))
+	var memberIDs []int
+	for _, id := range req.MemberIDs {
+		if !seen[id] {
+			seen[id] = true
+			memberIDs = append(memberIDs, id)
+		}
+	}
+
+	if !h.authorizeMemberOwner(w, r, req.ToAdminID) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting member transfer: %v", err)
+		sendJSONError(w, "Failed to transfer members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT m.id, m.admin_id, (SELECT MAX(t.effective_date) FROM member_transfers t WHERE t.member_id = m.id)
+		 FROM members m
+		 WHERE m.id = ANY($1) AND m.deleted_at IS NULL
+		 ORDER BY m.id
+		 FOR UPDATE OF m`,
+		pq.Array(memberIDs),
+	)
+	if err != nil {
+		log.Printf("Error locking members for transfer: %v", err)
+		sendJSONError(w, "Failed to transfer members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	fromAdmin := make(map[int]int, len(memberIDs))
+	var problem string
+	for rows.Next() {
+		var id, adminID int
+		var lastTransfer sql.NullTime
+		if err := rows.Scan(&id, &adminID, &lastTransfer); err != nil {
+			continue
+		}
+		fromAdmin[id] = adminID
+		switch {
+		case adminID == req.ToAdminID:
+			problem = "Member " + strconv.Itoa(id) + " already belongs to this admin"
+		case lastTransfer.Valid && effective.Before(lastTransfer.Time):
+			problem = "effective_date is before the previous transfer of member " + strconv.Itoa(id)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		log.Printf("Error fetching members for transfer: %v", err)
+		sendJSONError(w, "Failed to transfer members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if len(fromAdmin) != len(memberIDs) {
+		for _, id := range memberIDs {
+			if _, ok := fromAdmin[id]; !ok {
+				sendJSONError(w, "Member "+strconv.Itoa(id)+" not found", http.StatusNotFound)
+				return
+			}
+		}
+	}
+	if problem != "" {
+		sendJSONError(w, problem, http.StatusBadRequest)
+		return
+	}
+
+	callerID := getUserIDFromRequest(r)
+	for _, id := range memberIDs {
+		_, err = tx.Exec(
+			`INSERT INTO member_transfers (member_id, from_admin_id, to_admin_id, effective_date, reason, transferred_by)
+			 VALUES ($1, $2, $3, $4, $5, $6)`,
+			id, fromAdmin[id], req.ToAdminID, effective, req.Reason, callerID,
+		)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE members SET admin_id = $1 WHERE id = ANY($2)", req.ToAdminID, pq.Array(memberIDs))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error transferring members: %v", err)
+		sendJSONError(w, "Failed to transfer members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Transferred %d members to admin %d", len(memberIDs), req.ToAdminID)
+
+	sendJSONResponse(w, map[string]interface{}{
+		"member_ids":     memberIDs,
+		"to_admin_id":    req.ToAdminID,
+		"effective_date": effective.Format("2006-01-02"),
+		"transferred":    len(memberIDs),
+	}, http.StatusOK)
+}
+
+// GetMemberTransfers lists a member's transfers, oldest first.
+func (h *Handlers) GetMemberTransfers(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	memberID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeMember(w, r, memberID) {
+		return
+	}
+
+	query := `
+		SELECT t.id, t.member_id, t.from_admin_id, COALESCE(fu.username, ''), t.to_admin_id, COALESCE(tu.username, ''),
+			t.effective_date, t.reason, COALESCE(t.transferred_by, 0), COALESCE(bu.username, ''), t.created_at
+		FROM member_transfers t
+		LEFT JOIN users fu ON t.from_admin_id = fu.id
+		LEFT JOIN users tu ON t.to_admin_id = tu.id
+		LEFT JOIN users bu ON t.transferred_by = bu.id
+		WHERE t.member_id = $1
+		ORDER BY t.effective_date, t.id
+	`
+
+	rows, err := h.DB.Query(query, memberID)
+	if err != nil {
+		log.Printf("Error fetching member transfers: %v", err)
+		sendJSONError(w, "Failed to fetch member transfers. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	transfers := []models.MemberTransfer{}
+	for rows.Next() {
+		var t models.MemberTransfer
+		err := rows.Scan(
+			&t.ID, &t.MemberID, &t.FromAdminID, &t.FromAdminName, &t.ToAdminID, &t.ToAdminName,
+			&t.EffectiveDate, &t.Reason, &t.TransferredBy, &t.TransferredByName, &t.CreatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		transfers = append(transfers, t)
+	}
+
+	sendJSONResponse(w, transfers, http.StatusOK)
+}
@@ -1,15 +1,121 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
 )
 
+const maxMemberNameLength = 255
+
+const (
+	memberColumns = `m.id, m.name, m.mobile_no, m.address, m.admin_id, u.username, m.household_id, m.status, m.is_active,
+		` + memberAttributeColumns + `, m.created_at, m.updated_at`
+	memberFrom = `members m LEFT JOIN users u ON m.admin_id = u.id`
+)
+
+var memberListSpec = listSpec{
+	from:          memberFrom,
+	columns:       memberColumns,
+	idColumn:      "m.id",
+	searchColumns: []string{"m.name", "m.mobile_no", "m.address", "u.username"},
+	adminColumn:   "m.admin_id",
+	statusColumn:  "m.is_active",
+	dateColumn:    "m.created_at",
+	sortFields: map[string]listSortField{
+		"name":       {"m.name", "text"},
+		"admin_name": {"COALESCE(u.username, '')", "text"},
+		"created_at": {"m.created_at", "timestamp"},
+		"updated_at": {"m.updated_at", "timestamp"},
+	},
+	defaultSort: "-created_at",
+}
+
+// scanMember reads a row starting with memberColumns; extra receives any
+// columns selected after them.
+func scanMember(row interface{ Scan(...interface{}) error }, m *models.Member, extra ...interface{}) error {
+	var adminName sql.NullString
+	var customFields []byte
+	dest := []interface{}{
+		&m.ID, &m.Name, &m.MobileNo, &m.Address, &m.AdminID, &adminName, &m.HouseholdID, &m.Status, &m.IsActive,
+		&customFields, pq.Array(&m.Tags), &m.CreatedAt, &m.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	m.AdminName = adminName.String
+	return decodeMemberAttributes(customFields, &m.CustomFields, &m.Tags)
+}
+
+// memberWriteResponse is a created or updated member. When duplicate mobile
+// numbers are only warned about, it lists the other members sharing one.
+type memberWriteResponse struct {
+	models.Member
+	PossibleDuplicates []duplicateMember `json:"possible_duplicates,omitempty"`
+}
+
+// validateMember trims the member's fields in place, normalises the mobile
+// number to E.164 and checks them.
+func validateMember(m *models.Member, countryCode string) error {
+	m.Name = strings.TrimSpace(m.Name)
+	m.Address = strings.TrimSpace(m.Address)
+
+	if m.Name == "" {
+		return errors.New("Name is required")
+	}
+	if len(m.Name) > maxMemberNameLength {
+		return errors.New("Name is too long")
+	}
+
+	mobile, err := normalizeMobile(m.MobileNo, countryCode)
+	if err != nil {
+		return err
+	}
+	m.MobileNo = mobile
+
+	if m.Address == "" {
+		return errors.New("Address is required")
+	}
+	return nil
+}
+
+// checkMobileDuplicates looks for other members with m's mobile number. When
+// the policy blocks duplicates and there are some, it writes a 409 response
+// and returns false.
+func checkMobileDuplicates(w http.ResponseWriter, r *http.Request, tx *sql.Tx, policy mobilePolicy, m *models.Member) ([]duplicateMember, bool) {
+	if err := lockMobile(tx, m.MobileNo); err != nil {
+		log.Printf("Error locking member mobile number: %v", err)
+		sendJSONError(w, "Failed to save member. Please try again later.", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	duplicates, err := findMobileDuplicates(tx, scopeAdminID(r), m.MobileNo, m.ID)
+	if err != nil {
+		log.Printf("Error checking duplicate mobile numbers: %v", err)
+		sendJSONError(w, "Failed to save member. Please try again later.", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if policy.blockDuplicates && len(duplicates) > 0 {
+		sendJSONResponse(w, map[string]interface{}{
+			"error":      "A member with this mobile number is already registered",
+			"duplicates": duplicates,
+		}, http.StatusConflict)
+		return nil, false
+	}
+
+	return duplicates, true
+}
+
 func (h *Handlers) CreateMember(w http.ResponseWriter, r *http.Request) {
 	var member models.Member
 	if err := json.NewDecoder(r.Body).Decode(&member); err != nil {
@@ -23,11 +129,58 @@ func (h *Handlers) CreateMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var memberID int
-	err := h.DB.QueryRow(
+	policy, err := h.getMobilePolicy()
+	if err != nil {
+		log.Printf("Error reading mobile number settings: %v", err)
+		sendJSONError(w, "Failed to create member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	member.ID = 0
+	if err := validateMember(&member, policy.countryCode); err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fields, err := loadMemberFields(h.DB)
+	if err != nil {
+		log.Printf("Error fetching member fields: %v", err)
+		sendJSONError(w, "Failed to create member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	attributes, err := validateMemberAttributes(fields, member.CustomFields, member.Tags)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting member creation: %v", err)
+		sendJSONError(w, "Failed to create member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	duplicates, ok := checkMobileDuplicates(w, r, tx, policy, &member)
+	if !ok {
+		return
+	}
+
+	err = tx.QueryRow(
 		"INSERT INTO members (name, mobile_no, address, admin_id) VALUES ($1, $2, $3, $4) RETURNING id",
 		member.Name, member.MobileNo, member.Address, adminID,
-	).Scan(&memberID)
+	).Scan(&member.ID)
+	if err == nil {
+		err = attributes.save(tx, member.ID)
+	}
+	if err == nil {
+		err = scanMember(tx.QueryRow("SELECT "+memberColumns+" FROM "+memberFrom+" WHERE m.id = $1", member.ID), &member)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 
 	if err != nil {
 		log.Printf("Error creating member: %v", err)
@@ -35,44 +188,115 @@ func (h *Handlers) CreateMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	member.ID = memberID
-	member.AdminID = adminID
-
-	sendJSONResponse(w, member, http.StatusCreated)
+	sendJSONResponse(w, memberWriteResponse{Member: member, PossibleDuplicates: duplicates}, http.StatusCreated)
 }
 
+// GetMembers lists members a page at a time; parseListQuery and
+// memberAttributeFilter document the parameters.
 func (h *Handlers) GetMembers(w http.ResponseWriter, r *http.Request) {
-	query := `
-		SELECT m.id, m.name, m.mobile_no, m.address, m.admin_id, u.username, m.is_active, m.created_at, m.updated_at
-		FROM members m
-		LEFT JOIN users u ON m.admin_id = u.id
-		ORDER BY m.created_at DESC
-	`
+	lq, ok := h.memberListQuery(w, r)
+	if !ok {
+		return
+	}
+
+	members := []models.Member{}
+	total, next, err := lq.fetch(h.DB, func(row rowScanner) (int, error) {
+		var m models.Member
+		if err := scanMember(row, &m); err != nil {
+			return 0, err
+		}
+		members = append(members, m)
+		return m.ID, nil
+	})
 
-	rows, err := h.DB.Query(query)
 	if err != nil {
 		log.Printf("Error fetching members: %v", err)
 		sendJSONError(w, "Failed to fetch members. Please try again later.", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+
+	sendJSONResponse(w, listPage{Items: members, Total: total, NextCursor: next}, http.StatusOK)
+}
+
+// ExportMembers writes every member matching the GetMembers filters as CSV,
+// with a column per custom field and the member's tags.
+func (h *Handlers) ExportMembers(w http.ResponseWriter, r *http.Request) {
+	lq, ok := h.memberListQuery(w, r)
+	if !ok {
+		return
+	}
+
+	fields, err := loadMemberFields(h.DB)
+	if err != nil {
+		log.Printf("Error fetching member fields: %v", err)
+		sendJSONError(w, "Failed to export members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
 
 	var members []models.Member
-	for rows.Next() {
+	err = lq.fetchAll(h.DB, func(row rowScanner) {
 		var m models.Member
-		err := rows.Scan(
-			&m.ID, &m.Name, &m.MobileNo, &m.Address, &m.AdminID, &m.AdminName, &m.IsActive, &m.CreatedAt, &m.UpdatedAt,
-		)
-		if err != nil {
-			continue
+		if err := scanMember(row, &m); err == nil {
+			members = append(members, m)
 		}
-		members = append(members, m)
+	})
+	if err != nil {
+		log.Printf("Error exporting members: %v", err)
+		sendJSONError(w, "Failed to export members. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	header := []string{"ID", "Name", "Mobile No", "Address", "Admin", "Status", "Registered"}
+	for _, f := range fields {
+		header = append(header, f.Label)
 	}
+	header = append(header, "Tags")
 
-	sendJSONResponse(w, members, http.StatusOK)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="members.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	out.Write(header)
+	for _, m := range members {
+		record := []string{
+			strconv.Itoa(m.ID), m.Name, m.MobileNo, m.Address, m.AdminName, m.Status, m.CreatedAt.Format("2006-01-02"),
+		}
+		for _, f := range fields {
+			record = append(record, m.CustomFields[f.Key])
+		}
+		record = append(record, strings.Join(m.Tags, ", "))
+		out.Write(record)
+	}
+	out.Flush()
 }
 
-func (h *Handlers) ToggleMemberStatus(w http.ResponseWriter, r *http.Request) {
+// memberListQuery parses the member list parameters of r and limits the
+// list to undeleted members the caller may see. When the parameters are
+// invalid it writes an error response and returns false.
+func (h *Handlers) memberListQuery(w http.ResponseWriter, r *http.Request) (*listQuery, bool) {
+	lq, err := parseListQuery(r, &memberListSpec)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	lq.where("m.deleted_at IS NULL")
+	scope := lq.arg(scopeAdminID(r))
+	lq.where("(" + scope + " = 0 OR m.admin_id = " + scope + ")")
+
+	conditions, ok := h.memberAttributeFilter(w, r, lq.arg)
+	if !ok {
+		return nil, false
+	}
+	for _, condition := range conditions {
+		lq.where(condition)
+	}
+
+	return lq, true
+}
+
+func (h *Handlers) GetMember(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	memberID, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -80,23 +304,225 @@ func (h *Handlers) ToggleMemberStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var isActive bool
-	err = h.DB.QueryRow("SELECT is_active FROM members WHERE id = $1", memberID).Scan(&isActive)
+	var m models.Member
+	err = scanMember(h.DB.QueryRow(
+		`SELECT `+memberColumns+` FROM `+memberFrom+`
+		 WHERE m.id = $1 AND m.deleted_at IS NULL AND ($2 = 0 OR m.admin_id = $2)`,
+		memberID, scopeAdminID(r),
+	), &m)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching member: %v", err)
+		sendJSONError(w, "Failed to fetch member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, m, http.StatusOK)
+}
+
+// UpdateMember changes any of name, mobile_no and address; omitted fields
+// keep their current value. custom_fields sets the given fields (an empty
+// value clears one) and tags, when present, replaces the member's tags.
+func (h *Handlers) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	memberID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		log.Printf("Error fetching member for status toggle: %v", err)
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Name         *string           `json:"name"`
+		MobileNo     *string           `json:"mobile_no"`
+		Address      *string           `json:"address"`
+		CustomFields map[string]string `json:"custom_fields"`
+		Tags         *[]string         `json:"tags"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	fields, err := loadMemberFields(h.DB)
+	if err != nil {
+		log.Printf("Error fetching member fields: %v", err)
+		sendJSONError(w, "Failed to update member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	var tags []string
+	if req.Tags != nil {
+		tags = append([]string{}, *req.Tags...)
+	}
+	attributes, err := validateMemberAttributes(fields, req.CustomFields, tags)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	policy, err := h.getMobilePolicy()
+	if err != nil {
+		log.Printf("Error reading mobile number settings: %v", err)
+		sendJSONError(w, "Failed to update member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting member update: %v", err)
+		sendJSONError(w, "Failed to update member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	m := models.Member{ID: memberID}
+	err = tx.QueryRow(
+		`SELECT name, mobile_no, address FROM members
+		 WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR admin_id = $2)
+		 FOR UPDATE`,
+		memberID, scopeAdminID(r),
+	).Scan(&m.Name, &m.MobileNo, &m.Address)
+
+	if err == sql.ErrNoRows {
 		sendJSONError(w, "Member not found", http.StatusNotFound)
 		return
 	}
 
-	_, err = h.DB.Exec("UPDATE members SET is_active = $1 WHERE id = $2", !isActive, memberID)
 	if err != nil {
-		log.Printf("Error updating member status: %v", err)
-		sendJSONError(w, "Failed to update member status. Please try again later.", http.StatusInternalServerError)
+		log.Printf("Error fetching member for update: %v", err)
+		sendJSONError(w, "Failed to update member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if req.Name != nil {
+		m.Name = *req.Name
+	}
+	if req.MobileNo != nil {
+		m.MobileNo = *req.MobileNo
+	}
+	if req.Address != nil {
+		m.Address = *req.Address
+	}
+
+	previousMobile := m.MobileNo
+	if err := validateMember(&m, policy.countryCode); err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var duplicates []duplicateMember
+	if m.MobileNo != previousMobile {
+		var ok bool
+		if duplicates, ok = checkMobileDuplicates(w, r, tx, policy, &m); !ok {
+			return
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE members SET name = $1, mobile_no = $2, address = $3 WHERE id = $4",
+		m.Name, m.MobileNo, m.Address, memberID,
+	)
+	if err == nil {
+		err = attributes.save(tx, memberID)
+	}
+	if err == nil {
+		err = scanMember(tx.QueryRow("SELECT "+memberColumns+" FROM "+memberFrom+" WHERE m.id = $1", memberID), &m)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error updating member: %v", err)
+		sendJSONError(w, "Failed to update member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, memberWriteResponse{Member: m, PossibleDuplicates: duplicates}, http.StatusOK)
+}
+
+// DeleteMember soft-deletes a member: the row and its payments are kept for
+// reports and receipts, but the member disappears from member endpoints, can
+// no longer receive payments and loses access to the member portal. An
+// active or paused member exits from today. It also leaves its household; a
+// household's payer cannot be deleted.
+func (h *Handlers) DeleteMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	memberID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeMember(w, r, memberID) {
+		return
+	}
+
+	var paysForHousehold bool
+	err = h.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM households WHERE payer_member_id = $1)", memberID).Scan(&paysForHousehold)
+	if err != nil {
+		log.Printf("Error checking household payer: %v", err)
+		sendJSONError(w, "Failed to delete member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	if paysForHousehold {
+		sendJSONError(w, "This member pays for a household; choose another payer first", http.StatusConflict)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting member delete: %v", err)
+		sendJSONError(w, "Failed to delete member. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow(
+		`SELECT status FROM members
+		 WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR admin_id = $2)
+		 FOR UPDATE`,
+		memberID, scopeAdminID(r),
+	).Scan(&status)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	// A deleted member exits, keeping is_active equal to status = 'active'.
+	if err == nil && (status == memberStatusActive || status == memberStatusPaused) {
+		status = memberStatusExited
+		_, err = tx.Exec(
+			`INSERT INTO member_status_history (member_id, status, effective_date, reason, changed_by)
+			 VALUES ($1, $2, CURRENT_DATE, 'Deleted', $3)`,
+			memberID, status, getUserIDFromRequest(r),
+		)
+	}
+	if err == nil {
+		_, err = tx.Exec(
+			`UPDATE members SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $2, status = $3, is_active = false, household_id = NULL
+			 WHERE id = $1`,
+			memberID, getUserIDFromRequest(r), status,
+		)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error deleting member: %v", err)
+		sendJSONError(w, "Failed to delete member. Please try again later.", http.StatusInternalServerError)
 		return
 	}
 
 	sendJSONResponse(w, map[string]interface{}{
-		"id":        memberID,
-		"is_active": !isActive,
+		"id":      memberID,
+		"deleted": true,
 	}, http.StatusOK)
 }
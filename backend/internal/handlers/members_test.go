@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+func TestDeleteMemberOfAnotherAdmin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Member 12 belongs to admin 3 and pays for a household; admin 5 must
+	// not learn either.
+	mock.ExpectQuery("SELECT admin_id FROM members").WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"admin_id"}).AddRow(3))
+
+	r := httptest.NewRequest(http.MethodDelete, "/api/members/12", nil)
+	r.Header.Set("X-User-ID", "5")
+	r = mux.SetURLVars(r, map[string]string{"id": "12"})
+	w := httptest.NewRecorder()
+	(&Handlers{DB: db}).DeleteMember(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteMemberRecordsExit(t *testing.T) {
+	tests := []struct {
+		status     string
+		wantStatus string
+		wantExit   bool
+	}{
+		{memberStatusActive, memberStatusExited, true},
+		{memberStatusPaused, memberStatusExited, true},
+		{memberStatusExited, memberStatusExited, false},
+		{memberStatusDeceased, memberStatusDeceased, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT admin_id FROM members").WithArgs(12).
+				WillReturnRows(sqlmock.NewRows([]string{"admin_id"}).AddRow(5))
+			mock.ExpectQuery("FROM households WHERE payer_member_id").WithArgs(12).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT status FROM members").WithArgs(12, 5).
+				WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(tt.status))
+			if tt.wantExit {
+				mock.ExpectExec("INSERT INTO member_status_history").WithArgs(12, memberStatusExited, 5).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+			mock.ExpectExec("UPDATE members SET deleted_at").WithArgs(12, 5, tt.wantStatus).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			r := httptest.NewRequest(http.MethodDelete, "/api/members/12", nil)
+			r.Header.Set("X-User-ID", "5")
+			r = mux.SetURLVars(r, map[string]string{"id": "12"})
+			w := httptest.NewRecorder()
+			(&Handlers{DB: db}).DeleteMember(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Member mobile numbers are stored in E.164 form, e.g. +919812345678, so the
+// same number is recognised however it was typed.
+const (
+	settingDefaultCountryCode    = "default_country_code"
+	settingDuplicateMobilePolicy = "duplicate_mobile_policy"
+
+	duplicateMobileWarn  = "warn"
+	duplicateMobileBlock = "block"
+
+	maxNationalNumberDigits = 10
+	minE164Digits           = 8
+	maxE164Digits           = 15
+)
+
+// mobilePolicy is the organisation's configuration for member mobile
+// numbers.
+type mobilePolicy struct {
+	countryCode     string
+	blockDuplicates bool
+}
+
+func (h *Handlers) getMobilePolicy() (mobilePolicy, error) {
+	countryCode, err := h.getSetting(settingDefaultCountryCode)
+	if err != nil {
+		return mobilePolicy{}, err
+	}
+	duplicates, err := h.getSetting(settingDuplicateMobilePolicy)
+	if err != nil {
+		return mobilePolicy{}, err
+	}
+	return mobilePolicy{countryCode: countryCode, blockDuplicates: duplicates == duplicateMobileBlock}, nil
+}
+
+func isCountryCodeSetting(value string) bool {
+	if len(value) < 1 || len(value) > 3 || value[0] == '0' {
+		return false
+	}
+	return strings.Trim(value, "0123456789") == ""
+}
+
+func isDuplicateMobilePolicySetting(value string) bool {
+	return value == duplicateMobileWarn || value == duplicateMobileBlock
+}
+
+// normalizeMobile returns raw in E.164 form. Numbers with a + or 00 prefix
+// are international; otherwise a number of up to ten digits (after dropping
+// a trunk 0) is national to countryCode, and a longer one is taken to start
+// with its country code.
+func normalizeMobile(raw, countryCode string) (string, error) {
+	raw = strings.TrimSpace(raw)
+
+	international := strings.HasPrefix(raw, "+")
+	var digits strings.Builder
+	for i, c := range raw {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c == '+' && i == 0:
+		case c == ' ' || c == '-' || c == '(' || c == ')' || c == '.':
+		default:
+			return "", errors.New("Mobile number may only contain digits, spaces, '+', '-' and parentheses")
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case international:
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	default:
+		national := strings.TrimLeft(number, "0")
+		if len(national) <= maxNationalNumberDigits {
+			number = countryCode + national
+		}
+	}
+
+	if len(number) < minE164Digits || len(number) > maxE164Digits || number[0] == '0' {
+		return "", errors.New("Enter a valid mobile number, with a + and country code for numbers from other countries")
+	}
+	return "+" + number, nil
+}
+
+var errInvalidMobile = errors.New("invalid mobile number")
+
+// normalizeMemberMobile normalises a number typed into the member portal,
+// returning errInvalidMobile when it cannot be a member's number.
+func (h *Handlers) normalizeMemberMobile(raw string) (string, error) {
+	countryCode, err := h.getSetting(settingDefaultCountryCode)
+	if err != nil {
+		return "", err
+	}
+	mobile, err := normalizeMobile(raw, countryCode)
+	if err != nil {
+		return "", errInvalidMobile
+	}
+	return mobile, nil
+}
+
+// duplicateMember is another member registered with the same mobile number.
+// Only the owning admin is shown for members outside the caller's scope.
+type duplicateMember struct {
+	ID        int    `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	AdminID   int    `json:"admin_id"`
+	AdminName string `json:"admin_name"`
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// findMobileDuplicates lists the members other than excludeID registered
+// with mobile. scope is the caller's scopeAdminID.
+func findMobileDuplicates(q queryer, scope int, mobile string, excludeID int) ([]duplicateMember, error) {
+	rows, err := q.Query(
+		`SELECT m.id, m.name, m.admin_id, COALESCE(u.username, '')
+		 FROM `+memberFrom+`
+		 WHERE m.mobile_no = $1 AND m.id <> $2 AND m.deleted_at IS NULL
+		 ORDER BY m.id`,
+		mobile, excludeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var duplicates []duplicateMember
+	for rows.Next() {
+		var d duplicateMember
+		if err := rows.Scan(&d.ID, &d.Name, &d.AdminID, &d.AdminName); err != nil {
+			continue
+		}
+		if scope != 0 && scope != d.AdminID {
+			d.ID, d.Name = 0, ""
+		}
+		duplicates = append(duplicates, d)
+	}
+	return duplicates, rows.Err()
+}
+
+// lockMobile serialises writes of members with the same mobile number until
+// the transaction ends, so the duplicate check cannot race.
+func lockMobile(tx *sql.Tx, mobile string) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('member_mobile:' || $1))", mobile)
+	return err
+}
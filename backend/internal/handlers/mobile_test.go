@@ -0,0 +1,46 @@
+package handlers
+
+import "testing"
+
+func TestNormalizeMobile(t *testing.T) {
+	tests := []struct {
+		raw         string
+		countryCode string
+		want        string
+		wantErr     bool
+	}{
+		{"9812345678", "91", "+919812345678", false},
+		{"09812345678", "91", "+919812345678", false},
+		{" 98123-45678 ", "91", "+919812345678", false},
+		{"(981) 234.5678", "91", "+919812345678", false},
+		{"+91 98123 45678", "1", "+919812345678", false},
+		{"0091 9812345678", "1", "+919812345678", false},
+		{"919812345678", "1", "+919812345678", false},
+		{"07911 123456", "44", "+447911123456", false},
+		{"2025550123", "1", "+12025550123", false},
+		{"98123a45678", "91", "", true},
+		{"98+12345678", "91", "", true},
+		{"+0123456789", "91", "", true},
+		{"123", "91", "", true},
+		{"+1234567890123456", "91", "", true},
+		{"", "91", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizeMobile(tt.raw, tt.countryCode)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizeMobile(%q, %q) = %q, %v; want %q, error %v", tt.raw, tt.countryCode, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestIsCountryCodeSetting(t *testing.T) {
+	for value, want := range map[string]bool{
+		"91": true, "1": true, "971": true,
+		"": false, "0": false, "091": false, "1234": false, "+91": false,
+	} {
+		if got := isCountryCodeSetting(value); got != want {
+			t.Errorf("isCountryCodeSetting(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
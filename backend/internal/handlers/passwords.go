@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/notify"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength     = 8
+	passwordResetTTL      = time.Hour
+	passwordResetCooldown = 5 * time.Minute
+	passwordResetIPMax    = 5
+	defaultResetURL       = "http://localhost:3000/reset-password"
+)
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("Password must be at least %d characters long", minPasswordLength)
+	}
+	return nil
+}
+
+// ChangePassword lets an authenticated user set a new password after proving
+// the current one. All existing sessions are revoked and a fresh one issued.
+func (h *Handlers) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userID := getUserIDFromRequest(r)
+	if userID == 0 {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var passwordHash, userType string
+	err := h.DB.QueryRow("SELECT password_hash, user_type FROM users WHERE id = $1", userID).Scan(&passwordHash, &userType)
+	if err != nil {
+		log.Printf("Error fetching user for password change: %v", err)
+		sendJSONError(w, "Failed to change password. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.CurrentPassword)); err != nil {
+		sendJSONError(w, "Current password is incorrect", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.setPassword(userID, req.NewPassword, 0); err != nil {
+		log.Printf("Error changing password: %v", err)
+		sendJSONError(w, "Failed to change password. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	session, err := h.issueSession(r, userID, userType)
+	if err != nil {
+		log.Printf("Error issuing session: %v", err)
+		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, session, http.StatusOK)
+}
+
+// ForgotPassword sends a single-use reset link to the account's email. The
+// response is identical whether or not the account exists. An account gets at
+// most one link per passwordResetCooldown, and one address may request only
+// passwordResetIPMax links per ipFailureWindow.
+func (h *Handlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		sendJSONError(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	var ipResets int
+	err := h.DB.QueryRow(
+		`SELECT COUNT(*) FROM password_resets
+		 WHERE ip_address = $1 AND created_at > CURRENT_TIMESTAMP - $2 * INTERVAL '1 second'`,
+		clientIP(r), int(ipFailureWindow.Seconds()),
+	).Scan(&ipResets)
+	if err != nil {
+		log.Printf("Error counting password resets for IP: %v", err)
+		sendJSONError(w, "Failed to process request. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if ipResets >= passwordResetIPMax {
+		sendRetryAfter(w, ipFailureWindow, "Too many password reset requests from this address. Please try again later.")
+		return
+	}
+
+	response := map[string]string{
+		"message": "If an account exists for that email, a password reset link has been sent.",
+	}
+
+	var userID int
+	var email string
+	var recentlySent bool
+	err = h.DB.QueryRow(
+		`SELECT id, email, EXISTS (
+			SELECT 1 FROM password_resets pr
+			WHERE pr.user_id = users.id AND pr.created_at > CURRENT_TIMESTAMP - $2 * INTERVAL '1 second'
+		 )
+		 FROM users WHERE LOWER(email) = LOWER($1) AND is_active = true`,
+		strings.TrimSpace(req.Email), int(passwordResetCooldown.Seconds()),
+	).Scan(&userID, &email, &recentlySent)
+
+	// A link sent moments ago stays valid; answering as usual keeps the
+	// cooldown from revealing that the account exists.
+	if err == sql.ErrNoRows || (err == nil && recentlySent) {
+		sendJSONResponse(w, response, http.StatusOK)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching user for password reset: %v", err)
+		sendJSONError(w, "Failed to process request. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		sendJSONError(w, "Failed to process request. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting password reset transaction: %v", err)
+		sendJSONError(w, "Failed to process request. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Only the most recently requested link stays valid.
+	_, err = tx.Exec(
+		"UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL",
+		userID,
+	)
+	if err != nil {
+		log.Printf("Error invalidating previous password resets: %v", err)
+		sendJSONError(w, "Failed to process request. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO password_resets (user_id, token_hash, expires_at, ip_address)
+		 VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second', $4)`,
+		userID, middleware.HashToken(token), int(passwordResetTTL.Seconds()), clientIP(r),
+	)
+	if err != nil {
+		log.Printf("Error creating password reset: %v", err)
+		sendJSONError(w, "Failed to process request. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing password reset: %v", err)
+		sendJSONError(w, "Failed to process request. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	// Deliver asynchronously so response time does not reveal whether the
+	// account exists.
+	msg := passwordResetMessage(email, token)
+	go func() {
+		if err := h.Notifier.Send(msg); err != nil {
+			log.Printf("Error sending password reset notification: %v", err)
+		}
+	}()
+
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+func passwordResetMessage(email, token string) notify.Message {
+	resetURL := os.Getenv("PASSWORD_RESET_URL")
+	if resetURL == "" {
+		resetURL = defaultResetURL
+	}
+
+	link := resetURL + "?token=" + url.QueryEscape(token)
+	return notify.Message{
+		To:      email,
+		Subject: "Reset your Khidmat password",
+		Body: fmt.Sprintf(
+			"A password reset was requested for your Khidmat account.\n\n"+
+				"Use the link below within %d minutes to choose a new password:\n%s\n\n"+
+				"If you did not request this, you can ignore this message.",
+			int(passwordResetTTL.Minutes()), link,
+		),
+	}
+}
+
+// ResetPassword redeems a reset token and sets a new password. All existing
+// sessions for the account are revoked.
+func (h *Handlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resetID, userID int
+	var used, expired bool
+	err := h.DB.QueryRow(
+		"SELECT id, user_id, used_at IS NOT NULL, expires_at < CURRENT_TIMESTAMP FROM password_resets WHERE token_hash = $1",
+		middleware.HashToken(req.Token),
+	).Scan(&resetID, &userID, &used, &expired)
+
+	if err == sql.ErrNoRows || (err == nil && (used || expired)) {
+		sendJSONError(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching password reset: %v", err)
+		sendJSONError(w, "Failed to reset password. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.setPassword(userID, req.NewPassword, resetID); err != nil {
+		if err == errResetAlreadyUsed {
+			sendJSONError(w, "Invalid or expired reset token", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error resetting password: %v", err)
+		sendJSONError(w, "Failed to reset password. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]string{"message": "Password has been reset. Please log in."}, http.StatusOK)
+}
+
+var errResetAlreadyUsed = errors.New("password reset already used")
+
+// setPassword stores a new password hash and revokes every session of the
+// user. When resetID is non-zero the reset token is consumed in the same
+// transaction.
+func (h *Handlers) setPassword(userID int, password string, resetID int) error {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if resetID != 0 {
+		result, err := tx.Exec(
+			"UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE id = $1 AND used_at IS NULL",
+			resetID,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return errResetAlreadyUsed
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE users SET password_hash = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+		string(passwordHash), userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := h.revokeAllSessions(tx, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
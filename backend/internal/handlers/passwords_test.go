@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestForgotPasswordLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		ipResets int
+		expect   func(sqlmock.Sqlmock)
+		wantCode int
+	}{
+		{
+			name:     "address over its limit",
+			ipResets: passwordResetIPMax,
+			wantCode: http.StatusTooManyRequests,
+		},
+		{
+			name:     "link sent within the cooldown",
+			ipResets: 1,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("FROM users WHERE LOWER\\(email\\)").
+					WithArgs("aisha@example.org", int(passwordResetCooldown.Seconds())).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "email", "exists"}).AddRow(3, "aisha@example.org", true))
+			},
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("FROM password_resets").WithArgs(sqlmock.AnyArg(), int(ipFailureWindow.Seconds())).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.ipResets))
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/auth/password/forgot", strings.NewReader(`{"email": "aisha@example.org"}`))
+			w := httptest.NewRecorder()
+			(&Handlers{DB: db}).ForgotPassword(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
+)
+
+// maxAdvanceMonths is how far beyond the current month a payment can be
+// allocated.
+const maxAdvanceMonths = 24
+
+// paymentAllocationsColumn selects the allocations of the payment aliased p
+// as a JSON array, oldest month first.
+const paymentAllocationsColumn = `COALESCE((SELECT json_agg(json_build_object('period', TO_CHAR(a.period, 'YYYY-MM'), 'amount', a.amount) ORDER BY a.period)
+		FROM payment_allocations a WHERE a.payment_id = p.id), '[]')`
+
+// allocationRequest is one month a payment is sent to cover.
+type allocationRequest struct {
+	Period string  `json:"period"`
+	Amount float64 `json:"amount"`
+}
+
+// decodeAllocations unpacks a paymentAllocationsColumn value.
+func decodeAllocations(raw []byte, allocations *[]models.PaymentAllocation) error {
+	return json.Unmarshal(raw, allocations)
+}
+
+// validateAllocations checks the allocations requested for a payment of
+// amount, recording problems under field. The months must be distinct, no
+// later than maxAdvanceMonths ahead, and their amounts must add up to the
+// payment's.
+func validateAllocations(field string, requested []allocationRequest, amount float64, problems fieldErrors) []models.PaymentAllocation {
+	latest := firstOfMonth().AddDate(0, maxAdvanceMonths, 0)
+	allocations := make([]models.PaymentAllocation, 0, len(requested))
+	seen := make(map[string]bool, len(requested))
+	var total float64
+	for i, a := range requested {
+		prefix := fmt.Sprintf("%s[%d].", field, i)
+		period, err := time.Parse("2006-01", a.Period)
+		switch {
+		case err != nil:
+			problems[prefix+"period"] = "period must be in YYYY-MM format"
+		case period.After(latest):
+			problems[prefix+"period"] = fmt.Sprintf("Payments can be allocated at most %d months ahead", maxAdvanceMonths)
+		case seen[a.Period]:
+			problems[prefix+"period"] = "Each month can appear only once"
+		}
+		seen[a.Period] = true
+		if problem := paymentAmountProblem(a.Amount); problem != "" {
+			problems[prefix+"amount"] = problem
+		}
+		total += a.Amount
+		allocations = append(allocations, models.PaymentAllocation{Period: a.Period, Amount: a.Amount})
+	}
+
+	if len(requested) > 0 && roundAmount(total) != roundAmount(amount) {
+		problems[field] = "Allocations must add up to the amount paid"
+	}
+
+	sort.Slice(allocations, func(i, j int) bool { return allocations[i].Period < allocations[j].Period })
+	return allocations
+}
+
+// lockPaymentMembers locks the members payments are being allocated for, in
+// id order, so concurrent payments allocate one after another. NO KEY UPDATE
+// does not conflict with the key share lock inserting a payment takes.
+func lockPaymentMembers(tx *sql.Tx, memberIDs []int) error {
+	_, err := tx.Exec(
+		"SELECT id FROM members WHERE id = ANY($1) ORDER BY id FOR NO KEY UPDATE",
+		pq.Array(memberIDs),
+	)
+	return err
+}
+
+// allocatePayment records which months paymentID covers: allocations when
+// given, as checked by validateAllocations, otherwise autoAllocate's. The
+// member must be locked with lockPaymentMembers.
+func allocatePayment(tx *sql.Tx, paymentID, memberID int, amount float64, creditPeriod string, allocations []models.PaymentAllocation) ([]models.PaymentAllocation, error) {
+	if len(allocations) == 0 {
+		var err error
+		allocations, err = autoAllocate(tx, memberID, amount, creditPeriod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range allocations {
+		_, err := tx.Exec(
+			"INSERT INTO payment_allocations (payment_id, period, amount) VALUES ($1, $2::date, $3)",
+			paymentID, a.Period+"-01", a.Amount,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return allocations, nil
+}
+
+// autoAllocate spreads amount over the member's months, oldest first: first
+// the arrears since they joined, then this month, then up to
+// maxAdvanceMonths in advance, each up to what is still due for it.
+// Anything left over is credited to creditPeriod, the month of the payment.
+func autoAllocate(tx *sql.Tx, memberID int, amount float64, creditPeriod string) ([]models.PaymentAllocation, error) {
+	rows, err := tx.Query(`
+		SELECT TO_CHAR(month, 'YYYY-MM'),
+			member_expected_amount(m.id, month::date)
+			- COALESCE((SELECT SUM(a.amount) FROM payment_allocations a
+				INNER JOIN payments p ON p.id = a.payment_id
+				WHERE p.member_id = m.id AND a.period = month::date AND `+paymentCounts+`), 0)
+		FROM members m,
+			generate_series(date_trunc('month', m.created_at), date_trunc('month', CURRENT_DATE) + $2 * INTERVAL '1 month', INTERVAL '1 month') AS month
+		WHERE m.id = $1
+		ORDER BY month
+	`, memberID, maxAdvanceMonths)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var allocations []models.PaymentAllocation
+	remaining := roundAmount(amount)
+	for remaining > 0 && rows.Next() {
+		var a models.PaymentAllocation
+		var due float64
+		if err := rows.Scan(&a.Period, &due); err != nil {
+			return nil, err
+		}
+		if due <= 0 {
+			continue
+		}
+		a.Amount = roundAmount(math.Min(due, remaining))
+		remaining = roundAmount(remaining - a.Amount)
+		allocations = append(allocations, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return creditRemainder(allocations, remaining, creditPeriod), nil
+}
+
+// creditRemainder adds what is left of a payment after its dues are covered
+// to creditPeriod, keeping allocations in month order.
+func creditRemainder(allocations []models.PaymentAllocation, remaining float64, creditPeriod string) []models.PaymentAllocation {
+	if remaining <= 0 {
+		return allocations
+	}
+	for i := range allocations {
+		if allocations[i].Period == creditPeriod {
+			allocations[i].Amount = roundAmount(allocations[i].Amount + remaining)
+			return allocations
+		}
+	}
+	allocations = append(allocations, models.PaymentAllocation{Period: creditPeriod, Amount: remaining})
+	sort.Slice(allocations, func(i, j int) bool { return allocations[i].Period < allocations[j].Period })
+	return allocations
+}
+
+// UpdatePaymentAllocations replaces the months a payment covers with
+// allocations, or with an automatic allocation when none are given, e.g.
+// to count a late payment towards the month it was meant for.
+func (h *Handlers) UpdatePaymentAllocations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	paymentID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Allocations []allocationRequest `json:"allocations"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting payment allocation: %v", err)
+		sendJSONError(w, "Failed to allocate payment. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var payment models.Payment
+	err = tx.QueryRow(
+		`SELECT id, member_id, amount, COALESCE(cheque_status, ''), payment_date FROM payments
+		 WHERE id = $1 AND ($2 = 0 OR admin_id = $2)
+		 FOR UPDATE`,
+		paymentID, scopeAdminID(r),
+	).Scan(&payment.ID, &payment.MemberID, &payment.Amount, &payment.ChequeStatus, &payment.PaymentDate)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching payment for allocation: %v", err)
+		sendJSONError(w, "Failed to allocate payment. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if payment.ChequeStatus == chequeBounced {
+		sendJSONError(w, "A bounced cheque does not count towards any month", http.StatusConflict)
+		return
+	}
+
+	problems := fieldErrors{}
+	allocations := validateAllocations("allocations", req.Allocations, payment.Amount, problems)
+	if len(problems) > 0 {
+		sendFieldErrors(w, problems)
+		return
+	}
+
+	err = lockPaymentMembers(tx, []int{payment.MemberID})
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM payment_allocations WHERE payment_id = $1", paymentID)
+	}
+	if err == nil {
+		payment.Allocations, err = allocatePayment(tx, paymentID, payment.MemberID, payment.Amount, payment.PaymentDate.Format("2006-01"), allocations)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error allocating payment: %v", err)
+		sendJSONError(w, "Failed to allocate payment. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"id":          paymentID,
+		"allocations": payment.Allocations,
+	}, http.StatusOK)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/khidmat/backend/internal/models"
+)
+
+// alloc is an allocation of amount to period.
+func alloc(period string, amount float64) models.PaymentAllocation {
+	return models.PaymentAllocation{Period: period, Amount: amount}
+}
+
+func TestValidateAllocations(t *testing.T) {
+	latest := firstOfMonth().AddDate(0, maxAdvanceMonths, 0).Format("2006-01")
+	tooLate := firstOfMonth().AddDate(0, maxAdvanceMonths+1, 0).Format("2006-01")
+
+	tests := []struct {
+		name      string
+		requested []allocationRequest
+		amount    float64
+		want      []models.PaymentAllocation
+		problems  []string
+	}{
+		{
+			name:   "none requested",
+			amount: 500,
+			want:   []models.PaymentAllocation{},
+		},
+		{
+			name:      "sorted by month",
+			requested: []allocationRequest{{"2024-03", 200}, {"2024-01", 100.5}, {"2024-02", 199.5}},
+			amount:    500,
+			want:      []models.PaymentAllocation{alloc("2024-01", 100.5), alloc("2024-02", 199.5), alloc("2024-03", 200)},
+		},
+		{
+			name:      "furthest month ahead",
+			requested: []allocationRequest{{latest, 200}},
+			amount:    200,
+			want:      []models.PaymentAllocation{alloc(latest, 200)},
+		},
+		{
+			name:      "too far ahead",
+			requested: []allocationRequest{{tooLate, 200}},
+			amount:    200,
+			problems:  []string{"allocations[0].period"},
+		},
+		{
+			name:      "bad period",
+			requested: []allocationRequest{{"2024-13", 200}},
+			amount:    200,
+			problems:  []string{"allocations[0].period"},
+		},
+		{
+			name:      "month repeated",
+			requested: []allocationRequest{{"2024-01", 100}, {"2024-01", 100}},
+			amount:    200,
+			problems:  []string{"allocations[1].period"},
+		},
+		{
+			name:      "bad amount",
+			requested: []allocationRequest{{"2024-01", 0}, {"2024-02", 100.005}},
+			amount:    100.005,
+			problems:  []string{"allocations[0].amount", "allocations[1].amount"},
+		},
+		{
+			name:      "total short of the amount",
+			requested: []allocationRequest{{"2024-01", 100}, {"2024-02", 50}},
+			amount:    200,
+			problems:  []string{"allocations"},
+		},
+		{
+			name:      "total rounds to the amount",
+			requested: []allocationRequest{{"2024-01", 0.1}, {"2024-02", 0.2}},
+			amount:    0.3,
+			want:      []models.PaymentAllocation{alloc("2024-01", 0.1), alloc("2024-02", 0.2)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := fieldErrors{}
+			got := validateAllocations("allocations", tt.requested, tt.amount, problems)
+
+			if len(problems) != len(tt.problems) {
+				t.Fatalf("problems = %v, want %v", problems, tt.problems)
+			}
+			for _, field := range tt.problems {
+				if _, ok := problems[field]; !ok {
+					t.Errorf("problems = %v, want one for %s", problems, field)
+				}
+			}
+			if tt.problems == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allocations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreditRemainder(t *testing.T) {
+	tests := []struct {
+		name        string
+		allocations []models.PaymentAllocation
+		remaining   float64
+		want        []models.PaymentAllocation
+	}{
+		{
+			name:        "nothing left",
+			allocations: []models.PaymentAllocation{alloc("2024-01", 200)},
+			want:        []models.PaymentAllocation{alloc("2024-01", 200)},
+		},
+		{
+			name:      "nothing due",
+			remaining: 150,
+			want:      []models.PaymentAllocation{alloc("2024-03", 150)},
+		},
+		{
+			name:        "added to the payment month",
+			allocations: []models.PaymentAllocation{alloc("2024-02", 200), alloc("2024-03", 200), alloc("2024-04", 200)},
+			remaining:   50.1,
+			want:        []models.PaymentAllocation{alloc("2024-02", 200), alloc("2024-03", 250.1), alloc("2024-04", 200)},
+		},
+		{
+			name:        "payment month inserted in order",
+			allocations: []models.PaymentAllocation{alloc("2024-01", 200), alloc("2024-05", 200)},
+			remaining:   25,
+			want:        []models.PaymentAllocation{alloc("2024-01", 200), alloc("2024-03", 25), alloc("2024-05", 200)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := creditRemainder(tt.allocations, tt.remaining, "2024-03")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("creditRemainder = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Payment modes.
+const (
+	paymentModeCash         = "cash"
+	paymentModeUPI          = "upi"
+	paymentModeBankTransfer = "bank_transfer"
+	paymentModeCheque       = "cheque"
+)
+
+var paymentModes = []string{paymentModeCash, paymentModeUPI, paymentModeBankTransfer, paymentModeCheque}
+
+// Cheque states. A cheque payment starts out received.
+const (
+	chequeReceived  = "received"
+	chequeDeposited = "deposited"
+	chequeCleared   = "cleared"
+	chequeBounced   = "bounced"
+)
+
+// chequeTransitions lists the states a cheque can move to from each state.
+// Cleared and bounced cheques are final; a bounced cheque paid again is a
+// new payment.
+var chequeTransitions = map[string][]string{
+	chequeReceived:  {chequeDeposited},
+	chequeDeposited: {chequeCleared, chequeBounced},
+}
+
+const maxReferenceLength = 100
+
+// paymentCounts holds for payments, aliased p, that count towards a
+// member's dues: all but bounced cheques, whose allocations stop counting
+// so the months they covered are due again.
+const paymentCounts = "p.cheque_status IS DISTINCT FROM 'bounced'"
+
+// paymentReceived holds for payments, aliased p, whose money has arrived:
+// everything but cheques that have not cleared.
+const paymentReceived = "(p.payment_mode <> 'cheque' OR p.cheque_status = 'cleared')"
+
+// paymentModeColumns selects the mode, reference and cheque state of the
+// payment aliased p.
+const paymentModeColumns = "p.payment_mode, COALESCE(p.reference_no, ''), COALESCE(p.cheque_status, '')"
+
+var errReferenceTaken = errors.New("reference number already recorded")
+
+// paymentMethod is how a payment arrived.
+type paymentMethod struct {
+	mode      string
+	reference string
+}
+
+// validatePaymentMode checks a payment's mode (default cash) and reference
+// number, recording problems, and returns both normalized. Every mode but
+// cash needs a reference, which checkReference makes sure is new.
+func validatePaymentMode(mode, reference string, problems fieldErrors) paymentMethod {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		mode = paymentModeCash
+	}
+	reference = strings.ToUpper(strings.TrimSpace(reference))
+
+	switch {
+	case !containsString(paymentModes, mode):
+		problems["payment_mode"] = "payment_mode must be one of " + strings.Join(paymentModes, ", ")
+	case reference == "" && mode != paymentModeCash:
+		problems["reference_no"] = "A reference number is required for " + strings.ReplaceAll(mode, "_", " ") + " payments"
+	case len(reference) > maxReferenceLength:
+		problems["reference_no"] = "Reference number is too long"
+	}
+	return paymentMethod{mode: mode, reference: reference}
+}
+
+// checkReference reports errReferenceTaken when the method's reference has
+// already been recorded for another payment made the same way, other than a
+// bounced cheque. The unique index on payments.reference_no settles races
+// between two such checks.
+func checkReference(q queryer, method paymentMethod) error {
+	if method.reference == "" {
+		return nil
+	}
+	rows, err := q.Query(
+		"SELECT 1 FROM payments WHERE payment_mode = $1 AND reference_no = $2 AND cheque_status IS DISTINCT FROM 'bounced' LIMIT 1",
+		method.mode, method.reference,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return errReferenceTaken
+	}
+	return rows.Err()
+}
+
+// isReferenceConflict reports whether err is a violation of the unique
+// reference number index.
+func isReferenceConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "idx_payments_reference_no"
+}
+
+// UpdateChequeStatus moves a cheque payment, and every other payment of its
+// split, to status. A bounced cheque stops counting towards the months it
+// was allocated to, reopening the member's dues for them.
+func (h *Handlers) UpdateChequeStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	paymentID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !containsString([]string{chequeDeposited, chequeCleared, chequeBounced}, req.Status) {
+		sendJSONError(w, "status must be deposited, cleared or bounced", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting cheque status update: %v", err)
+		sendJSONError(w, "Failed to update cheque. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// The first payment of a split is locked for the whole split.
+	var mode, status string
+	var groupID int
+	err = tx.QueryRow(
+		`SELECT g.payment_mode, COALESCE(g.cheque_status, ''), g.id
+		 FROM payments p
+		 INNER JOIN payments g ON g.id = COALESCE(p.split_group_id, p.id)
+		 WHERE p.id = $1 AND ($2 = 0 OR p.admin_id = $2)
+		 FOR UPDATE OF g`,
+		paymentID, scopeAdminID(r),
+	).Scan(&mode, &status, &groupID)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching cheque payment: %v", err)
+		sendJSONError(w, "Failed to update cheque. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if mode != paymentModeCheque {
+		sendJSONError(w, "Payment was not made by cheque", http.StatusConflict)
+		return
+	}
+
+	if !containsString(chequeTransitions[status], req.Status) {
+		sendJSONError(w, "A "+status+" cheque cannot be marked "+req.Status, http.StatusConflict)
+		return
+	}
+
+	_, err = tx.Exec(
+		`UPDATE payments SET cheque_status = $2, cheque_status_at = CURRENT_TIMESTAMP
+		 WHERE id = $1 OR split_group_id = $1`,
+		groupID, req.Status,
+	)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error updating cheque status: %v", err)
+		sendJSONError(w, "Failed to update cheque. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"id":             paymentID,
+		"receipt_number": receiptNumber(groupID),
+		"cheque_status":  req.Status,
+	}, http.StatusOK)
+}
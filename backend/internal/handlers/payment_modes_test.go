@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidatePaymentMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		reference string
+		want      paymentMethod
+		problem   string
+	}{
+		{"cash by default", "", "", paymentMethod{mode: paymentModeCash}, ""},
+		{"normalized", " UPI ", " txn-42 ", paymentMethod{mode: paymentModeUPI, reference: "TXN-42"}, ""},
+		{"unknown mode", "card", "", paymentMethod{mode: "card"}, "payment_mode"},
+		{"cheque without number", "cheque", "", paymentMethod{mode: paymentModeCheque}, "reference_no"},
+		{"reference too long", "bank_transfer", strings.Repeat("1", maxReferenceLength+1), paymentMethod{}, "reference_no"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := fieldErrors{}
+			got := validatePaymentMode(tt.mode, tt.reference, problems)
+			if tt.problem == "" {
+				if len(problems) > 0 {
+					t.Fatalf("problems = %v, want none", problems)
+				}
+				if got != tt.want {
+					t.Errorf("validatePaymentMode = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			if _, ok := problems[tt.problem]; !ok || len(problems) != 1 {
+				t.Errorf("problems = %v, want one for %s", problems, tt.problem)
+			}
+		})
+	}
+}
+
+func TestCheckReference(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := checkReference(db, paymentMethod{mode: paymentModeCash}); err != nil {
+		t.Errorf("checkReference without a reference: %v", err)
+	}
+
+	query := regexp.QuoteMeta("cheque_status IS DISTINCT FROM 'bounced'")
+	mock.ExpectQuery(query).WithArgs(paymentModeCheque, "000123").
+		WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+	mock.ExpectQuery(query).WithArgs(paymentModeCheque, "000124").
+		WillReturnRows(sqlmock.NewRows([]string{"?column?"}))
+
+	if err := checkReference(db, paymentMethod{mode: paymentModeCheque, reference: "000123"}); err != errReferenceTaken {
+		t.Errorf("checkReference of a recorded cheque = %v, want errReferenceTaken", err)
+	}
+	if err := checkReference(db, paymentMethod{mode: paymentModeCheque, reference: "000124"}); err != nil {
+		t.Errorf("checkReference of a new or bounced cheque = %v, want nil", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
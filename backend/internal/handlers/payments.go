@@ -1,17 +1,51 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
-	"time"
+	"strconv"
 
+	"github.com/gorilla/mux"
 	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
 )
 
+// GetPaymentReceipt returns the receipt for a payment the caller can see.
+func (h *Handlers) GetPaymentReceipt(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	paymentID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	h.sendReceipt(w, paymentID, "($2 = 0 OR p.admin_id = $2)", scopeAdminID(r))
+}
+
+// paymentSplit is one covered member's share of a payment.
+type paymentSplit struct {
+	MemberID    int                 `json:"member_id"`
+	Amount      float64             `json:"amount"`
+	Allocations []allocationRequest `json:"allocations"`
+}
+
+// CreatePayment records a payment for member_id or, with splits, one payment
+// shared across several active members. A payer_member_id paying for someone
+// else must be in the same household. Invalid fields are reported together.
 func (h *Handlers) CreatePayment(w http.ResponseWriter, r *http.Request) {
-	var payment models.Payment
-	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
+	var req struct {
+		MemberID      int                 `json:"member_id"`
+		Amount        float64             `json:"amount"`
+		PayerMemberID int                 `json:"payer_member_id"`
+		PaymentMode   string              `json:"payment_mode"`
+		ReferenceNo   string              `json:"reference_no"`
+		Allocations   []allocationRequest `json:"allocations"`
+		Splits        []paymentSplit      `json:"splits"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		sendJSONError(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
@@ -22,52 +56,425 @@ func (h *Handlers) CreatePayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var paymentID int
-	err := h.DB.QueryRow(
-		"INSERT INTO payments (member_id, member_name, contact_no, amount, admin_id) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		payment.MemberID, payment.MemberName, payment.ContactNo, payment.Amount, adminID,
-	).Scan(&paymentID)
+	problems := fieldErrors{}
+	method := validatePaymentMode(req.PaymentMode, req.ReferenceNo, problems)
 
+	if len(req.Splits) > 0 {
+		h.createSplitPayment(w, r, req.PayerMemberID, req.Splits, method, problems)
+		return
+	}
+
+	if req.MemberID <= 0 {
+		problems["member_id"] = "member_id is required"
+	}
+	if problem := paymentAmountProblem(req.Amount); problem != "" {
+		problems["amount"] = problem
+	}
+	allocations := validateAllocations("allocations", req.Allocations, req.Amount, problems)
+	if req.MemberID > 0 {
+		memberProblems, err := h.paymentMemberProblems(r, []int{req.MemberID})
+		if err != nil {
+			log.Printf("Error checking payment member: %v", err)
+			sendJSONError(w, "Failed to create payment. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+		if problem, ok := memberProblems[req.MemberID]; ok {
+			problems["member_id"] = problem
+		}
+	}
+	if len(problems) == 0 && !h.referenceAvailable(w, method, problems) {
+		return
+	}
+	if len(problems) > 0 {
+		sendFieldErrors(w, problems)
+		return
+	}
+
+	payment := models.Payment{
+		MemberID: req.MemberID, Amount: req.Amount, AdminID: adminID, PayerMemberID: req.PayerMemberID,
+		PaymentMode: method.mode, ReferenceNo: method.reference,
+	}
+	if payment.PayerMemberID == 0 {
+		payment.PayerMemberID = payment.MemberID
+	}
+
+	payer, ok := h.householdPayer(w, r, payment.PayerMemberID, []int{payment.MemberID})
+	if !ok {
+		return
+	}
+	payment.PayerName = payer
+
+	tx, err := h.DB.Begin()
 	if err != nil {
-		log.Printf("Error creating payment: %v", err)
+		log.Printf("Error starting payment: %v", err)
 		sendJSONError(w, "Failed to create payment. Please try again later.", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
+
+	err = lockPaymentMembers(tx, []int{payment.MemberID})
+	if err == nil {
+		err = tx.QueryRow(
+			`INSERT INTO payments (member_id, member_name, contact_no, amount, admin_id, payer_member_id, payer_name,
+				payment_mode, reference_no, cheque_status)
+			 SELECT m.id, m.name, m.mobile_no, $2, $3, $4, $5, $6, NULLIF($7, ''), CASE WHEN $6::text = 'cheque' THEN 'received' END
+			 FROM members m
+			 WHERE m.id = $1 AND m.deleted_at IS NULL AND m.status = 'active'
+			 RETURNING id, member_name, contact_no, COALESCE(cheque_status, ''), payment_date, created_at`,
+			payment.MemberID, payment.Amount, adminID, payment.PayerMemberID, payment.PayerName, method.mode, method.reference,
+		).Scan(&payment.ID, &payment.MemberName, &payment.ContactNo, &payment.ChequeStatus, &payment.PaymentDate, &payment.CreatedAt)
+	}
+	if err == nil {
+		payment.Allocations, err = allocatePayment(tx, payment.ID, payment.MemberID, payment.Amount, payment.PaymentDate.Format("2006-01"), allocations)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	// The member was deactivated or deleted since it was checked.
+	if err == sql.ErrNoRows {
+		sendFieldErrors(w, fieldErrors{"member_id": "Member is not active"})
+		return
+	}
+
+	if isReferenceConflict(err) {
+		sendFieldErrors(w, fieldErrors{"reference_no": "This reference number has already been recorded"})
+		return
+	}
 
-	payment.ID = paymentID
-	payment.AdminID = adminID
-	payment.PaymentDate = time.Now()
+	if err != nil {
+		log.Printf("Error creating payment: %v", err)
+		sendJSONError(w, "Failed to create payment. Please try again later.", http.StatusInternalServerError)
+		return
+	}
 
 	sendJSONResponse(w, payment, http.StatusCreated)
 }
 
-func (h *Handlers) GetPayments(w http.ResponseWriter, r *http.Request) {
-	query := `
-		SELECT p.id, p.member_id, p.member_name, p.contact_no, p.amount, p.admin_id, u.username, p.payment_date, p.created_at
-		FROM payments p
-		LEFT JOIN users u ON p.admin_id = u.id
-		ORDER BY p.created_at DESC
-	`
-
-	rows, err := h.DB.Query(query)
+// createSplitPayment records one payment by payerID covering several
+// members, as a payments row per member sharing a split_group_id and the
+// payment method. problems holds what is already known to be invalid.
+func (h *Handlers) createSplitPayment(w http.ResponseWriter, r *http.Request, payerID int, splits []paymentSplit, method paymentMethod, problems fieldErrors) {
+	if payerID <= 0 {
+		problems["payer_member_id"] = "payer_member_id is required when splitting a payment"
+	}
+
+	seen := make(map[int]bool, len(splits))
+	memberIDs := make([]int, 0, len(splits))
+	allocations := make([][]models.PaymentAllocation, len(splits))
+	var total float64
+	for i, split := range splits {
+		field := fmt.Sprintf("splits[%d].", i)
+		switch {
+		case split.MemberID <= 0:
+			problems[field+"member_id"] = "member_id is required"
+		case seen[split.MemberID]:
+			problems[field+"member_id"] = "Each member can appear only once in splits"
+		default:
+			seen[split.MemberID] = true
+			memberIDs = append(memberIDs, split.MemberID)
+		}
+		if problem := paymentAmountProblem(split.Amount); problem != "" {
+			problems[field+"amount"] = problem
+		}
+		allocations[i] = validateAllocations(field+"allocations", split.Allocations, split.Amount, problems)
+		total += split.Amount
+	}
+
+	memberProblems, err := h.paymentMemberProblems(r, memberIDs)
 	if err != nil {
-		log.Printf("Error fetching payments: %v", err)
-		sendJSONError(w, "Failed to fetch payments. Please try again later.", http.StatusInternalServerError)
+		log.Printf("Error checking payment members: %v", err)
+		sendJSONError(w, "Failed to create payment. Please try again later.", http.StatusInternalServerError)
 		return
 	}
+	for i, split := range splits {
+		field := fmt.Sprintf("splits[%d].member_id", i)
+		if problem, ok := memberProblems[split.MemberID]; ok && problems[field] == "" {
+			problems[field] = problem
+		}
+	}
+	if len(problems) == 0 && !h.referenceAvailable(w, method, problems) {
+		return
+	}
+	if len(problems) > 0 {
+		sendFieldErrors(w, problems)
+		return
+	}
+
+	payer, ok := h.householdPayer(w, r, payerID, memberIDs)
+	if !ok {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting split payment: %v", err)
+		sendJSONError(w, "Failed to create payment. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	adminID := getUserIDFromRequest(r)
+	payments := make([]models.Payment, 0, len(splits))
+	var groupID *int
+	failed := 0
+	err = lockPaymentMembers(tx, memberIDs)
+	for i, split := range splits {
+		if err != nil {
+			break
+		}
+		p := models.Payment{
+			MemberID: split.MemberID, Amount: split.Amount, AdminID: adminID,
+			PayerMemberID: payerID, PayerName: payer, SplitGroupID: groupID,
+			PaymentMode: method.mode, ReferenceNo: method.reference,
+		}
+		err = tx.QueryRow(
+			`INSERT INTO payments (member_id, member_name, contact_no, amount, admin_id, payer_member_id, payer_name, split_group_id,
+				payment_mode, reference_no, cheque_status)
+			 SELECT m.id, m.name, m.mobile_no, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), CASE WHEN $7::text = 'cheque' THEN 'received' END
+			 FROM members m
+			 WHERE m.id = $1 AND m.deleted_at IS NULL AND m.status = 'active'
+			 RETURNING id, member_name, contact_no, COALESCE(cheque_status, ''), payment_date, created_at`,
+			split.MemberID, split.Amount, adminID, payerID, payer, groupID, method.mode, method.reference,
+		).Scan(&p.ID, &p.MemberName, &p.ContactNo, &p.ChequeStatus, &p.PaymentDate, &p.CreatedAt)
+		if err != nil {
+			failed = i
+			break
+		}
+		if groupID == nil {
+			groupID = &p.ID
+			p.SplitGroupID = groupID
+			_, err = tx.Exec("UPDATE payments SET split_group_id = id WHERE id = $1", p.ID)
+			if err != nil {
+				break
+			}
+		}
+		p.Allocations, err = allocatePayment(tx, p.ID, p.MemberID, p.Amount, p.PaymentDate.Format("2006-01"), allocations[i])
+		if err != nil {
+			break
+		}
+		payments = append(payments, p)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	// A member was deactivated or deleted since it was checked.
+	if err == sql.ErrNoRows {
+		sendFieldErrors(w, fieldErrors{fmt.Sprintf("splits[%d].member_id", failed): "Member is not active"})
+		return
+	}
+
+	if isReferenceConflict(err) {
+		sendFieldErrors(w, fieldErrors{"reference_no": "This reference number has already been recorded"})
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error creating split payment: %v", err)
+		sendJSONError(w, "Failed to create payment. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"split_group_id":  *groupID,
+		"receipt_number":  receiptNumber(*groupID),
+		"payer_member_id": payerID,
+		"payer_name":      payer,
+		"payment_mode":    method.mode,
+		"reference_no":    method.reference,
+		"amount":          roundAmount(total),
+		"payments":        payments,
+	}, http.StatusCreated)
+}
+
+// paymentAmountProblem describes what is wrong with a payment amount, or
+// returns "" when it is valid.
+func paymentAmountProblem(amount float64) string {
+	switch {
+	case amount <= 0:
+		return "Amount must be greater than zero"
+	case amount >= maxPaymentAmount:
+		return "Amount is too large"
+	case roundAmount(amount) != amount:
+		return "Amount can have at most two decimal places"
+	}
+	return ""
+}
+
+// maxPaymentAmount is the first amount payments.amount, a DECIMAL(10, 2),
+// cannot hold.
+const maxPaymentAmount = 1e8
+
+// paymentMemberProblems checks that payments can be recorded for memberIDs:
+// each must exist, be visible to the caller and be active. It maps each
+// member that fails to what is wrong with it.
+func (h *Handlers) paymentMemberProblems(r *http.Request, memberIDs []int) (map[int]string, error) {
+	problems := map[int]string{}
+	if len(memberIDs) == 0 {
+		return problems, nil
+	}
+
+	rows, err := h.DB.Query(
+		"SELECT id, status FROM members WHERE id = ANY($1) AND deleted_at IS NULL AND ($2 = 0 OR admin_id = $2)",
+		pq.Array(memberIDs), scopeAdminID(r),
+	)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
-	var payments []models.Payment
+	statuses := make(map[int]string, len(memberIDs))
 	for rows.Next() {
+		var id int
+		var status string
+		if err := rows.Scan(&id, &status); err != nil {
+			continue
+		}
+		statuses[id] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Foreign members are reported as missing so their existence is not leaked.
+	for _, id := range memberIDs {
+		status, ok := statuses[id]
+		switch {
+		case !ok:
+			problems[id] = "Member not found"
+		case status != memberStatusActive:
+			problems[id] = "Member is " + status + "; payments can only be recorded for active members"
+		}
+	}
+	return problems, nil
+}
+
+// householdPayer checks that payerID may pay for memberIDs: the payer
+// themselves, or members of the payer's household. It returns the payer's
+// name; on failure it writes the error response and returns false.
+func (h *Handlers) householdPayer(w http.ResponseWriter, r *http.Request, payerID int, memberIDs []int) (string, bool) {
+	var name string
+	var householdID sql.NullInt64
+	err := h.DB.QueryRow(
+		"SELECT name, household_id FROM members WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR admin_id = $2)",
+		payerID, scopeAdminID(r),
+	).Scan(&name, &householdID)
+
+	if err == sql.ErrNoRows {
+		sendFieldErrors(w, fieldErrors{"payer_member_id": "Payer not found"})
+		return "", false
+	}
+
+	if err != nil {
+		log.Printf("Error fetching payer: %v", err)
+		sendJSONError(w, "Failed to create payment. Please try again later.", http.StatusInternalServerError)
+		return "", false
+	}
+
+	var others []int
+	for _, id := range memberIDs {
+		if id != payerID {
+			others = append(others, id)
+		}
+	}
+	if len(others) == 0 {
+		return name, true
+	}
+
+	var inHousehold int
+	if householdID.Valid {
+		err = h.DB.QueryRow(
+			"SELECT COUNT(*) FROM members WHERE id = ANY($1) AND household_id = $2",
+			pq.Array(others), householdID.Int64,
+		).Scan(&inHousehold)
+		if err != nil {
+			log.Printf("Error checking payer household: %v", err)
+			sendJSONError(w, "Failed to create payment. Please try again later.", http.StatusInternalServerError)
+			return "", false
+		}
+	}
+	if inHousehold != len(others) {
+		sendFieldErrors(w, fieldErrors{"payer_member_id": "A member can only pay for members of their own household"})
+		return "", false
+	}
+
+	return name, true
+}
+
+// referenceAvailable records a problem when the method's reference number
+// has been recorded before. On failure to check it writes the error
+// response and returns false.
+func (h *Handlers) referenceAvailable(w http.ResponseWriter, method paymentMethod, problems fieldErrors) bool {
+	err := checkReference(h.DB, method)
+	if err == errReferenceTaken {
+		problems["reference_no"] = "This reference number has already been recorded"
+		return true
+	}
+
+	if err != nil {
+		log.Printf("Error checking payment reference: %v", err)
+		sendJSONError(w, "Failed to create payment. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+var paymentListSpec = listSpec{
+	from:          `payments p LEFT JOIN users u ON p.admin_id = u.id`,
+	columns:       `p.id, p.member_id, p.member_name, p.contact_no, p.amount, p.payer_member_id, p.payer_name, p.split_group_id, ` + paymentModeColumns + `, p.admin_id, u.username, p.payment_date, p.created_at, ` + paymentAllocationsColumn,
+	idColumn:      "p.id",
+	searchColumns: []string{"p.member_name", "p.contact_no", "p.payer_name", "p.reference_no", "u.username"},
+	adminColumn:   "p.admin_id",
+	dateColumn:    "p.payment_date",
+	amountColumn:  "p.amount",
+	sortFields: map[string]listSortField{
+		"member_name":  {"p.member_name", "text"},
+		"amount":       {"p.amount", "numeric"},
+		"payment_date": {"p.payment_date", "timestamp"},
+		"created_at":   {"p.created_at", "timestamp"},
+	},
+	defaultSort: "-created_at",
+}
+
+// GetPayments lists payments a page at a time; parseListQuery documents the
+// parameters.
+func (h *Handlers) GetPayments(w http.ResponseWriter, r *http.Request) {
+	lq, err := parseListQuery(r, &paymentListSpec)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scope := lq.arg(scopeAdminID(r))
+	lq.where("(" + scope + " = 0 OR p.admin_id = " + scope + ")")
+
+	payments := []models.Payment{}
+	total, next, err := lq.fetch(h.DB, func(row rowScanner) (int, error) {
 		var p models.Payment
-		err := rows.Scan(
-			&p.ID, &p.MemberID, &p.MemberName, &p.ContactNo, &p.Amount, &p.AdminID, &p.AdminName, &p.PaymentDate, &p.CreatedAt,
+		var adminName sql.NullString
+		var allocations []byte
+		err := row.Scan(
+			&p.ID, &p.MemberID, &p.MemberName, &p.ContactNo, &p.Amount, &p.PayerMemberID, &p.PayerName, &p.SplitGroupID,
+			&p.PaymentMode, &p.ReferenceNo, &p.ChequeStatus,
+			&p.AdminID, &adminName, &p.PaymentDate, &p.CreatedAt, &allocations,
 		)
+		if err == nil {
+			err = decodeAllocations(allocations, &p.Allocations)
+		}
 		if err != nil {
-			continue
+			return 0, err
 		}
+		p.AdminName = adminName.String
 		payments = append(payments, p)
+		return p.ID, nil
+	})
+
+	if err != nil {
+		log.Printf("Error fetching payments: %v", err)
+		sendJSONError(w, "Failed to fetch payments. Please try again later.", http.StatusInternalServerError)
+		return
 	}
 
-	sendJSONResponse(w, payments, http.StatusOK)
+	sendJSONResponse(w, listPage{Items: payments, Total: total, NextCursor: next}, http.StatusOK)
 }
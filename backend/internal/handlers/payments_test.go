@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/khidmat/backend/internal/middleware"
+)
+
+func TestPaymentMemberProblems(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities string
+		wantScope    int
+	}{
+		{"own members", middleware.CapPaymentsWrite, 2},
+		{"records.all", middleware.CapPaymentsWrite + " " + middleware.CapRecordsAll, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			// Member 5 is deleted, foreign or missing, so the query skips it.
+			mock.ExpectQuery("SELECT id, status FROM members WHERE id = ANY").
+				WithArgs("{3,4,5}", tt.wantScope).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).
+					AddRow(3, memberStatusActive).AddRow(4, memberStatusPaused))
+
+			r := httptest.NewRequest(http.MethodPost, "/api/payments", nil)
+			r.Header.Set("X-User-ID", "2")
+			r.Header.Set("X-Capabilities", tt.capabilities)
+
+			problems, err := (&Handlers{DB: db}).paymentMemberProblems(r, []int{3, 4, 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[int]string{
+				4: "Member is paused; payments can only be recorded for active members",
+				5: "Member not found",
+			}
+			if !reflect.DeepEqual(problems, want) {
+				t.Errorf("paymentMemberProblems = %q, want %q", problems, want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestPaymentMemberProblemsNoMembers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	problems, err := (&Handlers{DB: db}).paymentMemberProblems(httptest.NewRequest(http.MethodPost, "/api/payments", nil), nil)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("paymentMemberProblems(nil) = %v, %v; want no problems", problems, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPaymentAmountProblem(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   string
+	}{
+		{100, ""},
+		{0.01, ""},
+		{99999999.99, ""},
+		{0, "Amount must be greater than zero"},
+		{-5, "Amount must be greater than zero"},
+		{maxPaymentAmount, "Amount is too large"},
+		{10.005, "Amount can have at most two decimal places"},
+	}
+
+	for _, tt := range tests {
+		if got := paymentAmountProblem(tt.amount); got != tt.want {
+			t.Errorf("paymentAmountProblem(%v) = %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
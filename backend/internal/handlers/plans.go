@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
+)
+
+var planFrequencies = map[string]bool{"monthly": true, "quarterly": true, "yearly": true}
+
+const planColumns = `cp.id, cp.name, cp.amount, cp.frequency, cp.start_date, cp.end_date, cp.is_default,
+	(SELECT COUNT(*) FROM member_plans mp INNER JOIN members m ON m.id = mp.member_id
+	 WHERE mp.plan_id = cp.id AND mp.end_date IS NULL AND m.deleted_at IS NULL),
+	cp.created_at, cp.updated_at`
+
+func scanPlan(row interface{ Scan(...interface{}) error }, p *models.ContributionPlan) error {
+	return row.Scan(&p.ID, &p.Name, &p.Amount, &p.Frequency, &p.StartDate, &p.EndDate, &p.IsDefault, &p.MemberCount, &p.CreatedAt, &p.UpdatedAt)
+}
+
+// firstOfMonth is the first day of the current month, the default start of
+// plans and plan assignments.
+func firstOfMonth() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// parseOptionalDate parses a YYYY-MM-DD date, returning fallback for "".
+func parseOptionalDate(raw string, fallback time.Time) (time.Time, bool) {
+	if raw == "" {
+		return fallback, true
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	return t, err == nil
+}
+
+func (h *Handlers) GetPlans(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query("SELECT " + planColumns + " FROM contribution_plans cp ORDER BY cp.is_default DESC, cp.start_date DESC, cp.name")
+	if err != nil {
+		log.Printf("Error fetching contribution plans: %v", err)
+		sendJSONError(w, "Failed to fetch plans. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	plans := []models.ContributionPlan{}
+	for rows.Next() {
+		var p models.ContributionPlan
+		if err := scanPlan(rows, &p); err != nil {
+			continue
+		}
+		plans = append(plans, p)
+	}
+
+	sendJSONResponse(w, plans, http.StatusOK)
+}
+
+// CreatePlan adds a contribution plan. is_default makes it the organisation
+// default for members without a plan of their own, from its start date.
+func (h *Handlers) CreatePlan(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string  `json:"name"`
+		Amount    float64 `json:"amount"`
+		Frequency string  `json:"frequency"`
+		StartDate string  `json:"start_date"`
+		EndDate   string  `json:"end_date"`
+		IsDefault bool    `json:"is_default"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		sendJSONError(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	if problem := paymentAmountProblem(req.Amount); problem != "" {
+		sendJSONError(w, problem, http.StatusBadRequest)
+		return
+	}
+
+	if !planFrequencies[req.Frequency] {
+		sendJSONError(w, "frequency must be monthly, quarterly or yearly", http.StatusBadRequest)
+		return
+	}
+
+	startDate, ok := parseOptionalDate(req.StartDate, firstOfMonth())
+	if !ok {
+		sendJSONError(w, "start_date must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+
+	var endDate *time.Time
+	if req.EndDate != "" {
+		t, ok := parseOptionalDate(req.EndDate, time.Time{})
+		if !ok || t.Before(startDate) {
+			sendJSONError(w, "end_date must be a date (YYYY-MM-DD) on or after start_date", http.StatusBadRequest)
+			return
+		}
+		endDate = &t
+	}
+
+	var plan models.ContributionPlan
+	err := scanPlan(h.DB.QueryRow(
+		`WITH cp AS (
+			INSERT INTO contribution_plans (name, amount, frequency, start_date, end_date, is_default, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING *
+		)
+		SELECT `+planColumns+` FROM cp`,
+		req.Name, req.Amount, req.Frequency, startDate, endDate, req.IsDefault, getUserIDFromRequest(r),
+	), &plan)
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			sendJSONError(w, "A plan with this name already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Error creating contribution plan: %v", err)
+		sendJSONError(w, "Failed to create plan. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, plan, http.StatusCreated)
+}
+
+// UpdatePlan renames a plan, ends it or changes whether it is a default.
+// Past expected amounts never change: amount, frequency and start date are
+// fixed once created, and see planUpdateProblem for ends and defaults.
+func (h *Handlers) UpdatePlan(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	planID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid plan ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Name      *string  `json:"name"`
+		Amount    *float64 `json:"amount"`
+		EndDate   *string  `json:"end_date"`
+		IsDefault *bool    `json:"is_default"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Amount != nil {
+		sendJSONError(w, "A plan's amount cannot be changed; create a new plan instead", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name != nil {
+		*req.Name = strings.TrimSpace(*req.Name)
+		if *req.Name == "" {
+			sendJSONError(w, "Name cannot be empty", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// An empty end_date reopens the plan.
+	var endDate *time.Time
+	if req.EndDate != nil && *req.EndDate != "" {
+		t, ok := parseOptionalDate(*req.EndDate, time.Time{})
+		if !ok {
+			sendJSONError(w, "end_date must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		endDate = &t
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting contribution plan update: %v", err)
+		sendJSONError(w, "Failed to update plan. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var plan models.ContributionPlan
+	err = tx.QueryRow(
+		"SELECT start_date, end_date, is_default FROM contribution_plans WHERE id = $1 FOR UPDATE",
+		planID,
+	).Scan(&plan.StartDate, &plan.EndDate, &plan.IsDefault)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching contribution plan: %v", err)
+		sendJSONError(w, "Failed to update plan. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if problem := planUpdateProblem(plan, req.EndDate != nil, endDate, req.IsDefault, firstOfMonth()); problem != "" {
+		sendJSONError(w, problem, http.StatusConflict)
+		return
+	}
+
+	err = scanPlan(tx.QueryRow(
+		`WITH cp AS (
+			UPDATE contribution_plans SET
+				name = COALESCE($1, name),
+				end_date = CASE WHEN $2 THEN $3::date ELSE end_date END,
+				is_default = COALESCE($4, is_default),
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = $5
+			RETURNING *
+		)
+		SELECT `+planColumns+` FROM cp`,
+		req.Name, req.EndDate != nil, endDate, req.IsDefault, planID,
+	), &plan)
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505":
+				sendJSONError(w, "A plan with this name already exists", http.StatusConflict)
+				return
+			case "23514":
+				sendJSONError(w, "end_date cannot be before the plan's start_date", http.StatusBadRequest)
+				return
+			}
+		}
+		log.Printf("Error updating contribution plan: %v", err)
+		sendJSONError(w, "Failed to update plan. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, plan, http.StatusOK)
+}
+
+// planUpdateProblem explains why an update would change what plan expected
+// before month, the first of the current month, or returns "". A plan can
+// only end from month on, a plan that already ended cannot be reopened or
+// ended again, and only a plan starting from month can change whether it is
+// a default, as defaults are resolved by start date.
+func planUpdateProblem(plan models.ContributionPlan, endDateSet bool, endDate *time.Time, isDefault *bool, month time.Time) string {
+	if endDateSet {
+		if plan.EndDate != nil && plan.EndDate.Before(month) {
+			return "This plan ended before this month and can no longer change; create a new plan instead"
+		}
+		if endDate != nil && endDate.Before(month) {
+			return "end_date cannot be before the current month"
+		}
+	}
+	if isDefault != nil && *isDefault != plan.IsDefault && plan.StartDate.Before(month) {
+		return "Only a plan that has not started yet can change whether it is the default; create a new default plan instead"
+	}
+	return ""
+}
+
+// GetMemberPlans returns the plan a member is on this month and their plan
+// assignments, newest first.
+func (h *Handlers) GetMemberPlans(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	memberID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeMember(w, r, memberID) {
+		return
+	}
+
+	current, err := h.currentMemberPlan(memberID)
+	if err != nil {
+		log.Printf("Error fetching member plan: %v", err)
+		sendJSONError(w, "Failed to fetch member plans. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT mp.id, mp.member_id, mp.plan_id, cp.name, cp.amount, cp.frequency, mp.start_date, mp.end_date,
+			COALESCE(mp.assigned_by, 0), mp.created_at
+		FROM member_plans mp
+		INNER JOIN contribution_plans cp ON cp.id = mp.plan_id
+		WHERE mp.member_id = $1
+		ORDER BY mp.start_date DESC, mp.id DESC
+	`, memberID)
+	if err != nil {
+		log.Printf("Error fetching member plans: %v", err)
+		sendJSONError(w, "Failed to fetch member plans. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	assignments := []models.MemberPlan{}
+	for rows.Next() {
+		var mp models.MemberPlan
+		err := rows.Scan(
+			&mp.ID, &mp.MemberID, &mp.PlanID, &mp.PlanName, &mp.Amount, &mp.Frequency, &mp.StartDate, &mp.EndDate,
+			&mp.AssignedBy, &mp.CreatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		assignments = append(assignments, mp)
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"current":     current,
+		"assignments": assignments,
+	}, http.StatusOK)
+}
+
+// AssignMemberPlan puts a member on a plan from start_date (default: the
+// first of this month), ending their previous assignment the day before.
+func (h *Handlers) AssignMemberPlan(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	memberID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		PlanID    int    `json:"plan_id"`
+		StartDate string `json:"start_date"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	startDate, ok := parseOptionalDate(req.StartDate, firstOfMonth())
+	if !ok {
+		sendJSONError(w, "start_date must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeMember(w, r, memberID) {
+		return
+	}
+
+	var planEnd sql.NullTime
+	err = h.DB.QueryRow("SELECT end_date FROM contribution_plans WHERE id = $1", req.PlanID).Scan(&planEnd)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Plan not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching contribution plan: %v", err)
+		sendJSONError(w, "Failed to assign plan. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	if planEnd.Valid && planEnd.Time.Before(startDate) {
+		sendJSONError(w, "This plan ends before start_date", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting plan assignment: %v", err)
+		sendJSONError(w, "Failed to assign plan. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the member so concurrent assignments are applied one at a time.
+	if _, err := tx.Exec("SELECT id FROM members WHERE id = $1 FOR UPDATE", memberID); err != nil {
+		log.Printf("Error locking member for plan assignment: %v", err)
+		sendJSONError(w, "Failed to assign plan. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	var latestStart sql.NullTime
+	err = tx.QueryRow("SELECT MAX(start_date) FROM member_plans WHERE member_id = $1", memberID).Scan(&latestStart)
+	if err != nil {
+		log.Printf("Error fetching member plans: %v", err)
+		sendJSONError(w, "Failed to assign plan. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	if latestStart.Valid && !startDate.After(latestStart.Time) {
+		sendJSONError(w, "start_date must be after the start of the member's current plan", http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec(
+		"UPDATE member_plans SET end_date = $1::date - 1 WHERE member_id = $2 AND (end_date IS NULL OR end_date >= $1::date)",
+		startDate, memberID,
+	)
+	if err == nil {
+		_, err = tx.Exec(
+			"INSERT INTO member_plans (member_id, plan_id, start_date, assigned_by) VALUES ($1, $2, $3, $4)",
+			memberID, req.PlanID, startDate, getUserIDFromRequest(r),
+		)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error assigning member plan: %v", err)
+		sendJSONError(w, "Failed to assign plan. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	h.GetMemberPlans(w, r)
+}
+
+// currentMemberPlan returns the plan a member is on this month, or nil when
+// neither an assignment nor a default plan is in force.
+func (h *Handlers) currentMemberPlan(memberID int) (*models.ContributionPlan, error) {
+	var plan models.ContributionPlan
+	err := scanPlan(h.DB.QueryRow(
+		"SELECT "+planColumns+" FROM contribution_plans cp WHERE cp.id = member_plan_at($1, $2::date)",
+		memberID, firstOfMonth(),
+	), &plan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
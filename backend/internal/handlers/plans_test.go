@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/khidmat/backend/internal/models"
+)
+
+func TestPlanUpdateProblem(t *testing.T) {
+	month := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	date := func(year int, m time.Month, day int) *time.Time {
+		d := time.Date(year, m, day, 0, 0, 0, 0, time.UTC)
+		return &d
+	}
+	yes, no := true, false
+
+	started := models.ContributionPlan{StartDate: *date(2024, 1, 1), IsDefault: true}
+	startsThisMonth := models.ContributionPlan{StartDate: *date(2024, 6, 15)}
+	ended := models.ContributionPlan{StartDate: *date(2024, 1, 1), EndDate: date(2024, 3, 31)}
+	endsLater := models.ContributionPlan{StartDate: *date(2024, 1, 1), EndDate: date(2024, 9, 30)}
+
+	tests := []struct {
+		name       string
+		plan       models.ContributionPlan
+		endDateSet bool
+		endDate    *time.Time
+		isDefault  *bool
+		wantOK     bool
+	}{
+		{"rename only", started, false, nil, nil, true},
+		{"end this month", started, true, date(2024, 6, 1), nil, true},
+		{"end later", started, true, date(2024, 12, 31), nil, true},
+		{"end last month", started, true, date(2024, 5, 31), nil, false},
+		{"reopen a plan ending later", endsLater, true, nil, nil, true},
+		{"reopen an ended plan", ended, true, nil, nil, false},
+		{"move an ended plan's end", ended, true, date(2024, 7, 31), nil, false},
+		{"ended plan renamed", ended, false, nil, nil, true},
+		{"default unchanged", started, false, nil, &yes, true},
+		{"started plan stops being default", started, false, nil, &no, false},
+		{"plan starting this month becomes default", startsThisMonth, false, nil, &yes, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem := planUpdateProblem(tt.plan, tt.endDateSet, tt.endDate, tt.isDefault, month)
+			if (problem == "") != tt.wantOK {
+				t.Errorf("planUpdateProblem = %q, want ok %v", problem, tt.wantOK)
+			}
+		})
+	}
+}
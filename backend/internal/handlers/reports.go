@@ -4,43 +4,81 @@ import (
 	"database/sql"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
 )
 
+// GetAdminPaymentsReport summarises each account admin's members for the
+// current month, or for ?month=YYYY-MM. Members and their payments count for
+// the admin responsible for them in that month, so transferred members are
+// attributed to their previous admin for earlier months; a transfer counts
+// from the month of its effective date. Likewise only members active in that
+// month count. A member has paid when the payments allocated to the month
+// cover what their contribution plan expected, and is pending when they fall
+// short; total_amount is everything allocated to the month and
+// expected_amount what the plans expected.
 func (h *Handlers) GetAdminPaymentsReport(w http.ResponseWriter, r *http.Request) {
 	now := time.Now()
-	year, month := now.Year(), now.Month()
-	startOfMonth := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
-	endOfMonth := startOfMonth.AddDate(0, 1, 0)
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if raw := r.URL.Query().Get("month"); raw != "" {
+		month, err := time.Parse("2006-01", raw)
+		if err != nil {
+			sendJSONError(w, "month must be in YYYY-MM format", http.StatusBadRequest)
+			return
+		}
+		startOfMonth = month
+	}
+	lastDay := startOfMonth.AddDate(0, 1, -1)
 
 	query := `
-		WITH admin_members AS (
-			SELECT u.id as admin_id, u.username as admin_name, COUNT(m.id) as total_members
+		WITH allocated AS (
+			SELECT p.member_id, SUM(a.amount) as amount
+			FROM payment_allocations a
+			INNER JOIN payments p ON a.payment_id = p.id
+			WHERE a.period = $1::date AND ` + paymentCounts + `
+			GROUP BY p.member_id
+		),
+		responsible AS (
+			SELECT m.id as member_id, member_admin_at(m.id, $2::date) as admin_id,
+				member_expected_amount(m.id, $1::date) as expected,
+				COALESCE(al.amount, 0) as allocated
+			FROM members m
+			LEFT JOIN allocated al ON al.member_id = m.id
+			WHERE member_status_at(m.id, $2::date) = 'active'
+				AND (m.deleted_at IS NULL OR m.deleted_at >= $1)
+		),
+		admin_members AS (
+			SELECT u.id as admin_id, u.username as admin_name,
+				COUNT(rm.member_id) FILTER (WHERE rm.expected > 0 AND rm.allocated >= rm.expected) as paid_count,
+				COUNT(rm.member_id) FILTER (WHERE rm.allocated < rm.expected) as pending_count,
+				COALESCE(SUM(rm.expected), 0) as expected_amount
 			FROM users u
-			LEFT JOIN members m ON u.id = m.admin_id AND m.is_active = true
-			WHERE u.user_type = 'account_admin'
+			INNER JOIN role_capabilities rc ON rc.role_name = u.user_type AND rc.capability = 'members.write'
+			LEFT JOIN responsible rm ON rm.admin_id = u.id
 			GROUP BY u.id, u.username
 		),
-		paid_members AS (
-			SELECT p.admin_id, COUNT(DISTINCT p.member_id) as paid_count, COALESCE(SUM(p.amount), 0) as total_amount
-			FROM payments p
-			WHERE p.payment_date >= $1 AND p.payment_date < $2
-			GROUP BY p.admin_id
+		admin_totals AS (
+			SELECT member_admin_at(al.member_id, $2::date) as admin_id, SUM(al.amount) as total_amount
+			FROM allocated al
+			GROUP BY 1
 		)
 		SELECT 
 			am.admin_id,
 			am.admin_name,
-			COALESCE(pm.paid_count, 0) as paid_members,
-			(am.total_members - COALESCE(pm.paid_count, 0)) as pending_members,
-			COALESCE(pm.total_amount, 0) as total_amount
+			am.paid_count as paid_members,
+			am.pending_count as pending_members,
+			COALESCE(t.total_amount, 0) as total_amount,
+			am.expected_amount
 		FROM admin_members am
-		LEFT JOIN paid_members pm ON am.admin_id = pm.admin_id
+		LEFT JOIN admin_totals t ON am.admin_id = t.admin_id
 		ORDER BY am.admin_name
 	`
 
-	rows, err := h.DB.Query(query, startOfMonth, endOfMonth)
+	rows, err := h.DB.Query(query, startOfMonth, lastDay)
 	if err != nil {
 		log.Printf("Error fetching admin payments report: %v", err)
 		sendJSONError(w, "Failed to fetch report. Please try again later.", http.StatusInternalServerError)
@@ -53,6 +91,7 @@ func (h *Handlers) GetAdminPaymentsReport(w http.ResponseWriter, r *http.Request
 		var report models.AdminPaymentReport
 		err := rows.Scan(
 			&report.AdminID, &report.AdminName, &report.PaidMembers, &report.PendingMembers, &report.TotalAmount,
+			&report.ExpectedAmount,
 		)
 		if err != nil {
 			continue
@@ -63,18 +102,38 @@ func (h *Handlers) GetAdminPaymentsReport(w http.ResponseWriter, r *http.Request
 	sendJSONResponse(w, reports, http.StatusOK)
 }
 
+// monthDuesColumns selects, for the member aliased m, what their plan
+// expected in the month $1 and what was allocated to it.
+const monthDuesColumns = `SELECT member_expected_amount(m.id, $1::date) as expected,
+	COALESCE((SELECT SUM(a.amount) FROM payment_allocations a
+		INNER JOIN payments p ON a.payment_id = p.id
+		WHERE p.member_id = m.id AND a.period = $1::date AND ` + paymentCounts + `), 0) as paid`
+
+// GetMonthlyCollection totals the money received in each of the last 12
+// months, each with what the members' contribution plans expected that
+// month. Cheques count once they clear.
 func (h *Handlers) GetMonthlyCollection(w http.ResponseWriter, r *http.Request) {
 	query := `
-		SELECT 
-			TO_CHAR(payment_date, 'YYYY-MM') as month,
-			SUM(amount) as total
-		FROM payments
-		GROUP BY TO_CHAR(payment_date, 'YYYY-MM')
-		ORDER BY month DESC
-		LIMIT 12
+		SELECT
+			c.month,
+			c.total,
+			(SELECT COALESCE(SUM(member_expected_amount(m.id, TO_DATE(c.month, 'YYYY-MM'))), 0)
+			 FROM members m
+			 WHERE ($1 = 0 OR m.admin_id = $1)) as expected
+		FROM (
+			SELECT 
+				TO_CHAR(p.payment_date, 'YYYY-MM') as month,
+				SUM(p.amount) as total
+			FROM payments p
+			WHERE ($1 = 0 OR p.admin_id = $1) AND ` + paymentReceived + `
+			GROUP BY TO_CHAR(p.payment_date, 'YYYY-MM')
+			ORDER BY month DESC
+			LIMIT 12
+		) c
+		ORDER BY c.month DESC
 	`
 
-	rows, err := h.DB.Query(query)
+	rows, err := h.DB.Query(query, scopeAdminID(r))
 	if err != nil {
 		log.Printf("Error fetching monthly collection: %v", err)
 		sendJSONError(w, "Failed to fetch monthly collection. Please try again later.", http.StatusInternalServerError)
@@ -85,7 +144,7 @@ func (h *Handlers) GetMonthlyCollection(w http.ResponseWriter, r *http.Request)
 	var collections []models.MonthlyCollection
 	for rows.Next() {
 		var collection models.MonthlyCollection
-		err := rows.Scan(&collection.Month, &collection.Total)
+		err := rows.Scan(&collection.Month, &collection.Total, &collection.Expected)
 		if err != nil {
 			continue
 		}
@@ -95,6 +154,9 @@ func (h *Handlers) GetMonthlyCollection(w http.ResponseWriter, r *http.Request)
 	sendJSONResponse(w, collections, http.StatusOK)
 }
 
+// GetMonthlyCollectionDetails lists the payments made in the current month,
+// or in ?month=YYYY-MM, leaving out bounced cheques. total counts only the
+// money received, so cheques still to clear are not included.
 func (h *Handlers) GetMonthlyCollectionDetails(w http.ResponseWriter, r *http.Request) {
 	// Get month parameter from query string, default to current month
 	monthParam := r.URL.Query().Get("month")
@@ -121,15 +183,18 @@ func (h *Handlers) GetMonthlyCollectionDetails(w http.ResponseWriter, r *http.Re
 			p.member_name,
 			p.contact_no,
 			p.amount,
+			` + paymentModeColumns + `,
+			` + paymentReceived + ` as received,
 			u.username as admin_name,
 			TO_CHAR(p.payment_date, 'YYYY-MM-DD') as payment_date
 		FROM payments p
 		LEFT JOIN users u ON p.admin_id = u.id
 		WHERE p.payment_date >= $1 AND p.payment_date < $2
+			AND ($3 = 0 OR p.admin_id = $3) AND ` + paymentCounts + `
 		ORDER BY p.payment_date DESC, p.member_name
 	`
 
-	rows, err := h.DB.Query(query, startOfMonth, endOfMonth)
+	rows, err := h.DB.Query(query, startOfMonth, endOfMonth, scopeAdminID(r))
 	if err != nil {
 		log.Printf("Error fetching monthly collection details: %v", err)
 		sendJSONError(w, "Failed to fetch monthly collection details. Please try again later.", http.StatusInternalServerError)
@@ -142,17 +207,25 @@ func (h *Handlers) GetMonthlyCollectionDetails(w http.ResponseWriter, r *http.Re
 
 	for rows.Next() {
 		var detail models.MonthlyCollectionDetail
+		var received bool
 		err := rows.Scan(
 			&detail.MemberName,
 			&detail.ContactNo,
 			&detail.Amount,
+			&detail.PaymentMode,
+			&detail.ReferenceNo,
+			&detail.ChequeStatus,
+			&received,
 			&detail.AdminName,
 			&detail.PaymentDate,
 		)
 		if err != nil {
 			continue
 		}
-		totalAmount += detail.Amount
+		// Uncleared cheques are listed but not yet collected.
+		if received {
+			totalAmount += detail.Amount
+		}
 		details = append(details, detail)
 	}
 
@@ -170,12 +243,13 @@ func (h *Handlers) GetMonthlyDonations(w http.ResponseWriter, r *http.Request) {
 			TO_CHAR(donation_date, 'YYYY-MM') as month,
 			SUM(amount) as total
 		FROM donations
+		WHERE ($1 = 0 OR admin_id = $1)
 		GROUP BY TO_CHAR(donation_date, 'YYYY-MM')
 		ORDER BY month DESC
 		LIMIT 12
 	`
 
-	rows, err := h.DB.Query(query)
+	rows, err := h.DB.Query(query, scopeAdminID(r))
 	if err != nil {
 		log.Printf("Error fetching monthly donations: %v", err)
 		sendJSONError(w, "Failed to fetch monthly donations. Please try again later.", http.StatusInternalServerError)
@@ -227,10 +301,11 @@ func (h *Handlers) GetMonthlyDonationDetails(w http.ResponseWriter, r *http.Requ
 		FROM donations d
 		LEFT JOIN users u ON d.admin_id = u.id
 		WHERE d.donation_date >= $1 AND d.donation_date < $2
+			AND ($3 = 0 OR d.admin_id = $3)
 		ORDER BY d.donation_date DESC, d.beneficiary_name
 	`
 
-	rows, err := h.DB.Query(query, startOfMonth, endOfMonth)
+	rows, err := h.DB.Query(query, startOfMonth, endOfMonth, scopeAdminID(r))
 	if err != nil {
 		log.Printf("Error fetching monthly donation details: %v", err)
 		sendJSONError(w, "Failed to fetch monthly donation details. Please try again later.", http.StatusInternalServerError)
@@ -265,69 +340,104 @@ func (h *Handlers) GetMonthlyDonationDetails(w http.ResponseWriter, r *http.Requ
 	}, http.StatusOK)
 }
 
+// GetPoolBalance totals the money received less donations given. Cheques
+// count once they clear; uncleared_cheques totals those still received or
+// deposited.
 func (h *Handlers) GetPoolBalance(w http.ResponseWriter, r *http.Request) {
 	var totalPayments float64
 	var totalDonations float64
+	var unclearedCheques float64
 
-	h.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM payments").Scan(&totalPayments)
+	h.DB.QueryRow("SELECT COALESCE(SUM(p.amount), 0) FROM payments p WHERE " + paymentReceived).Scan(&totalPayments)
+	h.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM payments WHERE cheque_status IN ('received', 'deposited')").Scan(&unclearedCheques)
 	h.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM donations").Scan(&totalDonations)
 
 	balance := totalPayments - totalDonations
 
 	sendJSONResponse(w, map[string]interface{}{
-		"total_payments":  totalPayments,
-		"total_donations": totalDonations,
-		"balance":         balance,
+		"total_payments":    totalPayments,
+		"total_donations":   totalDonations,
+		"uncleared_cheques": unclearedCheques,
+		"balance":           balance,
 	}, http.StatusOK)
 }
 
+// GetPaidMembersReport lists the payments allocated to the current month,
+// or to ?month=YYYY-MM, whenever they were made, of the members whose
+// allocations cover what their plan expected. paid_amount is the part of
+// each payment counted towards that month.
 func (h *Handlers) GetPaidMembersReport(w http.ResponseWriter, r *http.Request) {
-	// Get current month
 	now := time.Now()
-	year, month := now.Year(), now.Month()
-	startOfMonth := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
-	endOfMonth := startOfMonth.AddDate(0, 1, 0)
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if raw := r.URL.Query().Get("month"); raw != "" {
+		month, err := time.Parse("2006-01", raw)
+		if err != nil {
+			sendJSONError(w, "month must be in YYYY-MM format", http.StatusBadRequest)
+			return
+		}
+		startOfMonth = month
+	}
 
 	// Get user info
 	adminID := getUserIDFromRequest(r)
-	userType := getUserTypeFromRequest(r)
+	seesAll := scopeAdminID(r) == 0
+
+	args := []interface{}{startOfMonth}
+	if !seesAll {
+		args = append(args, adminID)
+	}
+	filter, ok := h.memberReportFilter(w, r, &args)
+	if !ok {
+		return
+	}
 
 	var query string
 	var rows *sql.Rows
 	var err error
 
-	if userType == "master_admin" {
-		// Master admin sees all paid members
+	if seesAll {
+		// Callers with access to all records see every paid member
 		query = `
 			SELECT 
 				p.member_name,
 				p.contact_no as mobile_no,
-				p.amount as paid_amount,
+				a.amount as paid_amount,
+				dues.expected as expected_amount,
 				TO_CHAR(p.payment_date, 'YYYY-MM-DD') as payment_date,
-				u.username as admin_name
-			FROM payments p
+				u.username as admin_name,
+				` + memberAttributeColumns + `
+			FROM payment_allocations a
+			INNER JOIN payments p ON a.payment_id = p.id
+			INNER JOIN members m ON p.member_id = m.id
 			LEFT JOIN users u ON p.admin_id = u.id
-			WHERE p.payment_date >= $1 AND p.payment_date < $2
+			CROSS JOIN LATERAL (` + monthDuesColumns + `) dues
+			WHERE a.period = $1::date AND ` + paymentCounts + `
+				AND dues.paid >= dues.expected` + filter + `
 			ORDER BY p.payment_date DESC, p.member_name
 		`
-		rows, err = h.DB.Query(query, startOfMonth, endOfMonth)
 	} else {
-		// Account admin sees only their paid members
+		// Everyone else sees only their own paid members
 		query = `
 			SELECT 
 				p.member_name,
 				p.contact_no as mobile_no,
-				p.amount as paid_amount,
+				a.amount as paid_amount,
+				dues.expected as expected_amount,
 				TO_CHAR(p.payment_date, 'YYYY-MM-DD') as payment_date,
-				u.username as admin_name
-			FROM payments p
+				u.username as admin_name,
+				` + memberAttributeColumns + `
+			FROM payment_allocations a
+			INNER JOIN payments p ON a.payment_id = p.id
+			INNER JOIN members m ON p.member_id = m.id
 			LEFT JOIN users u ON p.admin_id = u.id
-			WHERE p.payment_date >= $1 AND p.payment_date < $2
-				AND p.admin_id = $3
+			CROSS JOIN LATERAL (` + monthDuesColumns + `) dues
+			WHERE a.period = $1::date AND ` + paymentCounts + `
+				AND dues.paid >= dues.expected
+				AND p.admin_id = $2` + filter + `
 			ORDER BY p.payment_date DESC, p.member_name
 		`
-		rows, err = h.DB.Query(query, startOfMonth, endOfMonth, adminID)
 	}
+	rows, err = h.DB.Query(query, args...)
 
 	if err != nil {
 		log.Printf("Error fetching paid members report: %v", err)
@@ -339,14 +449,18 @@ func (h *Handlers) GetPaidMembersReport(w http.ResponseWriter, r *http.Request)
 	var paidMembers []models.PaidMemberReport
 	for rows.Next() {
 		var member models.PaidMemberReport
+		var customFields []byte
 		err := rows.Scan(
 			&member.MemberName,
 			&member.MobileNo,
 			&member.PaidAmount,
+			&member.ExpectedAmount,
 			&member.PaymentDate,
 			&member.AdminName,
+			&customFields,
+			pq.Array(&member.Tags),
 		)
-		if err != nil {
+		if err != nil || decodeMemberAttributes(customFields, &member.CustomFields, &member.Tags) != nil {
 			continue
 		}
 		paidMembers = append(paidMembers, member)
@@ -355,59 +469,78 @@ func (h *Handlers) GetPaidMembersReport(w http.ResponseWriter, r *http.Request)
 	sendJSONResponse(w, paidMembers, http.StatusOK)
 }
 
+// GetUnpaidMembersReport lists the members who were active in the current
+// month, or in ?month=YYYY-MM, and whose payments allocated to it fall short
+// of what their plan expected. paid_amount is what was allocated.
 func (h *Handlers) GetUnpaidMembersReport(w http.ResponseWriter, r *http.Request) {
-	// Get current month
 	now := time.Now()
-	year, month := now.Year(), now.Month()
-	startOfMonth := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
-	endOfMonth := startOfMonth.AddDate(0, 1, 0)
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if raw := r.URL.Query().Get("month"); raw != "" {
+		month, err := time.Parse("2006-01", raw)
+		if err != nil {
+			sendJSONError(w, "month must be in YYYY-MM format", http.StatusBadRequest)
+			return
+		}
+		startOfMonth = month
+	}
+	lastDay := startOfMonth.AddDate(0, 1, -1)
 
 	// Get user info
 	adminID := getUserIDFromRequest(r)
-	userType := getUserTypeFromRequest(r)
+	seesAll := scopeAdminID(r) == 0
+
+	args := []interface{}{startOfMonth, lastDay}
+	if !seesAll {
+		args = append(args, adminID)
+	}
+	filter, ok := h.memberReportFilter(w, r, &args)
+	if !ok {
+		return
+	}
 
 	var query string
 	var rows *sql.Rows
 	var err error
 
-	if userType == "master_admin" {
-		// Master admin sees all unpaid members
+	if seesAll {
+		// Callers with access to all records see every unpaid member
 		query = `
-			SELECT DISTINCT
+			SELECT
 				m.name as member_name,
 				m.mobile_no,
-				u.username as admin_name
+				dues.expected as expected_amount,
+				dues.paid as paid_amount,
+				u.username as admin_name,
+				` + memberAttributeColumns + `
 			FROM members m
 			INNER JOIN users u ON m.admin_id = u.id
-			WHERE m.is_active = true
-				AND m.id NOT IN (
-					SELECT DISTINCT p.member_id
-					FROM payments p
-					WHERE p.payment_date >= $1 AND p.payment_date < $2
-				)
+			CROSS JOIN LATERAL (` + monthDuesColumns + `) dues
+			WHERE member_status_at(m.id, $2::date) = 'active'
+				AND (m.deleted_at IS NULL OR m.deleted_at >= $1)
+				AND dues.paid < dues.expected` + filter + `
 			ORDER BY u.username, m.name
 		`
-		rows, err = h.DB.Query(query, startOfMonth, endOfMonth)
 	} else {
-		// Account admin sees only their unpaid members
+		// Everyone else sees only their own unpaid members
 		query = `
-			SELECT DISTINCT
+			SELECT
 				m.name as member_name,
 				m.mobile_no,
-				u.username as admin_name
+				dues.expected as expected_amount,
+				dues.paid as paid_amount,
+				u.username as admin_name,
+				` + memberAttributeColumns + `
 			FROM members m
 			INNER JOIN users u ON m.admin_id = u.id
-			WHERE m.is_active = true
+			CROSS JOIN LATERAL (` + monthDuesColumns + `) dues
+			WHERE member_status_at(m.id, $2::date) = 'active'
+				AND (m.deleted_at IS NULL OR m.deleted_at >= $1)
 				AND m.admin_id = $3
-				AND m.id NOT IN (
-					SELECT DISTINCT p.member_id
-					FROM payments p
-					WHERE p.payment_date >= $1 AND p.payment_date < $2
-				)
+				AND dues.paid < dues.expected` + filter + `
 			ORDER BY m.name
 		`
-		rows, err = h.DB.Query(query, startOfMonth, endOfMonth, adminID)
 	}
+	rows, err = h.DB.Query(query, args...)
 
 	if err != nil {
 		log.Printf("Error fetching unpaid members report: %v", err)
@@ -419,12 +552,17 @@ func (h *Handlers) GetUnpaidMembersReport(w http.ResponseWriter, r *http.Request
 	var unpaidMembers []models.UnpaidMemberReport
 	for rows.Next() {
 		var member models.UnpaidMemberReport
+		var customFields []byte
 		err := rows.Scan(
 			&member.MemberName,
 			&member.MobileNo,
+			&member.ExpectedAmount,
+			&member.PaidAmount,
 			&member.AdminName,
+			&customFields,
+			pq.Array(&member.Tags),
 		)
-		if err != nil {
+		if err != nil || decodeMemberAttributes(customFields, &member.CustomFields, &member.Tags) != nil {
 			continue
 		}
 		unpaidMembers = append(unpaidMembers, member)
@@ -432,3 +570,17 @@ func (h *Handlers) GetUnpaidMembersReport(w http.ResponseWriter, r *http.Request
 
 	sendJSONResponse(w, unpaidMembers, http.StatusOK)
 }
+
+// memberReportFilter reads the memberAttributeFilter parameters of a report
+// on members aliased m, appending their arguments to args. It returns the
+// conditions as a clause to append to the report's WHERE.
+func (h *Handlers) memberReportFilter(w http.ResponseWriter, r *http.Request, args *[]interface{}) (string, bool) {
+	conditions, ok := h.memberAttributeFilter(w, r, func(value interface{}) string {
+		*args = append(*args, value)
+		return "$" + strconv.Itoa(len(*args))
+	})
+	if !ok || len(conditions) == 0 {
+		return "", ok
+	}
+	return " AND " + strings.Join(conditions, " AND "), true
+}
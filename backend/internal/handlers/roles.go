@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,49}$`)
+
+// GetCapabilities lists every capability a role can be granted.
+func (h *Handlers) GetCapabilities(w http.ResponseWriter, r *http.Request) {
+	type capability struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	var capabilities []capability
+	for _, name := range middleware.AllCapabilities() {
+		capabilities = append(capabilities, capability{Name: name, Description: middleware.Capabilities[name]})
+	}
+
+	sendJSONResponse(w, capabilities, http.StatusOK)
+}
+
+func (h *Handlers) GetRoles(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT
+			ro.name,
+			ro.description,
+			ro.is_system,
+			ARRAY_REMOVE(ARRAY_AGG(DISTINCT rc.capability), NULL),
+			(SELECT COUNT(*) FROM users u WHERE u.user_type = ro.name),
+			ro.created_at,
+			ro.updated_at
+		FROM roles ro
+		LEFT JOIN role_capabilities rc ON rc.role_name = ro.name
+		GROUP BY ro.name
+		ORDER BY ro.is_system DESC, ro.name
+	`
+
+	rows, err := h.DB.Query(query)
+	if err != nil {
+		log.Printf("Error fetching roles: %v", err)
+		sendJSONError(w, "Failed to fetch roles. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		err := rows.Scan(
+			&role.Name, &role.Description, &role.IsSystem, pq.Array(&role.Capabilities),
+			&role.UserCount, &role.CreatedAt, &role.UpdatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		if role.Name == middleware.RoleMasterAdmin {
+			role.Capabilities = middleware.AllCapabilities()
+		}
+		roles = append(roles, role)
+	}
+
+	sendJSONResponse(w, roles, http.StatusOK)
+}
+
+// CreateRole defines a new role. Callers can only grant capabilities they
+// hold themselves.
+func (h *Handlers) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name         string   `json:"name"`
+		Description  string   `json:"description"`
+		Capabilities []string `json:"capabilities"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if !roleNamePattern.MatchString(req.Name) || req.Name == middleware.RoleMember {
+		sendJSONError(w, "Role names must be 2-50 lowercase letters, digits or underscores, starting with a letter", http.StatusBadRequest)
+		return
+	}
+
+	capabilities, ok := validateRoleCapabilities(w, r, req.Capabilities, nil)
+	if !ok {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting role creation: %v", err)
+		sendJSONError(w, "Failed to create role. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO roles (name, description) VALUES ($1, $2)", req.Name, strings.TrimSpace(req.Description))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			sendJSONError(w, "A role with this name already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Error creating role: %v", err)
+		sendJSONError(w, "Failed to create role. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := replaceRoleCapabilities(tx, req.Name, capabilities); err != nil {
+		log.Printf("Error storing role capabilities: %v", err)
+		sendJSONError(w, "Failed to create role. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing role: %v", err)
+		sendJSONError(w, "Failed to create role. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, models.Role{
+		Name:         req.Name,
+		Description:  strings.TrimSpace(req.Description),
+		Capabilities: capabilities,
+	}, http.StatusCreated)
+}
+
+// UpdateRole changes a role's description and/or capabilities. Callers can
+// only grant or revoke capabilities they hold themselves. Changes apply to
+// users holding the role on their next request.
+func (h *Handlers) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var req struct {
+		Description  *string   `json:"description"`
+		Capabilities *[]string `json:"capabilities"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if name == middleware.RoleMasterAdmin {
+		sendJSONError(w, "The master_admin role always has every capability and cannot be edited", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting role update: %v", err)
+		sendJSONError(w, "Failed to update role. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE roles SET description = COALESCE($1, description), updated_at = CURRENT_TIMESTAMP WHERE name = $2",
+		req.Description, name,
+	)
+	if err != nil {
+		log.Printf("Error updating role: %v", err)
+		sendJSONError(w, "Failed to update role. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		sendJSONError(w, "Role not found", http.StatusNotFound)
+		return
+	}
+
+	if req.Capabilities != nil {
+		current, err := roleCapabilities(tx, name)
+		if err != nil {
+			log.Printf("Error fetching role capabilities: %v", err)
+			sendJSONError(w, "Failed to update role. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+
+		capabilities, ok := validateRoleCapabilities(w, r, *req.Capabilities, current)
+		if !ok {
+			return
+		}
+
+		if err := replaceRoleCapabilities(tx, name, capabilities); err != nil {
+			log.Printf("Error storing role capabilities: %v", err)
+			sendJSONError(w, "Failed to update role. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing role update: %v", err)
+		sendJSONError(w, "Failed to update role. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]string{"message": "Role updated successfully"}, http.StatusOK)
+}
+
+// DeleteRole removes a custom role that is no longer assigned to anyone.
+func (h *Handlers) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var isSystem bool
+	err := h.DB.QueryRow("SELECT is_system FROM roles WHERE name = $1", name).Scan(&isSystem)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Role not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching role: %v", err)
+		sendJSONError(w, "Failed to delete role. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if isSystem {
+		sendJSONError(w, "Built-in roles cannot be deleted", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.DB.Exec("DELETE FROM roles WHERE name = $1", name); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" { // foreign_key_violation
+			sendJSONError(w, "Role is still assigned to users or invitations", http.StatusConflict)
+			return
+		}
+		log.Printf("Error deleting role: %v", err)
+		sendJSONError(w, "Failed to delete role. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]string{"message": "Role deleted successfully"}, http.StatusOK)
+}
+
+// validateRoleCapabilities checks that every requested capability exists and
+// that the caller holds every capability being added to or removed from
+// current, and returns the requested ones de-duplicated and sorted.
+func validateRoleCapabilities(w http.ResponseWriter, r *http.Request, requested, current []string) ([]string, bool) {
+	seen := make(map[string]bool)
+	var capabilities []string
+	for _, c := range requested {
+		if _, known := middleware.Capabilities[c]; !known {
+			sendJSONError(w, "Unknown capability: "+c, http.StatusBadRequest)
+			return nil, false
+		}
+		if !containsString(current, c) && !middleware.HasCapability(r, c) {
+			sendJSONError(w, "You cannot grant a capability you do not have: "+c, http.StatusForbidden)
+			return nil, false
+		}
+		if !seen[c] {
+			seen[c] = true
+			capabilities = append(capabilities, c)
+		}
+	}
+	for _, c := range current {
+		if !seen[c] && !middleware.HasCapability(r, c) {
+			sendJSONError(w, "You cannot revoke a capability you do not have: "+c, http.StatusForbidden)
+			return nil, false
+		}
+	}
+	sort.Strings(capabilities)
+	return capabilities, true
+}
+
+func roleCapabilities(tx *sql.Tx, role string) ([]string, error) {
+	rows, err := tx.Query("SELECT capability FROM role_capabilities WHERE role_name = $1 ORDER BY capability", role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var capabilities []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		capabilities = append(capabilities, c)
+	}
+	return capabilities, rows.Err()
+}
+
+func replaceRoleCapabilities(tx *sql.Tx, role string, capabilities []string) error {
+	if _, err := tx.Exec("DELETE FROM role_capabilities WHERE role_name = $1", role); err != nil {
+		return err
+	}
+	for _, c := range capabilities {
+		if _, err := tx.Exec("INSERT INTO role_capabilities (role_name, capability) VALUES ($1, $2)", role, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canAssignRole reports whether the caller holds every capability of role,
+// so managing users can never be used to escalate privileges. Only master
+// admins can hand out or act on master_admin.
+func (h *Handlers) canAssignRole(r *http.Request, role string) (bool, error) {
+	if role == middleware.RoleMasterAdmin {
+		return getUserTypeFromRequest(r) == middleware.RoleMasterAdmin, nil
+	}
+
+	capabilities, err := middleware.CapabilitiesForRole(h.DB, role)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range capabilities {
+		if !middleware.HasCapability(r, c) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// authorizeRoleAssignment checks that role exists and that the caller may
+// assign it. On failure it writes the error response and returns false.
+func (h *Handlers) authorizeRoleAssignment(w http.ResponseWriter, r *http.Request, role string) bool {
+	var exists bool
+	if err := h.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)", role).Scan(&exists); err != nil {
+		log.Printf("Error checking role: %v", err)
+		sendJSONError(w, "Failed to check role. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+	if !exists {
+		sendJSONError(w, "Invalid user type", http.StatusBadRequest)
+		return false
+	}
+
+	allowed, err := h.canAssignRole(r, role)
+	if err != nil {
+		log.Printf("Error checking role capabilities: %v", err)
+		sendJSONError(w, "Failed to check role. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		sendJSONError(w, "You cannot assign a role with capabilities you do not have", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeUserManagement checks that the caller may manage the target user,
+// i.e. could have assigned the target's role. On failure it writes the error
+// response and returns false.
+func (h *Handlers) authorizeUserManagement(w http.ResponseWriter, r *http.Request, role string) bool {
+	allowed, err := h.canAssignRole(r, role)
+	if err != nil {
+		log.Printf("Error checking role capabilities: %v", err)
+		sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		sendJSONError(w, "You cannot manage a user whose role has capabilities you do not have", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/middleware"
+)
+
+func TestUpdateRoleCapabilities(t *testing.T) {
+	// The caller can manage roles and read members, but cannot touch payments.
+	callerCaps := middleware.CapRolesManage + " " + middleware.CapMembersRead
+
+	tests := []struct {
+		name      string
+		current   []string
+		requested []string
+		wantCode  int
+	}{
+		{"grant a held capability", []string{"payments.read"}, []string{"members.read", "payments.read"}, http.StatusOK},
+		{"revoke a held capability", []string{"members.read", "payments.read"}, []string{"payments.read"}, http.StatusOK},
+		{"keep capabilities the caller lacks", []string{"payments.read"}, []string{"payments.read", "payments.read"}, http.StatusOK},
+		{"grant a missing capability", []string{"payments.read"}, []string{"payments.read", "payments.write"}, http.StatusForbidden},
+		{"revoke a missing capability", []string{"members.read", "payments.read"}, []string{"members.read"}, http.StatusForbidden},
+		{"unknown capability", nil, []string{"members.delete"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE roles").WillReturnResult(sqlmock.NewResult(0, 1))
+			rows := sqlmock.NewRows([]string{"capability"})
+			for _, c := range tt.current {
+				rows.AddRow(c)
+			}
+			mock.ExpectQuery("SELECT capability FROM role_capabilities").WithArgs("treasurer").WillReturnRows(rows)
+			if tt.wantCode == http.StatusOK {
+				mock.ExpectExec("DELETE FROM role_capabilities").WillReturnResult(sqlmock.NewResult(0, 1))
+				seen := make(map[string]bool)
+				for _, c := range tt.requested {
+					if !seen[c] {
+						seen[c] = true
+						mock.ExpectExec("INSERT INTO role_capabilities").WithArgs("treasurer", c).
+							WillReturnResult(sqlmock.NewResult(0, 1))
+					}
+				}
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			body, _ := json.Marshal(map[string][]string{"capabilities": tt.requested})
+			r := httptest.NewRequest(http.MethodPut, "/api/roles/treasurer", strings.NewReader(string(body)))
+			r.Header.Set("X-Capabilities", callerCaps)
+			r = mux.SetURLVars(r, map[string]string{"name": "treasurer"})
+			w := httptest.NewRecorder()
+			(&Handlers{DB: db}).UpdateRole(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCanAssignRole(t *testing.T) {
+	tests := []struct {
+		name         string
+		userType     string
+		capabilities string
+		role         string
+		roleCaps     []string
+		want         bool
+	}{
+		{"holds every capability", "treasurer", "members.read payments.read payments.write", "cashier", []string{"payments.read", "payments.write"}, true},
+		{"lacks one capability", "treasurer", "members.read payments.read", "cashier", []string{"payments.read", "payments.write"}, false},
+		{"role without capabilities", "treasurer", "", "viewer", nil, true},
+		{"master_admin by a master admin", middleware.RoleMasterAdmin, "", middleware.RoleMasterAdmin, nil, true},
+		{"master_admin by anyone else", "treasurer", strings.Join(middleware.AllCapabilities(), " "), middleware.RoleMasterAdmin, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if tt.role != middleware.RoleMasterAdmin {
+				rows := sqlmock.NewRows([]string{"capability"})
+				for _, c := range tt.roleCaps {
+					rows.AddRow(c)
+				}
+				mock.ExpectQuery("SELECT capability FROM role_capabilities").WithArgs(tt.role).WillReturnRows(rows)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/users", nil)
+			r.Header.Set("X-User-Type", tt.userType)
+			r.Header.Set("X-Capabilities", tt.capabilities)
+
+			got, err := (&Handlers{DB: db}).canAssignRole(r, tt.role)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("canAssignRole(%q) = %v, want %v", tt.role, got, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/khidmat/backend/internal/middleware"
+)
+
+// issueSession creates an access token and a new refresh token family for
+// the user and returns the login response body.
+func (h *Handlers) issueSession(r *http.Request, userID int, userType string) (map[string]interface{}, error) {
+	familyID, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, _, err := h.storeRefreshToken(h.DB, r, userID, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := middleware.GenerateToken(userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities, err := middleware.CapabilitiesForRole(h.DB, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(middleware.AccessTokenTTL.Seconds()),
+		"user_id":       userID,
+		"user_type":     userType,
+		"capabilities":  capabilities,
+	}, nil
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// storeRefreshToken generates a refresh token in the given family and
+// persists its hash. It returns the plaintext token and the new row ID.
+func (h *Handlers) storeRefreshToken(q queryRower, r *http.Request, userID int, familyID string) (string, int, error) {
+	refreshToken, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		return "", 0, err
+	}
+
+	var tokenID int
+	err = q.QueryRow(
+		`INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at, user_agent, ip_address)
+		 VALUES ($1, $2, $3, CURRENT_TIMESTAMP + $4 * INTERVAL '1 second', $5, $6) RETURNING id`,
+		userID, middleware.HashToken(refreshToken), familyID, int(middleware.RefreshTokenTTL.Seconds()),
+		r.UserAgent(), clientIP(r),
+	).Scan(&tokenID)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return refreshToken, tokenID, nil
+}
+
+func (h *Handlers) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting refresh transaction: %v", err)
+		sendJSONError(w, "Failed to refresh session. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var tokenID, userID int
+	var familyID, userType string
+	var revoked, expired bool
+	err = tx.QueryRow(`
+		SELECT rt.id, rt.user_id, rt.family_id, rt.revoked_at IS NOT NULL, rt.expires_at < CURRENT_TIMESTAMP, u.user_type
+		FROM refresh_tokens rt
+		INNER JOIN users u ON rt.user_id = u.id
+		WHERE rt.token_hash = $1 AND u.is_active = true
+		FOR UPDATE OF rt
+	`, middleware.HashToken(req.RefreshToken)).Scan(&tokenID, &userID, &familyID, &revoked, &expired, &userType)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error fetching refresh token: %v", err)
+		sendJSONError(w, "Failed to refresh session. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if revoked {
+		// A rotated token was presented again: assume it was stolen and kill
+		// every token descended from the same login.
+		if _, err := tx.Exec(
+			"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = $1 AND revoked_at IS NULL",
+			familyID,
+		); err != nil {
+			log.Printf("Error revoking refresh token family: %v", err)
+		} else if err := tx.Commit(); err != nil {
+			log.Printf("Error committing refresh token family revocation: %v", err)
+		}
+		sendJSONError(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if expired {
+		sendJSONError(w, "Refresh token expired", http.StatusUnauthorized)
+		return
+	}
+
+	newRefreshToken, newTokenID, err := h.storeRefreshToken(tx, r, userID, familyID)
+	if err != nil {
+		log.Printf("Error storing refresh token: %v", err)
+		sendJSONError(w, "Failed to refresh session. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, replaced_by = $1 WHERE id = $2",
+		newTokenID, tokenID,
+	)
+	if err != nil {
+		log.Printf("Error rotating refresh token: %v", err)
+		sendJSONError(w, "Failed to refresh session. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing refresh token rotation: %v", err)
+		sendJSONError(w, "Failed to refresh session. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := middleware.GenerateToken(userID, userType)
+	if err != nil {
+		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"token":         accessToken,
+		"refresh_token": newRefreshToken,
+		"expires_in":    int(middleware.AccessTokenTTL.Seconds()),
+		"user_id":       userID,
+		"user_type":     userType,
+	}, http.StatusOK)
+}
+
+// Logout revokes the access token used for this request and, if supplied,
+// the refresh token belonging to the same device.
+func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// The body is optional; an empty body only revokes the access token.
+	json.NewDecoder(r.Body).Decode(&req)
+
+	userID := getUserIDFromRequest(r)
+	if userID == 0 {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	_, err := h.DB.Exec(
+		`INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		 VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second')
+		 ON CONFLICT (jti) DO NOTHING`,
+		getTokenIDFromRequest(r), userID, int(middleware.AccessTokenTTL.Seconds()),
+	)
+	if err != nil {
+		log.Printf("Error revoking access token: %v", err)
+		sendJSONError(w, "Failed to log out. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if req.RefreshToken != "" {
+		_, err = h.DB.Exec(
+			`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+			 WHERE token_hash = $1 AND user_id = $2 AND revoked_at IS NULL`,
+			middleware.HashToken(req.RefreshToken), userID,
+		)
+		if err != nil {
+			log.Printf("Error revoking refresh token: %v", err)
+			sendJSONError(w, "Failed to log out. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Expired entries no longer need to be remembered.
+	if _, err := h.DB.Exec("DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP"); err != nil {
+		log.Printf("Error pruning revoked tokens: %v", err)
+	}
+
+	sendJSONResponse(w, map[string]string{"message": "Logged out"}, http.StatusOK)
+}
+
+// LogoutAll invalidates every access and refresh token issued to the user.
+func (h *Handlers) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == 0 {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.revokeAllSessions(h.DB, userID); err != nil {
+		log.Printf("Error logging out all sessions: %v", err)
+		sendJSONError(w, "Failed to log out. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]string{"message": "Logged out of all devices"}, http.StatusOK)
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// revokeAllSessions rejects access tokens issued before now and revokes all
+// outstanding refresh tokens for the user.
+func (h *Handlers) revokeAllSessions(e execer, userID int) error {
+	_, err := e.Exec(
+		"UPDATE users SET tokens_valid_after = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1",
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.Exec(
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL",
+		userID,
+	)
+	return err
+}
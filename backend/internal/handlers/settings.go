@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const settingRequireTwoFactor = "require_two_factor"
+
+type settingDefinition struct {
+	Default  string
+	Validate func(value string) bool
+}
+
+// settingDefinitions lists the organisation-wide settings that holders of
+// settings.manage may change, with their defaults and value validation.
+var settingDefinitions = map[string]settingDefinition{
+	settingRequireTwoFactor:      {Default: "false", Validate: isBoolSetting},
+	settingDefaultCountryCode:    {Default: "91", Validate: isCountryCodeSetting},
+	settingDuplicateMobilePolicy: {Default: duplicateMobileWarn, Validate: isDuplicateMobilePolicySetting},
+}
+
+func isBoolSetting(value string) bool {
+	_, err := strconv.ParseBool(value)
+	return err == nil
+}
+
+// getSetting returns the stored value of a setting, or its default.
+func (h *Handlers) getSetting(key string) (string, error) {
+	var value string
+	err := h.DB.QueryRow("SELECT value FROM settings WHERE key = $1", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return settingDefinitions[key].Default, nil
+	}
+	return value, err
+}
+
+func (h *Handlers) getBoolSetting(key string) (bool, error) {
+	value, err := h.getSetting(key)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(value)
+}
+
+func (h *Handlers) GetSettings(w http.ResponseWriter, r *http.Request) {
+	settings := make(map[string]string, len(settingDefinitions))
+	for key, def := range settingDefinitions {
+		settings[key] = def.Default
+	}
+
+	rows, err := h.DB.Query("SELECT key, value FROM settings")
+	if err != nil {
+		log.Printf("Error fetching settings: %v", err)
+		sendJSONError(w, "Failed to fetch settings. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			continue
+		}
+		if _, known := settingDefinitions[key]; known {
+			settings[key] = value
+		}
+	}
+
+	sendJSONResponse(w, settings, http.StatusOK)
+}
+
+func (h *Handlers) UpdateSetting(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	def, known := settingDefinitions[key]
+	if !known {
+		sendJSONError(w, "Unknown setting", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Value string `json:"value"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !def.Validate(req.Value) {
+		sendJSONError(w, "Invalid value for setting", http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.DB.Exec(
+		`INSERT INTO settings (key, value, updated_by) VALUES ($1, $2, $3)
+		 ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_by = EXCLUDED.updated_by, updated_at = CURRENT_TIMESTAMP`,
+		key, req.Value, getUserIDFromRequest(r),
+	)
+	if err != nil {
+		log.Printf("Error updating setting: %v", err)
+		sendJSONError(w, "Failed to update setting. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]string{
+		"key":   key,
+		"value": req.Value,
+	}, http.StatusOK)
+}
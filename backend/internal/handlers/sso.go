@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/oidc"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Single sign-on: OIDCLogin sends the browser to the identity provider,
+// OIDCCallback verifies the result and redirects to the frontend with a
+// short-lived single-use login code, and OIDCExchange trades that code for a
+// session. Tokens therefore never appear in a URL.
+const (
+	oidcStateTTL          = 10 * time.Minute
+	oidcLoginCodeTTL      = time.Minute
+	defaultSSOCallbackURL = "http://localhost:3000/sso-callback"
+)
+
+var usernameUnsafeChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// OIDCLogin starts the authorization code flow.
+func (h *Handlers) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		sendJSONError(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	state, errState := middleware.GenerateOpaqueToken()
+	nonce, errNonce := middleware.GenerateOpaqueToken()
+	verifier, errVerifier := middleware.GenerateOpaqueToken()
+	if errState != nil || errNonce != nil || errVerifier != nil {
+		sendJSONError(w, "Failed to start single sign-on", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := h.OIDC.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Printf("Error building OIDC authorization URL: %v", err)
+		sendJSONError(w, "The identity provider is unavailable. Please try again later.", http.StatusBadGateway)
+		return
+	}
+
+	_, err = h.DB.Exec(
+		`INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at, ip_address)
+		 VALUES ($1, $2, $3, CURRENT_TIMESTAMP + $4 * INTERVAL '1 second', $5)`,
+		middleware.HashToken(state), nonce, verifier, int(oidcStateTTL.Seconds()), clientIP(r),
+	)
+	if err != nil {
+		log.Printf("Error storing OIDC login state: %v", err)
+		sendJSONError(w, "Failed to start single sign-on", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback is the redirect URI registered with the identity provider.
+// The browser is always sent on to the frontend, with either a login code or
+// an error in the URL fragment.
+func (h *Handlers) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		sendJSONError(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if idpError := query.Get("error"); idpError != "" {
+		log.Printf("OIDC provider returned error: %s %s", idpError, query.Get("error_description"))
+		redirectSSOResult(w, r, url.Values{"error": {"Sign-in was cancelled or refused by the identity provider."}})
+		return
+	}
+
+	// Consume the state first so a callback can never be replayed.
+	var loginID int
+	var nonce, verifier string
+	err := h.DB.QueryRow(
+		`UPDATE oidc_logins SET callback_at = CURRENT_TIMESTAMP
+		 WHERE state_hash = $1 AND callback_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		 RETURNING id, nonce, code_verifier`,
+		middleware.HashToken(query.Get("state")),
+	).Scan(&loginID, &nonce, &verifier)
+
+	if err == sql.ErrNoRows {
+		redirectSSOResult(w, r, url.Values{"error": {"Sign-in expired. Please try again."}})
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching OIDC login state: %v", err)
+		redirectSSOResult(w, r, url.Values{"error": {"Sign-in failed. Please try again later."}})
+		return
+	}
+
+	identity, err := h.OIDC.Exchange(query.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("Error completing OIDC sign-in: %v", err)
+		redirectSSOResult(w, r, url.Values{"error": {"Sign-in with the identity provider failed."}})
+		return
+	}
+
+	userID, active, err := h.findOrProvisionSSOUser(identity)
+	if err != nil {
+		log.Printf("Error mapping OIDC identity %q: %v", identity.Email, err)
+		redirectSSOResult(w, r, url.Values{"error": {"Sign-in failed. Please try again later."}})
+		return
+	}
+
+	if userID == 0 {
+		h.recordLoginAttempt(r, 0, identity.Email, false, loginReasonUnknownUser)
+		redirectSSOResult(w, r, url.Values{"error": {"There is no Khidmat account for " + identity.Email + "."}})
+		return
+	}
+
+	if !active {
+		h.recordLoginAttempt(r, userID, "", false, loginReasonDisabled)
+		redirectSSOResult(w, r, url.Values{"error": {"This account has been disabled. Contact a master admin."}})
+		return
+	}
+
+	code, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		redirectSSOResult(w, r, url.Values{"error": {"Sign-in failed. Please try again later."}})
+		return
+	}
+
+	_, err = h.DB.Exec(
+		`UPDATE oidc_logins SET user_id = $1, login_code_hash = $2,
+			login_code_expires_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second'
+		 WHERE id = $4`,
+		userID, middleware.HashToken(code), int(oidcLoginCodeTTL.Seconds()), loginID,
+	)
+	if err != nil {
+		log.Printf("Error storing OIDC login code: %v", err)
+		redirectSSOResult(w, r, url.Values{"error": {"Sign-in failed. Please try again later."}})
+		return
+	}
+
+	redirectSSOResult(w, r, url.Values{"code": {code}})
+}
+
+// OIDCExchange redeems the login code from OIDCCallback for a session. Users
+// with two-factor authentication, or who must set it up, get the same
+// challenge as Login instead.
+func (h *Handlers) OIDCExchange(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	var userType string
+	var totpEnabled bool
+	err := h.DB.QueryRow(
+		`UPDATE oidc_logins l SET redeemed_at = CURRENT_TIMESTAMP
+		 FROM users u
+		 WHERE l.login_code_hash = $1 AND l.redeemed_at IS NULL AND l.login_code_expires_at > CURRENT_TIMESTAMP
+			AND u.id = l.user_id AND u.is_active = true
+		 RETURNING u.id, u.user_type, u.totp_enabled`,
+		middleware.HashToken(req.Code),
+	).Scan(&userID, &userType, &totpEnabled)
+
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Invalid or expired login code", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error redeeming OIDC login code: %v", err)
+		sendJSONError(w, "Failed to sign in. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := h.loginChallenge(userID, userType, totpEnabled)
+	if err != nil {
+		log.Printf("Error creating login challenge: %v", err)
+		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	if challenge != nil {
+		sendJSONResponse(w, challenge, http.StatusOK)
+		return
+	}
+
+	h.resetLoginFailures(userID)
+	h.recordLoginAttempt(r, userID, "", true, loginReasonSSO)
+
+	session, err := h.issueSession(r, userID, userType)
+	if err != nil {
+		log.Printf("Error issuing session: %v", err)
+		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, session, http.StatusOK)
+}
+
+// findOrProvisionSSOUser maps an identity to a user by email. Unknown users
+// are created in OIDC_DEFAULT_ROLE when it is set; otherwise userID is 0.
+func (h *Handlers) findOrProvisionSSOUser(identity *oidc.Identity) (userID int, active bool, err error) {
+	err = h.DB.QueryRow(
+		"SELECT id, is_active FROM users WHERE LOWER(email) = LOWER($1)",
+		identity.Email,
+	).Scan(&userID, &active)
+	if err != sql.ErrNoRows {
+		return userID, active, err
+	}
+
+	role := os.Getenv("OIDC_DEFAULT_ROLE")
+	if role == "" {
+		return 0, false, nil
+	}
+	if role == middleware.RoleMasterAdmin {
+		log.Printf("Refusing to provision %q: OIDC_DEFAULT_ROLE must not be %s", identity.Email, middleware.RoleMasterAdmin)
+		return 0, false, nil
+	}
+
+	// SSO users have no usable local password until they reset one.
+	secret, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		return 0, false, err
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, false, err
+	}
+
+	base := ssoUsername(identity)
+	for attempt := 1; attempt <= 20; attempt++ {
+		username := base
+		if attempt > 1 {
+			username = fmt.Sprintf("%s%d", base, attempt)
+		}
+
+		userID, err = insertUser(h.DB, username, identity.Email, string(passwordHash), role)
+		if err == nil {
+			log.Printf("Provisioned user %q (%s) from single sign-on", username, role)
+			return userID, true, nil
+		}
+
+		if pqErr, ok := err.(*pq.Error); !ok || pqErr.Code != "23505" || !strings.Contains(pqErr.Constraint, "username") {
+			return 0, false, err
+		}
+	}
+
+	return 0, false, fmt.Errorf("no free username for %q", base)
+}
+
+// ssoUsername derives a username from the IdP's preferred username or the
+// local part of the email.
+func ssoUsername(identity *oidc.Identity) string {
+	candidate := identity.PreferredUsername
+	if candidate == "" || strings.Contains(candidate, "@") {
+		candidate = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+
+	username := usernameUnsafeChars.ReplaceAllString(strings.ToLower(candidate), "")
+	if username == "" {
+		username = "user"
+	}
+	return username
+}
+
+func redirectSSOResult(w http.ResponseWriter, r *http.Request, result url.Values) {
+	target := os.Getenv("OIDC_FRONTEND_CALLBACK_URL")
+	if target == "" {
+		target = defaultSSOCallbackURL
+	}
+
+	// The fragment is never sent to servers or written to access logs.
+	http.Redirect(w, r, target+"#"+result.Encode(), http.StatusFound)
+}
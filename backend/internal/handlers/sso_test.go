@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/oidc"
+	"github.com/lib/pq"
+)
+
+func TestOIDCExchangeTwoFactor(t *testing.T) {
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("JWT_KEYS_FILE", "")
+	t.Setenv("JWT_SECRET", "a-test-secret-that-is-long-enough")
+	t.Setenv("JWT_SIGNING_KEY_ID", "")
+	if err := middleware.LoadSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("UPDATE oidc_logins").WithArgs(middleware.HashToken("login-code")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_type", "totp_enabled"}).AddRow(5, "admin", true))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/oidc/exchange", strings.NewReader(`{"code": "login-code"}`))
+	w := httptest.NewRecorder()
+	(&Handlers{DB: db}).OIDCExchange(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["two_factor_required"] != true || body["challenge_token"] == nil {
+		t.Errorf("response = %v, want a two-factor challenge", body)
+	}
+	if _, ok := body["token"]; ok {
+		t.Errorf("response = %v, want no session", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFindOrProvisionSSOUser(t *testing.T) {
+	identity := &oidc.Identity{Email: "aisha@example.org", PreferredUsername: "Aisha.Khan"}
+	usernameTaken := &pq.Error{Code: "23505", Constraint: "users_username_key"}
+
+	tests := []struct {
+		name       string
+		role       string
+		expect     func(sqlmock.Sqlmock)
+		wantID     int
+		wantActive bool
+	}{
+		{
+			name: "existing user",
+			role: middleware.RoleAccountAdmin,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("FROM users WHERE LOWER").WithArgs(identity.Email).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(4, false))
+			},
+			wantID: 4,
+		},
+		{
+			name: "provisioning off",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("FROM users WHERE LOWER").WillReturnError(sql.ErrNoRows)
+			},
+		},
+		{
+			name: "master admin never provisioned",
+			role: middleware.RoleMasterAdmin,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("FROM users WHERE LOWER").WillReturnError(sql.ErrNoRows)
+			},
+		},
+		{
+			name: "username collisions",
+			role: middleware.RoleAccountAdmin,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("FROM users WHERE LOWER").WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery("INSERT INTO users").
+					WithArgs("aisha.khan", identity.Email, sqlmock.AnyArg(), middleware.RoleAccountAdmin).
+					WillReturnError(usernameTaken)
+				mock.ExpectQuery("INSERT INTO users").
+					WithArgs("aisha.khan2", identity.Email, sqlmock.AnyArg(), middleware.RoleAccountAdmin).
+					WillReturnError(usernameTaken)
+				mock.ExpectQuery("INSERT INTO users").
+					WithArgs("aisha.khan3", identity.Email, sqlmock.AnyArg(), middleware.RoleAccountAdmin).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+			},
+			wantID:     9,
+			wantActive: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OIDC_DEFAULT_ROLE", tt.role)
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			tt.expect(mock)
+
+			userID, active, err := (&Handlers{DB: db}).findOrProvisionSSOUser(identity)
+			if err != nil {
+				t.Fatalf("findOrProvisionSSOUser: %v", err)
+			}
+			if userID != tt.wantID || active != tt.wantActive {
+				t.Errorf("findOrProvisionSSOUser = (%d, %v), want (%d, %v)", userID, active, tt.wantID, tt.wantActive)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,531 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	errNoPendingTwoFactorSetup = errors.New("no pending two-factor setup")
+	errInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+)
+
+// twoFactorSetup is returned when enrollment starts. The secret is shown once
+// so the user can add it to an authenticator app.
+type twoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// loginChallenge decides whether a user who passed the password check needs a
+// second step. It returns nil when a session can be issued directly.
+func (h *Handlers) loginChallenge(userID int, userType string, totpEnabled bool) (map[string]interface{}, error) {
+	purpose := ""
+	if totpEnabled {
+		purpose = middleware.PurposeTwoFactor
+	} else {
+		required, err := h.getBoolSetting(settingRequireTwoFactor)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			purpose = middleware.PurposeTwoFactorSetup
+		}
+	}
+
+	if purpose == "" {
+		return nil, nil
+	}
+
+	challengeToken, err := middleware.GenerateChallengeToken(userID, userType, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	response := map[string]interface{}{
+		"challenge_token": challengeToken,
+		"expires_in":      int(middleware.ChallengeTokenTTL.Seconds()),
+	}
+	if purpose == middleware.PurposeTwoFactor {
+		response["two_factor_required"] = true
+	} else {
+		response["two_factor_setup_required"] = true
+	}
+	return response, nil
+}
+
+// VerifyTwoFactor completes a two-step login with a TOTP or recovery code.
+func (h *Handlers) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userID, userType, err := middleware.ParseChallengeToken(req.ChallengeToken, middleware.PurposeTwoFactor)
+	if err != nil {
+		sendJSONError(w, "Login challenge expired. Please log in again.", http.StatusUnauthorized)
+		return
+	}
+
+	if h.rejectThrottledLogin(w, r, userID, "") {
+		return
+	}
+
+	var ok bool
+	switch {
+	case req.Code != "":
+		ok, err = h.verifyTOTP(userID, req.Code)
+	case req.RecoveryCode != "":
+		ok, err = h.useRecoveryCode(userID, req.RecoveryCode)
+	default:
+		sendJSONError(w, "A code or recovery code is required", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error verifying two-factor code: %v", err)
+		sendJSONError(w, "Failed to verify code. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		h.registerLoginFailure(userID)
+		h.recordLoginAttempt(r, userID, "", false, loginReasonInvalidCode)
+		sendJSONError(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	h.resetLoginFailures(userID)
+	h.recordLoginAttempt(r, userID, "", true, loginReasonSuccess)
+
+	session, err := h.issueSession(r, userID, userType)
+	if err != nil {
+		log.Printf("Error issuing session: %v", err)
+		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, session, http.StatusOK)
+}
+
+// EnrollTwoFactor starts the mandatory enrollment of a user who logged in
+// while two-factor authentication is required but not yet set up.
+func (h *Handlers) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userID, _, err := middleware.ParseChallengeToken(req.ChallengeToken, middleware.PurposeTwoFactorSetup)
+	if err != nil {
+		sendJSONError(w, "Login challenge expired. Please log in again.", http.StatusUnauthorized)
+		return
+	}
+
+	setup, err := h.beginTwoFactorSetup(userID)
+	if err != nil {
+		log.Printf("Error starting two-factor setup: %v", err)
+		sendJSONError(w, "Failed to start two-factor setup. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, setup, http.StatusOK)
+}
+
+// ConfirmTwoFactorEnrollment finishes mandatory enrollment and logs the user
+// in. Recovery codes are returned once.
+func (h *Handlers) ConfirmTwoFactorEnrollment(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userID, userType, err := middleware.ParseChallengeToken(req.ChallengeToken, middleware.PurposeTwoFactorSetup)
+	if err != nil {
+		sendJSONError(w, "Login challenge expired. Please log in again.", http.StatusUnauthorized)
+		return
+	}
+
+	if h.rejectThrottledLogin(w, r, userID, "") {
+		return
+	}
+
+	recoveryCodes, err := h.confirmTwoFactorSetup(w, userID, req.Code)
+	if err == errInvalidTwoFactorCode {
+		h.registerLoginFailure(userID)
+		h.recordLoginAttempt(r, userID, "", false, loginReasonInvalidCode)
+	}
+	if err != nil {
+		return
+	}
+
+	h.resetLoginFailures(userID)
+	h.recordLoginAttempt(r, userID, "", true, loginReasonSuccess)
+
+	session, err := h.issueSession(r, userID, userType)
+	if err != nil {
+		log.Printf("Error issuing session: %v", err)
+		sendJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	session["recovery_codes"] = recoveryCodes
+	sendJSONResponse(w, session, http.StatusOK)
+}
+
+// SetupTwoFactor starts voluntary enrollment for a logged-in user.
+func (h *Handlers) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	setup, err := h.beginTwoFactorSetup(getUserIDFromRequest(r))
+	if err != nil {
+		log.Printf("Error starting two-factor setup: %v", err)
+		sendJSONError(w, "Failed to start two-factor setup. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, setup, http.StatusOK)
+}
+
+// EnableTwoFactor confirms voluntary enrollment with a code from the app.
+func (h *Handlers) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	recoveryCodes, err := h.confirmTwoFactorSetup(w, getUserIDFromRequest(r), req.Code)
+	if err != nil {
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"two_factor_enabled": true,
+		"recovery_codes":     recoveryCodes,
+	}, http.StatusOK)
+}
+
+// DisableTwoFactor turns two-factor authentication off. It requires both the
+// password and a current code, and is refused while the organisation
+// requires two-factor authentication.
+func (h *Handlers) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	required, err := h.getBoolSetting(settingRequireTwoFactor)
+	if err != nil {
+		log.Printf("Error reading two-factor setting: %v", err)
+		sendJSONError(w, "Failed to disable two-factor authentication. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	if required {
+		sendJSONError(w, "Two-factor authentication is required by your organisation", http.StatusForbidden)
+		return
+	}
+
+	userID := getUserIDFromRequest(r)
+
+	var passwordHash string
+	if err := h.DB.QueryRow("SELECT password_hash FROM users WHERE id = $1", userID).Scan(&passwordHash); err != nil {
+		log.Printf("Error fetching user for two-factor disable: %v", err)
+		sendJSONError(w, "Failed to disable two-factor authentication. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+		sendJSONError(w, "Password is incorrect", http.StatusUnauthorized)
+		return
+	}
+
+	ok, err := h.verifyTOTP(userID, req.Code)
+	if err != nil {
+		log.Printf("Error verifying two-factor code: %v", err)
+		sendJSONError(w, "Failed to disable two-factor authentication. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		sendJSONError(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting two-factor disable transaction: %v", err)
+		sendJSONError(w, "Failed to disable two-factor authentication. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE users SET totp_enabled = false, totp_secret = NULL, totp_pending_secret = NULL, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = $1`,
+		userID,
+	)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error disabling two-factor authentication: %v", err)
+		sendJSONError(w, "Failed to disable two-factor authentication. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]bool{"two_factor_enabled": false}, http.StatusOK)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying a
+// current TOTP code.
+func (h *Handlers) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userID := getUserIDFromRequest(r)
+	ok, err := h.verifyTOTP(userID, req.Code)
+	if err != nil {
+		log.Printf("Error verifying two-factor code: %v", err)
+		sendJSONError(w, "Failed to regenerate recovery codes. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		sendJSONError(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting recovery code transaction: %v", err)
+		sendJSONError(w, "Failed to regenerate recovery codes. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	recoveryCodes, err := replaceRecoveryCodes(tx, userID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error regenerating recovery codes: %v", err)
+		sendJSONError(w, "Failed to regenerate recovery codes. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{"recovery_codes": recoveryCodes}, http.StatusOK)
+}
+
+// beginTwoFactorSetup stores a new pending secret for the user. The active
+// secret (if any) keeps working until the new one is confirmed.
+func (h *Handlers) beginTwoFactorSetup(userID int) (*twoFactorSetup, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var username string
+	err = h.DB.QueryRow(
+		"UPDATE users SET totp_pending_secret = $1 WHERE id = $2 RETURNING username",
+		secret, userID,
+	).Scan(&username)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "Khidmat"
+	}
+
+	return &twoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(issuer, username, secret),
+	}, nil
+}
+
+// confirmTwoFactorSetup activates the pending secret if code matches it and
+// issues fresh recovery codes. On failure it writes the error response and
+// returns the error, errInvalidTwoFactorCode for a wrong code.
+func (h *Handlers) confirmTwoFactorSetup(w http.ResponseWriter, userID int, code string) ([]string, error) {
+	recoveryCodes, err := h.activatePendingSecret(userID, code)
+
+	switch {
+	case err == errNoPendingTwoFactorSetup:
+		sendJSONError(w, "Two-factor setup has not been started", http.StatusBadRequest)
+	case err == errInvalidTwoFactorCode:
+		sendJSONError(w, "Invalid two-factor code", http.StatusBadRequest)
+	case err != nil:
+		log.Printf("Error enabling two-factor authentication: %v", err)
+		sendJSONError(w, "Failed to enable two-factor authentication. Please try again later.", http.StatusInternalServerError)
+	}
+
+	return recoveryCodes, err
+}
+
+func (h *Handlers) activatePendingSecret(userID int, code string) ([]string, error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var pendingSecret sql.NullString
+	err = tx.QueryRow("SELECT totp_pending_secret FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&pendingSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !pendingSecret.Valid {
+		return nil, errNoPendingTwoFactorSetup
+	}
+
+	step, ok := totp.Validate(pendingSecret.String, code, time.Now(), 0)
+	if !ok {
+		return nil, errInvalidTwoFactorCode
+	}
+
+	_, err = tx.Exec(
+		`UPDATE users SET totp_secret = totp_pending_secret, totp_pending_secret = NULL, totp_enabled = true,
+		 totp_last_step = $1, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = $2`,
+		step, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, tx.Commit()
+}
+
+// verifyTOTP checks a code against the user's active secret and records the
+// matched time step so the same code cannot be used twice.
+func (h *Handlers) verifyTOTP(userID int, code string) (bool, error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	err = tx.QueryRow(
+		"SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1 FOR UPDATE",
+		userID,
+	).Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		return false, err
+	}
+
+	if !enabled || !secret.Valid {
+		return false, nil
+	}
+
+	step, ok := totp.Validate(secret.String, code, time.Now(), lastStep)
+	if !ok {
+		return false, nil
+	}
+
+	if _, err := tx.Exec("UPDATE users SET totp_last_step = $1 WHERE id = $2", step, userID); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// useRecoveryCode consumes a single-use recovery code.
+func (h *Handlers) useRecoveryCode(userID int, code string) (bool, error) {
+	result, err := h.DB.Exec(
+		"UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, middleware.HashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores a new
+// set, returning the plaintext codes.
+func replaceRecoveryCodes(e execer, userID int) ([]string, error) {
+	if _, err := e.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+
+		_, err := e.Exec(
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, middleware.HashToken(normalizeRecoveryCode(code)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
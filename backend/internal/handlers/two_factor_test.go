@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/khidmat/backend/internal/middleware"
+)
+
+func TestConfirmTwoFactorEnrollmentWrongCode(t *testing.T) {
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("JWT_KEYS_FILE", "")
+	t.Setenv("JWT_SECRET", "a-test-secret-that-is-long-enough")
+	t.Setenv("JWT_SIGNING_KEY_ID", "")
+	if err := middleware.LoadSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+	challengeToken, err := middleware.GenerateChallengeToken(7, "admin", middleware.PurposeTwoFactorSetup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("FROM login_attempts").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("SELECT failed_login_count").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_count", "is_active", "locked_for", "since_failure"}).
+			AddRow(0, true, nil, nil))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT totp_pending_secret").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_pending_secret"}).AddRow("JBSWY3DPEHPK3PXP"))
+	mock.ExpectRollback()
+	mock.ExpectExec("failed_login_count = failed_login_count \\+ 1").
+		WithArgs(7, loginLockoutThreshold, int(loginLockoutDuration.Seconds())).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO login_attempts").
+		WithArgs(7, "", sqlmock.AnyArg(), sqlmock.AnyArg(), false, loginReasonInvalidCode).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// Six letters never match a TOTP code.
+	body := `{"challenge_token": "` + challengeToken + `", "code": "abcdef"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/2fa/enroll/confirm", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	(&Handlers{DB: db}).ConfirmTwoFactorEnrollment(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
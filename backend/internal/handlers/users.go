@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/models"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const userColumns = `id, username, email, user_type, totp_enabled, is_active, disabled_at, locked_until, created_at, updated_at`
+
+func scanUser(row interface{ Scan(...interface{}) error }, u *models.User) error {
+	return row.Scan(&u.ID, &u.Username, &u.Email, &u.UserType, &u.TOTPEnabled, &u.IsActive, &u.DisabledAt, &u.LockedUntil, &u.CreatedAt, &u.UpdatedAt)
+}
+
+// GetUsers lists every admin account, including disabled ones.
+func (h *Handlers) GetUsers(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query("SELECT " + userColumns + " FROM users ORDER BY username")
+	if err != nil {
+		log.Printf("Error fetching users: %v", err)
+		sendJSONError(w, "Failed to fetch users. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := scanUser(rows, &u); err != nil {
+			continue
+		}
+		users = append(users, u)
+	}
+
+	sendJSONResponse(w, users, http.StatusOK)
+}
+
+func (h *Handlers) GetUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var u models.User
+	err = scanUser(h.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", userID), &u)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching user: %v", err)
+		sendJSONError(w, "Failed to fetch user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, u, http.StatusOK)
+}
+
+// CreateUser creates an account directly, without an invitation. The caller
+// must hold every capability of the role being assigned.
+func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		UserType string `json:"user_type"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Username) == "" || strings.TrimSpace(req.Email) == "" {
+		sendJSONError(w, "Username and email are required", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeRoleAssignment(w, r, req.UserType) {
+		return
+	}
+
+	if err := validatePassword(req.Password); err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		sendJSONError(w, "Failed to create account. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := insertUser(h.DB, strings.TrimSpace(req.Username), req.Email, string(passwordHash), req.UserType)
+	if err != nil {
+		sendUserCreateError(w, err)
+		return
+	}
+
+	var u models.User
+	if err := scanUser(h.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", userID), &u); err != nil {
+		log.Printf("Error fetching created user: %v", err)
+		sendJSONResponse(w, map[string]interface{}{"id": userID}, http.StatusCreated)
+		return
+	}
+
+	sendJSONResponse(w, u, http.StatusCreated)
+}
+
+// UpdateUser changes a user's email and/or role. A role change revokes the
+// user's sessions so the new role applies from their next login.
+func (h *Handlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Email    *string `json:"email"`
+		UserType *string `json:"user_type"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Email != nil && strings.TrimSpace(*req.Email) == "" {
+		sendJSONError(w, "Email cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserType != nil && !h.authorizeRoleAssignment(w, r, *req.UserType) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting user update: %v", err)
+		sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var currentType string
+	err = tx.QueryRow("SELECT user_type FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&currentType)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching user for update: %v", err)
+		sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.authorizeUserManagement(w, r, currentType) {
+		return
+	}
+
+	roleChanged := req.UserType != nil && *req.UserType != currentType
+	if roleChanged && !h.guardLastMasterAdmin(w, tx, userID) {
+		return
+	}
+
+	if req.Email != nil {
+		_, err = tx.Exec(
+			"UPDATE users SET email = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+			strings.TrimSpace(*req.Email), userID,
+		)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				sendJSONError(w, "Email already exists. Please use a different email address.", http.StatusConflict)
+				return
+			}
+			log.Printf("Error updating user email: %v", err)
+			sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if roleChanged {
+		_, err = tx.Exec(
+			"UPDATE users SET user_type = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+			*req.UserType, userID,
+		)
+		if err == nil {
+			err = h.revokeAllSessions(tx, userID)
+		}
+		if err != nil {
+			log.Printf("Error updating user role: %v", err)
+			sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var u models.User
+	if err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", userID), &u); err != nil {
+		log.Printf("Error fetching updated user: %v", err)
+		sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing user update: %v", err)
+		sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, u, http.StatusOK)
+}
+
+// DisableUser blocks an account from logging in and revokes its sessions
+// immediately. Users are never deleted because members, payments and
+// donations keep referring to the admin who recorded them.
+func (h *Handlers) DisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserActive(w, r, false)
+}
+
+// EnableUser re-enables a disabled account.
+func (h *Handlers) EnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserActive(w, r, true)
+}
+
+func (h *Handlers) setUserActive(w http.ResponseWriter, r *http.Request, active bool) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting user status update: %v", err)
+		sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRow("SELECT user_type FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching user for status update: %v", err)
+		sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.authorizeUserManagement(w, r, role) {
+		return
+	}
+
+	if !active && !h.guardLastMasterAdmin(w, tx, userID) {
+		return
+	}
+
+	_, err = tx.Exec(
+		`UPDATE users SET is_active = $1,
+			disabled_at = CASE WHEN $1 THEN NULL ELSE COALESCE(disabled_at, CURRENT_TIMESTAMP) END,
+			updated_at = CURRENT_TIMESTAMP
+		 WHERE id = $2`,
+		active, userID,
+	)
+	if err != nil {
+		log.Printf("Error updating user status: %v", err)
+		sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if !active {
+		if err := h.revokeAllSessions(tx, userID); err != nil {
+			log.Printf("Error revoking sessions of disabled user: %v", err)
+			sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing user status update: %v", err)
+		sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"id":        userID,
+		"is_active": active,
+	}, http.StatusOK)
+}
+
+var errLastMasterAdmin = errors.New("last active master admin")
+
+// guardLastMasterAdmin must run inside the transaction that demotes or
+// disables userID. It locks every active master admin row so concurrent
+// changes cannot each remove a different one, and writes a 409 if userID is
+// the only one left.
+func (h *Handlers) guardLastMasterAdmin(w http.ResponseWriter, tx *sql.Tx, userID int) bool {
+	err := ensureAnotherMasterAdmin(tx, userID)
+	if err == errLastMasterAdmin {
+		sendJSONError(w, "Cannot remove the last active master admin", http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		log.Printf("Error checking master admins: %v", err)
+		sendJSONError(w, "Failed to update user. Please try again later.", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func ensureAnotherMasterAdmin(tx *sql.Tx, userID int) error {
+	rows, err := tx.Query(
+		"SELECT id FROM users WHERE user_type = $1 AND is_active = true ORDER BY id FOR UPDATE",
+		middleware.RoleMasterAdmin,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	isMaster, others := false, 0
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		if id == userID {
+			isMaster = true
+		} else {
+			others++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if isMaster && others == 0 {
+		return errLastMasterAdmin
+	}
+	return nil
+}
+
+// UnlockUser clears a login lockout and the failed attempt counter.
+func (h *Handlers) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var role string
+	err = h.DB.QueryRow("SELECT user_type FROM users WHERE id = $1", userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching user to unlock: %v", err)
+		sendJSONError(w, "Failed to unlock user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.authorizeUserManagement(w, r, role) {
+		return
+	}
+
+	_, err = h.DB.Exec(
+		`UPDATE users SET failed_login_count = 0, last_failed_login_at = NULL, locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = $1`,
+		userID,
+	)
+	if err != nil {
+		log.Printf("Error unlocking user: %v", err)
+		sendJSONError(w, "Failed to unlock user. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"id":       userID,
+		"unlocked": true,
+	}, http.StatusOK)
+}
+
+// GetLoginHistory lists recent login attempts for a user. Users who can
+// manage users can view anyone's history; others only their own.
+func (h *Handlers) GetLoginHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if !middleware.HasCapability(r, middleware.CapUsersManage) && getUserIDFromRequest(r) != userID {
+		sendJSONError(w, "You do not have permission to perform this action", http.StatusForbidden)
+		return
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	query := `
+		SELECT id, user_id, username, ip_address, COALESCE(user_agent, ''), success, reason, created_at
+		FROM login_attempts
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := h.DB.Query(query, userID, limit)
+	if err != nil {
+		log.Printf("Error fetching login history: %v", err)
+		sendJSONError(w, "Failed to fetch login history. Please try again later.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var a models.LoginAttempt
+		err := rows.Scan(&a.ID, &a.UserID, &a.Username, &a.IPAddress, &a.UserAgent, &a.Success, &a.Reason, &a.CreatedAt)
+		if err != nil {
+			continue
+		}
+		attempts = append(attempts, a)
+	}
+
+	sendJSONResponse(w, attempts, http.StatusOK)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
 )
 
@@ -11,12 +12,35 @@ func sendJSONError(w http.ResponseWriter, message string, statusCode int) {
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
+// fieldErrors maps request fields to what is wrong with them.
+type fieldErrors map[string]string
+
+// sendFieldErrors rejects a request with a 400 listing every invalid field
+// under "fields"; "error" summarises them.
+func sendFieldErrors(w http.ResponseWriter, fields fieldErrors) {
+	message := "Some fields are invalid"
+	if len(fields) == 1 {
+		for _, m := range fields {
+			message = m
+		}
+	}
+	sendJSONResponse(w, map[string]interface{}{
+		"error":  message,
+		"fields": fields,
+	}, http.StatusBadRequest)
+}
+
 func sendJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(data)
 }
 
-
-
-
+// clientIP returns the remote address of the request without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/khidmat/backend/internal/database"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix marks API keys so they can be told apart from JWTs in the
+// Authorization header.
+const APIKeyPrefix = "khk_"
+
+// apiKeyFromRequest returns the API key sent either as X-API-Key or as a
+// Bearer token carrying APIKeyPrefix, or "" if there is none.
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); strings.HasPrefix(bearer, APIKeyPrefix) {
+		return bearer
+	}
+	return ""
+}
+
+// serveAPIKey authenticates a request made with an API key. The key acts as
+// the user it was issued for, with only the capabilities that are both in the
+// key's scope and still granted by that user's role. Every request is logged
+// against the key.
+func serveAPIKey(next http.Handler, w http.ResponseWriter, r *http.Request, key string) {
+	if database.DB == nil {
+		writeUnauthorized(w, "Invalid API key")
+		return
+	}
+
+	var keyID, userID int
+	var role string
+	var scopes, allowedIPs []string
+	var revoked, expired, active bool
+	err := database.DB.QueryRow(`
+		SELECT k.id, k.user_id, u.user_type, k.capabilities, k.allowed_ips,
+			k.revoked_at IS NOT NULL, k.expires_at IS NOT NULL AND k.expires_at < CURRENT_TIMESTAMP, u.is_active
+		FROM api_keys k
+		INNER JOIN users u ON k.user_id = u.id
+		WHERE k.key_hash = $1
+	`, HashToken(key)).Scan(&keyID, &userID, &role, pq.Array(&scopes), pq.Array(&allowedIPs), &revoked, &expired, &active)
+
+	if err == sql.ErrNoRows {
+		writeUnauthorized(w, "Invalid API key")
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching API key: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Failed to validate API key"}`))
+		return
+	}
+
+	ip := remoteIP(r)
+	if revoked || expired || !active {
+		recordAPIKeyUsage(keyID, r, ip, http.StatusUnauthorized)
+		writeUnauthorized(w, "API key has been revoked or has expired")
+		return
+	}
+
+	if len(allowedIPs) > 0 && !ipAllowed(ip, allowedIPs) {
+		recordAPIKeyUsage(keyID, r, ip, http.StatusForbidden)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"API key is not allowed from this address"}`))
+		return
+	}
+
+	roleCapabilities, err := CapabilitiesForRole(database.DB, role)
+	if err != nil {
+		log.Printf("Error loading role capabilities: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Failed to validate API key"}`))
+		return
+	}
+
+	granted := make(map[string]bool, len(roleCapabilities))
+	for _, c := range roleCapabilities {
+		granted[c] = true
+	}
+	var capabilities []string
+	for _, c := range scopes {
+		if granted[c] {
+			capabilities = append(capabilities, c)
+		}
+	}
+
+	r.Header.Del("X-Member-ID")
+	r.Header.Del("X-Token-ID")
+	r.Header.Set("X-User-ID", strconv.Itoa(userID))
+	r.Header.Set("X-User-Type", role)
+	r.Header.Set("X-Capabilities", strings.Join(capabilities, " "))
+	r.Header.Set("X-API-Key-ID", strconv.Itoa(keyID))
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(recorder, r)
+	recordAPIKeyUsage(keyID, r, ip, recorder.status)
+}
+
+func recordAPIKeyUsage(keyID int, r *http.Request, ip string, status int) {
+	_, err := database.DB.Exec(`
+		WITH usage AS (
+			INSERT INTO api_key_usage (api_key_id, method, path, status_code, ip_address)
+			VALUES ($1, $2, $3, $4, $5)
+		)
+		UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = $5 WHERE id = $1
+	`, keyID, r.Method, r.URL.Path, status, ip)
+	if err != nil {
+		log.Printf("Error recording API key usage: %v", err)
+	}
+}
+
+// ipAllowed reports whether ip matches one of the allowlist entries, each an
+// IP address or a CIDR range.
+func ipAllowed(ip string, allowed []string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"error":"` + message + `"}`))
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
@@ -1,14 +1,36 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/khidmat/backend/internal/database"
 )
 
-var jwtSecret = []byte("your-secret-key-change-in-production")
+// Lifetimes of issued credentials. Access tokens are short-lived JWTs; refresh
+// tokens are opaque values stored (hashed) in the refresh_tokens table.
+var (
+	AccessTokenTTL    = 15 * time.Minute
+	RefreshTokenTTL   = 30 * 24 * time.Hour
+	ChallengeTokenTTL = 5 * time.Minute
+	MemberTokenTTL    = 60 * time.Minute
+)
+
+// Purposes of challenge tokens issued between the password check and the
+// second login step. Challenge tokens are never accepted by AuthMiddleware.
+const (
+	PurposeTwoFactor      = "2fa"
+	PurposeTwoFactorSetup = "2fa_setup"
+)
 
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -17,6 +39,15 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Never let a client-sent header stand in for the authenticated
+		// API key.
+		r.Header.Del("X-API-Key-ID")
+
+		if key := apiKeyFromRequest(r); key != "" {
+			serveAPIKey(next, w, r, key)
+			return
+		}
+
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			w.Header().Set("Content-Type", "application/json")
@@ -33,9 +64,7 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+		token, err := parseToken(tokenString)
 
 		if err != nil || !token.Valid {
 			w.Header().Set("Content-Type", "application/json")
@@ -52,21 +81,126 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		userID := int(claims["user_id"].(float64))
-		userType := claims["user_type"].(string)
+		if _, isChallenge := claims["purpose"]; isChallenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Invalid token"}`))
+			return
+		}
+
+		userType, typeOK := claims["user_type"].(string)
+		tokenID, idOK := claims["jti"].(string)
+		principalClaim := "user_id"
+		if userType == RoleMember {
+			principalClaim = "member_id"
+		}
+		principalID, ok := claims[principalClaim].(float64)
+		if !ok || !typeOK || !idOK {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Invalid token claims"}`))
+			return
+		}
+
+		var revoked bool
+		if userType == RoleMember {
+			revoked, err = isMemberTokenRevoked(int(principalID), tokenID)
+		} else {
+			revoked, err = isTokenRevoked(claims, int(principalID), tokenID)
+		}
+		if err != nil {
+			log.Printf("Error checking token revocation: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":"Failed to validate token"}`))
+			return
+		}
+		if revoked {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Token has been revoked"}`))
+			return
+		}
+
+		// Members and admins are separate principals; never let a client-sent
+		// header stand in for the one that does not apply.
+		r.Header.Del("X-User-ID")
+		r.Header.Del("X-Member-ID")
+		r.Header.Del("X-Capabilities")
+		if userType == RoleMember {
+			r.Header.Set("X-Member-ID", strconv.Itoa(int(principalID)))
+		} else {
+			r.Header.Set("X-User-ID", strconv.Itoa(int(principalID)))
 
-		r.Header.Set("X-User-ID", strconv.Itoa(userID))
+			// Capabilities are looked up on every request so edits to a
+			// role take effect without a new login.
+			if database.DB != nil {
+				capabilities, err := CapabilitiesForRole(database.DB, userType)
+				if err != nil {
+					log.Printf("Error loading role capabilities: %v", err)
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write([]byte(`{"error":"Failed to validate token"}`))
+					return
+				}
+				r.Header.Set("X-Capabilities", strings.Join(capabilities, " "))
+			}
+		}
 		r.Header.Set("X-User-Type", userType)
+		r.Header.Set("X-Token-ID", tokenID)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// isTokenRevoked reports whether the token was explicitly revoked (logout),
+// was issued before the user's last "log out all devices", or belongs to a
+// user that has since been disabled.
+func isTokenRevoked(claims jwt.MapClaims, userID int, tokenID string) (bool, error) {
+	if database.DB == nil {
+		return false, nil
+	}
+
+	// iat is read directly rather than via GetIssuedAt, which truncates to
+	// whole seconds; sub-second precision keeps a token issued right after
+	// "log out all devices" valid.
+	issuedAt, ok := claims["iat"].(float64)
+	if !ok {
+		return true, nil
+	}
+
+	var revoked bool
+	err := database.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR EXISTS (
+				SELECT 1 FROM users
+				WHERE id = $2 AND tokens_valid_after IS NOT NULL AND tokens_valid_after > to_timestamp($3)::timestamp
+			)
+			OR NOT EXISTS (SELECT 1 FROM users WHERE id = $2 AND is_active = true)
+	`, tokenID, userID, issuedAt).Scan(&revoked)
+	return revoked, err
+}
+
+// isMemberTokenRevoked reports whether a member session was logged out or the
+// member is no longer active.
+func isMemberTokenRevoked(memberID int, tokenID string) (bool, error) {
+	if database.DB == nil {
+		return false, nil
+	}
+
+	var revoked bool
+	err := database.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR NOT EXISTS (SELECT 1 FROM members WHERE id = $2 AND is_active = true)
+	`, tokenID, memberID).Scan(&revoked)
+	return revoked, err
+}
+
 func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -77,12 +211,103 @@ func CORS(next http.Handler) http.Handler {
 	})
 }
 
+// GenerateToken issues a short-lived access token. Every token carries a
+// unique jti so it can be revoked individually on logout.
 func GenerateToken(userID int, userType string) (string, error) {
+	tokenID, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"user_id":   userID,
 		"user_type": userType,
+		"jti":       tokenID,
+		"iat":       float64(now.UnixMicro()) / 1e6,
+		"exp":       now.Add(AccessTokenTTL).Unix(),
+	}
+
+	return signToken(claims)
+}
+
+// GenerateMemberToken issues an access token for the member self-service
+// portal. Member tokens carry member_id instead of user_id.
+func GenerateMemberToken(memberID int) (string, error) {
+	tokenID, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"member_id": memberID,
+		"user_type": RoleMember,
+		"jti":       tokenID,
+		"iat":       float64(now.UnixMicro()) / 1e6,
+		"exp":       now.Add(MemberTokenTTL).Unix(),
+	}
+
+	return signToken(claims)
+}
+
+// GenerateChallengeToken issues a short-lived token proving that the user
+// passed the password check. It can only be redeemed by the endpoint that
+// handles the given purpose.
+func GenerateChallengeToken(userID int, userType, purpose string) (string, error) {
+	tokenID, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"user_id":   userID,
+		"user_type": userType,
+		"purpose":   purpose,
+		"jti":       tokenID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(ChallengeTokenTTL).Unix(),
+	}
+
+	return signToken(claims)
+}
+
+// ParseChallengeToken validates a challenge token for the given purpose and
+// returns the user it was issued to.
+func ParseChallengeToken(tokenString, purpose string) (int, string, error) {
+	token, err := parseToken(tokenString)
+	if err != nil || !token.Valid {
+		return 0, "", errors.New("invalid challenge token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != purpose {
+		return 0, "", errors.New("invalid challenge token")
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	userType, typeOK := claims["user_type"].(string)
+	if !ok || !typeOK {
+		return 0, "", errors.New("invalid challenge token")
+	}
+
+	return int(userID), userType, nil
+}
+
+// GenerateOpaqueToken returns a random URL-safe token suitable for refresh
+// tokens and other single-use secrets.
+func GenerateOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns the hex SHA-256 of an opaque token. Only hashes are
+// persisted so a database leak does not expose usable tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// RoleMasterAdmin is the built-in superuser role. It implicitly holds
+	// every capability and its definition cannot be edited.
+	RoleMasterAdmin  = "master_admin"
+	RoleAccountAdmin = "account_admin"
+
+	// RoleMember is the self-service principal of a row in members, not users.
+	RoleMember = "member"
+)
+
+// Capabilities that roles are made of. Routes and handlers check these rather
+// than role names, so new roles can be defined without code changes.
+const (
+	CapMembersRead      = "members.read"
+	CapMembersWrite     = "members.write"
+	CapPaymentsRead     = "payments.read"
+	CapPaymentsWrite    = "payments.write"
+	CapDonationsRead    = "donations.read"
+	CapDonationsWrite   = "donations.write"
+	CapReportsRead      = "reports.read"
+	CapReportsFinancial = "reports.financial"
+	CapRecordsAll       = "records.all"
+	CapUsersManage      = "users.manage"
+	CapRolesManage      = "roles.manage"
+	CapSettingsManage   = "settings.manage"
+	CapAPIKeysManage    = "api_keys.manage"
+)
+
+// Capabilities describes every capability a role may be granted.
+var Capabilities = map[string]string{
+	CapMembersRead:      "View members",
+	CapMembersWrite:     "Create and update members",
+	CapPaymentsRead:     "View payments",
+	CapPaymentsWrite:    "Record payments",
+	CapDonationsRead:    "View donations",
+	CapDonationsWrite:   "Record donations",
+	CapReportsRead:      "View collection and donation reports",
+	CapReportsFinancial: "View organisation-wide financial reports such as the pool balance",
+	CapRecordsAll:       "Access records owned by every admin, not only one's own",
+	CapUsersManage:      "Manage admin accounts and invitations",
+	CapRolesManage:      "Define roles and their capabilities",
+	CapSettingsManage:   "Change organisation settings",
+	CapAPIKeysManage:    "Issue and revoke API keys for integrations",
+}
+
+// AllCapabilities returns every known capability in a stable order.
+func AllCapabilities() []string {
+	caps := make([]string, 0, len(Capabilities))
+	for c := range Capabilities {
+		caps = append(caps, c)
+	}
+	sort.Strings(caps)
+	return caps
+}
+
+// CapabilitiesForRole returns the capabilities granted to a role.
+func CapabilitiesForRole(db *sql.DB, role string) ([]string, error) {
+	if role == RoleMasterAdmin {
+		return AllCapabilities(), nil
+	}
+
+	rows, err := db.Query("SELECT capability FROM role_capabilities WHERE role_name = $1 ORDER BY capability", role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var caps []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		caps = append(caps, c)
+	}
+	return caps, rows.Err()
+}
+
+// HasCapability reports whether the authenticated caller's role grants cap.
+// It must run behind AuthMiddleware.
+func HasCapability(r *http.Request, capability string) bool {
+	for _, c := range strings.Fields(r.Header.Get("X-Capabilities")) {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireUser only lets the request through for an authenticated admin user,
+// whatever their role. It must run behind AuthMiddleware.
+func RequireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" || (r.Header.Get("X-User-ID") != "" && r.Header.Get("X-User-Type") != RoleMember) {
+			next(w, r)
+			return
+		}
+		writeForbidden(w)
+	}
+}
+
+// RequireLogin is RequireUser for routes that only make sense for a person
+// who logged in, such as sessions, passwords and two-factor settings. API
+// keys are refused.
+func RequireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "OPTIONS" && r.Header.Get("X-API-Key-ID") != "" {
+			writeForbidden(w)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RequireCapability only lets the request through when the caller holds every
+// one of capabilities. It must run behind AuthMiddleware.
+func RequireCapability(next http.HandlerFunc, capabilities ...string) http.HandlerFunc {
+	return RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "OPTIONS" {
+			for _, c := range capabilities {
+				if !HasCapability(r, c) {
+					writeForbidden(w)
+					return
+				}
+			}
+		}
+		next(w, r)
+	})
+}
+
+// RequireRole only lets the request through when the authenticated caller's
+// user type is one of roles. Admin routes use RequireCapability instead; this
+// remains for principals that are not configurable roles, such as members.
+func RequireRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			next(w, r)
+			return
+		}
+
+		userType := r.Header.Get("X-User-Type")
+		for _, role := range roles {
+			if userType == role {
+				next(w, r)
+				return
+			}
+		}
+
+		writeForbidden(w)
+	}
+}
+
+func writeForbidden(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(`{"error":"You do not have permission to perform this action"}`))
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serve(handler http.HandlerFunc, method string, headers map[string]string) int {
+	r := httptest.NewRequest(method, "/api/test", nil)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w.Code
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestRequireRole(t *testing.T) {
+	handler := RequireRole(okHandler, RoleMember)
+
+	tests := []struct {
+		name     string
+		method   string
+		userType string
+		want     int
+	}{
+		{"allowed role", http.MethodGet, RoleMember, http.StatusOK},
+		{"other role", http.MethodGet, RoleMasterAdmin, http.StatusForbidden},
+		{"no role", http.MethodGet, "", http.StatusForbidden},
+		{"preflight", http.MethodOptions, "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if got := serve(handler, tt.method, map[string]string{"X-User-Type": tt.userType}); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequireCapability(t *testing.T) {
+	handler := RequireCapability(okHandler, CapMembersRead, CapPaymentsRead)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"every capability", http.MethodGet, map[string]string{"X-User-ID": "2", "X-User-Type": "treasurer", "X-Capabilities": "members.read payments.read reports.read"}, http.StatusOK},
+		{"one capability missing", http.MethodGet, map[string]string{"X-User-ID": "2", "X-User-Type": "treasurer", "X-Capabilities": "members.read"}, http.StatusForbidden},
+		{"capability name as a prefix", http.MethodGet, map[string]string{"X-User-ID": "2", "X-User-Type": "treasurer", "X-Capabilities": "members.reader payments.read"}, http.StatusForbidden},
+		{"member", http.MethodGet, map[string]string{"X-User-ID": "2", "X-User-Type": RoleMember, "X-Capabilities": "members.read payments.read"}, http.StatusForbidden},
+		{"unauthenticated", http.MethodGet, nil, http.StatusForbidden},
+		{"preflight", http.MethodOptions, nil, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if got := serve(handler, tt.method, tt.headers); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
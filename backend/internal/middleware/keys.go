@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultJWTSecret is the secret the project shipped with. It is only
+// accepted in development mode.
+const defaultJWTSecret = "your-secret-key-change-in-production"
+
+// minHMACSecretLength is the shortest HS256 secret accepted outside
+// development mode.
+const minHMACSecretLength = 32
+
+// jwtKey is one entry of the key set. Keys without private material can
+// still verify tokens, which is how a key is retired during rotation.
+type jwtKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// jwtKeySet holds every key tokens may be verified with, indexed by the kid
+// header, and the key new tokens are signed with.
+type jwtKeySet struct {
+	keys    map[string]*jwtKey
+	methods []string
+	signing *jwtKey
+}
+
+var signingKeys *jwtKeySet
+
+// jwtKeyConfig is one entry of the JWT_KEYS_FILE JSON array.
+type jwtKeyConfig struct {
+	ID             string `json:"kid"`
+	Alg            string `json:"alg"`
+	Secret         string `json:"secret"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+// IsDevelopment reports whether APP_ENV selects development mode. Anything
+// else, including an unset APP_ENV, counts as production so a deploy that
+// forgets to set it cannot fall back to the default JWT secret.
+func IsDevelopment() bool {
+	env := strings.ToLower(os.Getenv("APP_ENV"))
+	return env == "development" || env == "dev"
+}
+
+// LoadSigningKeys configures the keys used to sign and verify JWTs. Keys come
+// from JWT_KEYS_FILE (several keys, for rotation or asymmetric signing) or
+// JWT_SECRET (a single HS256 key). JWT_SIGNING_KEY_ID picks the key new
+// tokens are signed with. It must be called once at startup.
+func LoadSigningKeys() error {
+	var configs []jwtKeyConfig
+
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read JWT_KEYS_FILE: %w", err)
+		}
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return fmt.Errorf("failed to parse JWT_KEYS_FILE: %w", err)
+		}
+		if len(configs) == 0 {
+			return errors.New("JWT_KEYS_FILE contains no keys")
+		}
+	} else {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			secret = defaultJWTSecret
+		}
+		configs = []jwtKeyConfig{{ID: "primary", Alg: "HS256", Secret: secret}}
+	}
+
+	set := &jwtKeySet{keys: make(map[string]*jwtKey)}
+	seenMethods := make(map[string]bool)
+	for _, cfg := range configs {
+		key, err := buildJWTKey(cfg)
+		if err != nil {
+			return fmt.Errorf("JWT key %q: %w", cfg.ID, err)
+		}
+		if _, exists := set.keys[key.ID]; exists {
+			return fmt.Errorf("duplicate JWT key id %q", key.ID)
+		}
+		set.keys[key.ID] = key
+		if !seenMethods[key.Method.Alg()] {
+			seenMethods[key.Method.Alg()] = true
+			set.methods = append(set.methods, key.Method.Alg())
+		}
+	}
+
+	if kid := os.Getenv("JWT_SIGNING_KEY_ID"); kid != "" {
+		set.signing = set.keys[kid]
+		if set.signing == nil {
+			return fmt.Errorf("JWT_SIGNING_KEY_ID %q is not a configured key", kid)
+		}
+	} else {
+		// Default to the first key in configuration order that can sign.
+		for _, cfg := range configs {
+			if set.keys[cfg.ID].signKey != nil {
+				set.signing = set.keys[cfg.ID]
+				break
+			}
+		}
+	}
+	if set.signing == nil || set.signing.signKey == nil {
+		return errors.New("no JWT key with private material is available for signing")
+	}
+
+	signingKeys = set
+	return nil
+}
+
+func buildJWTKey(cfg jwtKeyConfig) (*jwtKey, error) {
+	if cfg.ID == "" {
+		return nil, errors.New("kid is required")
+	}
+
+	key := &jwtKey{ID: cfg.ID}
+
+	switch cfg.Alg {
+	case "HS256":
+		if cfg.Secret == "" {
+			return nil, errors.New("secret is required for HS256")
+		}
+		if !IsDevelopment() {
+			if cfg.Secret == defaultJWTSecret {
+				return nil, errors.New("the default JWT secret must not be used outside development; set JWT_SECRET or JWT_KEYS_FILE")
+			}
+			if len(cfg.Secret) < minHMACSecretLength {
+				return nil, fmt.Errorf("HS256 secrets must be at least %d characters outside development", minHMACSecretLength)
+			}
+		} else if cfg.Secret == defaultJWTSecret {
+			log.Println("WARNING: using the default JWT secret; set JWT_SECRET before deploying")
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.signKey = []byte(cfg.Secret)
+		key.verifyKey = key.signKey
+
+	case "RS256":
+		key.Method = jwt.SigningMethodRS256
+		if cfg.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		}
+		if cfg.PublicKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+
+	case "EdDSA":
+		key.Method = jwt.SigningMethodEdDSA
+		if cfg.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = private
+			key.verifyKey = private.(crypto.Signer).Public().(ed25519.PublicKey)
+		}
+		if cfg.PublicKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q (use HS256, RS256 or EdDSA)", cfg.Alg)
+	}
+
+	if key.verifyKey == nil {
+		return nil, errors.New("private_key_file or public_key_file is required")
+	}
+
+	return key, nil
+}
+
+// signToken signs claims with the active key and records its kid in the
+// token header.
+func signToken(claims jwt.MapClaims) (string, error) {
+	if signingKeys == nil {
+		return "", errors.New("JWT signing keys are not configured")
+	}
+
+	key := signingKeys.signing
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// parseToken verifies a token against the key named by its kid header. The
+// token's alg must match the key's, so a public key can never be used as an
+// HMAC secret.
+func parseToken(tokenString string) (*jwt.Token, error) {
+	if signingKeys == nil {
+		return nil, errors.New("JWT signing keys are not configured")
+	}
+
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := signingKeys.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), kid)
+		}
+		return key.verifyKey, nil
+	}, jwt.WithValidMethods(signingKeys.methods), jwt.WithExpirationRequired())
+}
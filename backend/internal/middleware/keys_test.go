@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecretA = "a-test-secret-that-is-long-enough-1"
+const testSecretB = "b-test-secret-that-is-long-enough-2"
+
+// writeKeysFile points JWT_KEYS_FILE at a file holding configs.
+func writeKeysFile(t *testing.T, configs []jwtKeyConfig) {
+	t.Helper()
+	data, err := json.Marshal(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_KEYS_FILE", path)
+}
+
+// signWith signs a token that has not expired with method and key, naming
+// kid in its header.
+func signWith(t *testing.T, method jwt.SigningMethod, key interface{}, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"user_id": 1,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestLoadSigningKeysSecretLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		secret  string
+		wantErr string
+	}{
+		{"short secret in development", "development", "short", ""},
+		{"default secret in development", "dev", "", ""},
+		{"short secret without APP_ENV", "", "short", "at least 32 characters"},
+		{"default secret without APP_ENV", "", "", "default JWT secret"},
+		{"short secret in production", "production", "short", "at least 32 characters"},
+		{"default secret in production", "production", "", "default JWT secret"},
+		{"long secret in production", "production", testSecretA, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APP_ENV", tt.env)
+			t.Setenv("JWT_KEYS_FILE", "")
+			t.Setenv("JWT_SIGNING_KEY_ID", "")
+			t.Setenv("JWT_SECRET", tt.secret)
+
+			err := LoadSigningKeys()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("LoadSigningKeys: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("LoadSigningKeys error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTokenKeyID(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("JWT_SIGNING_KEY_ID", "new")
+	writeKeysFile(t, []jwtKeyConfig{
+		{ID: "old", Alg: "HS256", Secret: testSecretA},
+		{ID: "new", Alg: "HS256", Secret: testSecretB},
+	})
+	if err := LoadSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := signToken(jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		wantOK bool
+	}{
+		{"signed with the active key", signed, true},
+		{"signed with a retired key", signWith(t, jwt.SigningMethodHS256, []byte(testSecretA), "old"), true},
+		{"kid names another key", signWith(t, jwt.SigningMethodHS256, []byte(testSecretA), "new"), false},
+		{"unknown kid", signWith(t, jwt.SigningMethodHS256, []byte(testSecretA), "missing"), false},
+		{"no kid", signWith(t, jwt.SigningMethodHS256, []byte(testSecretA), ""), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseToken(tt.token)
+			if (err == nil) != tt.wantOK {
+				t.Errorf("parseToken error = %v, want ok %v", err, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseTokenAlgorithm(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "ed25519.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("JWT_SIGNING_KEY_ID", "ed")
+	writeKeysFile(t, []jwtKeyConfig{
+		{ID: "ed", Alg: "EdDSA", PrivateKeyFile: keyFile},
+		{ID: "hmac", Alg: "HS256", Secret: testSecretA},
+	})
+	if err := LoadSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		wantOK bool
+	}{
+		{"EdDSA for the EdDSA key", signWith(t, jwt.SigningMethodEdDSA, private, "ed"), true},
+		{"HS256 for the HS256 key", signWith(t, jwt.SigningMethodHS256, []byte(testSecretA), "hmac"), true},
+		{"HS256 keyed with the public key", signWith(t, jwt.SigningMethodHS256, publicPEM, "ed"), false},
+		{"EdDSA for the HS256 key", signWith(t, jwt.SigningMethodEdDSA, private, "hmac"), false},
+		{"algorithm not configured", signWith(t, jwt.SigningMethodHS384, []byte(testSecretA), "hmac"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseToken(tt.token)
+			if (err == nil) != tt.wantOK {
+				t.Errorf("parseToken error = %v, want ok %v", err, tt.wantOK)
+			}
+		})
+	}
+}
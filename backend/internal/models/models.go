@@ -3,37 +3,228 @@ package models
 import "time"
 
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	UserType     string    `json:"user_type"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           int        `json:"id"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email"`
+	PasswordHash string     `json:"-"`
+	UserType     string     `json:"user_type"`
+	TOTPEnabled  bool       `json:"two_factor_enabled"`
+	IsActive     bool       `json:"is_active"`
+	DisabledAt   *time.Time `json:"disabled_at,omitempty"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	UserID    *int      `json:"user_id,omitempty"`
+	Username  string    `json:"username"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type Member struct {
+	ID           int               `json:"id"`
+	Name         string            `json:"name"`
+	MobileNo     string            `json:"mobile_no"`
+	Address      string            `json:"address"`
+	AdminID      int               `json:"admin_id"`
+	AdminName    string            `json:"admin_name,omitempty"`
+	HouseholdID  *int              `json:"household_id"`
+	Status       string            `json:"status"`
+	IsActive     bool              `json:"is_active"`
+	CustomFields map[string]string `json:"custom_fields"`
+	Tags         []string          `json:"tags"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// MemberField is an organisation-defined member attribute. Options lists
+// the allowed values of an enum field.
+type MemberField struct {
 	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	MobileNo  string    `json:"mobile_no"`
-	Address   string    `json:"address"`
-	AdminID   int       `json:"admin_id"`
-	AdminName string    `json:"admin_name,omitempty"`
-	IsActive  bool      `json:"is_active"`
+	Key       string    `json:"key"`
+	Label     string    `json:"label"`
+	FieldType string    `json:"field_type"`
+	Options   []string  `json:"options"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// MemberTransfer records a member moving from one account admin to another.
+type MemberTransfer struct {
+	ID                int       `json:"id"`
+	MemberID          int       `json:"member_id"`
+	FromAdminID       int       `json:"from_admin_id"`
+	FromAdminName     string    `json:"from_admin_name"`
+	ToAdminID         int       `json:"to_admin_id"`
+	ToAdminName       string    `json:"to_admin_name"`
+	EffectiveDate     time.Time `json:"effective_date"`
+	Reason            string    `json:"reason"`
+	TransferredBy     int       `json:"transferred_by"`
+	TransferredByName string    `json:"transferred_by_name"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// MemberStatusChange is one entry in a member's lifecycle history.
+type MemberStatusChange struct {
+	ID            int       `json:"id"`
+	MemberID      int       `json:"member_id"`
+	Status        string    `json:"status"`
+	EffectiveDate time.Time `json:"effective_date"`
+	Reason        string    `json:"reason"`
+	ChangedBy     int       `json:"changed_by,omitempty"`
+	ChangedByName string    `json:"changed_by_name,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ContributionPlan is an amount members are expected to pay every month,
+// quarter or year between StartDate and EndDate.
+type ContributionPlan struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Amount      float64    `json:"amount"`
+	Frequency   string     `json:"frequency"`
+	StartDate   time.Time  `json:"start_date"`
+	EndDate     *time.Time `json:"end_date"`
+	IsDefault   bool       `json:"is_default"`
+	MemberCount int        `json:"member_count"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// MemberPlan assigns a contribution plan to a member for a period.
+type MemberPlan struct {
+	ID         int        `json:"id"`
+	MemberID   int        `json:"member_id"`
+	PlanID     int        `json:"plan_id"`
+	PlanName   string     `json:"plan_name"`
+	Amount     float64    `json:"amount"`
+	Frequency  string     `json:"frequency"`
+	StartDate  time.Time  `json:"start_date"`
+	EndDate    *time.Time `json:"end_date"`
+	AssignedBy int        `json:"assigned_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Payment is money received for one member. PayerMemberID is the member
+// who paid it, usually the member themselves or their household's payer;
+// the payments of one split share a SplitGroupID. PaymentMode is cash, upi,
+// bank_transfer or cheque; ChequeStatus is set for cheques only.
 type Payment struct {
-	ID          int       `json:"id"`
-	MemberID    int       `json:"member_id"`
-	MemberName  string    `json:"member_name"`
-	ContactNo   string    `json:"contact_no"`
-	Amount      float64   `json:"amount"`
-	AdminID     int       `json:"admin_id"`
-	AdminName   string    `json:"admin_name,omitempty"`
-	PaymentDate time.Time `json:"payment_date"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID            int                 `json:"id"`
+	MemberID      int                 `json:"member_id"`
+	MemberName    string              `json:"member_name"`
+	ContactNo     string              `json:"contact_no"`
+	Amount        float64             `json:"amount"`
+	PayerMemberID int                 `json:"payer_member_id"`
+	PayerName     string              `json:"payer_name"`
+	SplitGroupID  *int                `json:"split_group_id,omitempty"`
+	PaymentMode   string              `json:"payment_mode"`
+	ReferenceNo   string              `json:"reference_no,omitempty"`
+	ChequeStatus  string              `json:"cheque_status,omitempty"`
+	AdminID       int                 `json:"admin_id"`
+	AdminName     string              `json:"admin_name,omitempty"`
+	PaymentDate   time.Time           `json:"payment_date"`
+	Allocations   []PaymentAllocation `json:"allocations"`
+	CreatedAt     time.Time           `json:"created_at"`
+}
+
+// PaymentAllocation is the part of a payment counted towards one
+// contribution month (YYYY-MM).
+type PaymentAllocation struct {
+	Period string  `json:"period"`
+	Amount float64 `json:"amount"`
+}
+
+// Household groups members, one of whom pays for the others.
+type Household struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	PayerMemberID int       `json:"payer_member_id"`
+	PayerName     string    `json:"payer_name"`
+	Members       []Member  `json:"members"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type PaymentReceipt struct {
+	ReceiptNumber  string          `json:"receipt_number"`
+	PaymentID      int             `json:"payment_id"`
+	MemberID       int             `json:"member_id"`
+	MemberName     string          `json:"member_name"`
+	ContactNo      string          `json:"contact_no"`
+	Amount         float64         `json:"amount"`
+	PayerMemberID  int             `json:"payer_member_id"`
+	PayerName      string          `json:"payer_name"`
+	PaymentMode    string          `json:"payment_mode"`
+	ReferenceNo    string          `json:"reference_no,omitempty"`
+	ChequeStatus   string          `json:"cheque_status,omitempty"`
+	CoveredMembers []CoveredMember `json:"covered_members"`
+	TotalAmount    float64         `json:"total_amount"`
+	ReceivedBy     string          `json:"received_by"`
+	PaymentDate    time.Time       `json:"payment_date"`
+}
+
+// CoveredMember is a member's share of a payment made for several members.
+type CoveredMember struct {
+	PaymentID   int                 `json:"payment_id"`
+	MemberID    int                 `json:"member_id"`
+	MemberName  string              `json:"member_name"`
+	Amount      float64             `json:"amount"`
+	Allocations []PaymentAllocation `json:"allocations"`
+}
+
+type MemberDues struct {
+	MemberID     int               `json:"member_id"`
+	Plan         *ContributionPlan `json:"plan"`
+	UnpaidMonths []string          `json:"unpaid_months"`
+	Months       []DuesMonth       `json:"months"`
+	TotalDue     float64           `json:"total_due"`
+}
+
+// DuesMonth compares what a member was expected to pay in a month with what
+// they paid.
+type DuesMonth struct {
+	Month    string  `json:"month"`
+	Expected float64 `json:"expected"`
+	Received float64 `json:"received"`
+	Due      float64 `json:"due"`
+}
+
+// MemberStatement is a member's ledger for the months From to To: dues
+// generated by their contribution plan and the payments received. Balances
+// are what the member owes; a negative balance is advance credit.
+type MemberStatement struct {
+	MemberID       int              `json:"member_id"`
+	MemberName     string           `json:"member_name"`
+	MobileNo       string           `json:"mobile_no"`
+	From           string           `json:"from"`
+	To             string           `json:"to"`
+	OpeningBalance float64          `json:"opening_balance"`
+	Entries        []StatementEntry `json:"entries"`
+	TotalDue       float64          `json:"total_due"`
+	TotalReceived  float64          `json:"total_received"`
+	ClosingBalance float64          `json:"closing_balance"`
+	Arrears        float64          `json:"arrears"`
+	AdvanceCredit  float64          `json:"advance_credit"`
+}
+
+// StatementEntry is one line of a member's ledger: a due (Debit) or a
+// payment (Credit), with the balance after it.
+type StatementEntry struct {
+	Date        string  `json:"date"`
+	Type        string  `json:"type"`
+	Description string  `json:"description"`
+	PaymentID   int     `json:"payment_id,omitempty"`
+	PaidBy      string  `json:"paid_by,omitempty"`
+	Debit       float64 `json:"debit"`
+	Credit      float64 `json:"credit"`
+	Balance     float64 `json:"balance"`
 }
 
 type Donation struct {
@@ -53,10 +244,60 @@ type LoginRequest struct {
 }
 
 type SignupRequest struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	UserType string `json:"user_type"`
+	Username       string `json:"username"`
+	Email          string `json:"email"`
+	Password       string `json:"password"`
+	InvitationCode string `json:"invitation_code"`
+}
+
+type Role struct {
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	IsSystem     bool      `json:"is_system"`
+	Capabilities []string  `json:"capabilities"`
+	UserCount    int       `json:"user_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type APIKey struct {
+	ID           int        `json:"id"`
+	Name         string     `json:"name"`
+	Key          string     `json:"key,omitempty"`
+	KeyPrefix    string     `json:"key_prefix"`
+	UserID       int        `json:"user_id"`
+	Username     string     `json:"username,omitempty"`
+	Capabilities []string   `json:"capabilities"`
+	AllowedIPs   []string   `json:"allowed_ips"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP   *string    `json:"last_used_ip,omitempty"`
+	CreatedBy    int        `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type APIKeyUsage struct {
+	ID         int       `json:"id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	StatusCode int       `json:"status_code"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Invitation struct {
+	ID            int        `json:"id"`
+	Email         string     `json:"email"`
+	UserType      string     `json:"user_type"`
+	Code          string     `json:"code,omitempty"`
+	CreatedBy     int        `json:"created_by"`
+	CreatedByName string     `json:"created_by_name,omitempty"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at,omitempty"`
+	UsedBy        *int       `json:"used_by,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
 }
 
 type AdminPaymentReport struct {
@@ -65,11 +306,13 @@ type AdminPaymentReport struct {
 	PaidMembers    int     `json:"paid_members"`
 	PendingMembers int     `json:"pending_members"`
 	TotalAmount    float64 `json:"total_amount"`
+	ExpectedAmount float64 `json:"expected_amount"`
 }
 
 type MonthlyCollection struct {
-	Month string  `json:"month"`
-	Total float64 `json:"total"`
+	Month    string  `json:"month"`
+	Total    float64 `json:"total"`
+	Expected float64 `json:"expected"`
 }
 
 type MonthlyDonation struct {
@@ -78,11 +321,14 @@ type MonthlyDonation struct {
 }
 
 type MonthlyCollectionDetail struct {
-	MemberName  string  `json:"member_name"`
-	ContactNo   string  `json:"contact_no"`
-	Amount      float64 `json:"amount"`
-	AdminName   string  `json:"admin_name"`
-	PaymentDate string  `json:"payment_date"`
+	MemberName   string  `json:"member_name"`
+	ContactNo    string  `json:"contact_no"`
+	Amount       float64 `json:"amount"`
+	PaymentMode  string  `json:"payment_mode"`
+	ReferenceNo  string  `json:"reference_no,omitempty"`
+	ChequeStatus string  `json:"cheque_status,omitempty"`
+	AdminName    string  `json:"admin_name"`
+	PaymentDate  string  `json:"payment_date"`
 }
 
 type MonthlyDonationDetail struct {
@@ -94,15 +340,22 @@ type MonthlyDonationDetail struct {
 }
 
 type PaidMemberReport struct {
-	MemberName  string  `json:"member_name"`
-	MobileNo    string  `json:"mobile_no"`
-	PaidAmount  float64 `json:"paid_amount"`
-	PaymentDate string  `json:"payment_date"`
-	AdminName   string  `json:"admin_name"`
+	MemberName     string            `json:"member_name"`
+	MobileNo       string            `json:"mobile_no"`
+	PaidAmount     float64           `json:"paid_amount"`
+	ExpectedAmount float64           `json:"expected_amount"`
+	PaymentDate    string            `json:"payment_date"`
+	AdminName      string            `json:"admin_name"`
+	CustomFields   map[string]string `json:"custom_fields"`
+	Tags           []string          `json:"tags"`
 }
 
 type UnpaidMemberReport struct {
-	MemberName string `json:"member_name"`
-	MobileNo   string `json:"mobile_no"`
-	AdminName  string `json:"admin_name"`
+	MemberName     string            `json:"member_name"`
+	MobileNo       string            `json:"mobile_no"`
+	ExpectedAmount float64           `json:"expected_amount"`
+	PaidAmount     float64           `json:"paid_amount"`
+	AdminName      string            `json:"admin_name"`
+	CustomFields   map[string]string `json:"custom_fields"`
+	Tags           []string          `json:"tags"`
 }
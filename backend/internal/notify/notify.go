@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email-style notification sent to a user.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users, e.g. password reset links.
+type Notifier interface {
+	Send(msg Message) error
+}
+
+// NewFromEnv builds the notifier selected by NOTIFIER ("log" or "smtp").
+// The log notifier is the default so local setups work without a mail server.
+func NewFromEnv() (Notifier, error) {
+	switch strings.ToLower(os.Getenv("NOTIFIER")) {
+	case "", "log":
+		return &LogNotifier{Path: os.Getenv("NOTIFIER_LOG_FILE")}, nil
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			host = "localhost"
+		}
+
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "1025"
+		}
+
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			from = "no-reply@khidmat.local"
+		}
+
+		return &SMTPNotifier{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", os.Getenv("NOTIFIER"))
+	}
+}
+
+// LogNotifier appends messages to a file, or to the application log when no
+// path is configured. It is intended for local development.
+type LogNotifier struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (n *LogNotifier) Send(msg Message) error {
+	if n.Path == "" {
+		log.Printf("Notification to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open notifier log: %w", err)
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "--- %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+// SMTPNotifier sends messages through an SMTP server. Without credentials it
+// sends unauthenticated, which works with local mail sinks such as MailHog.
+type SMTPNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (n *SMTPNotifier) Send(msg Message) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	body := strings.Join([]string{
+		"From: " + headerValue(n.From),
+		"To: " + headerValue(msg.To),
+		"Subject: " + headerValue(msg.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		msg.Body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(n.Host+":"+n.Port, auth, n.From, []string{msg.To}, []byte(body)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// headerValue strips line breaks so user-supplied values cannot inject
+// additional mail headers.
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMSSender delivers text messages to mobile numbers, e.g. member login codes.
+type SMSSender interface {
+	SendSMS(to, text string) error
+}
+
+// NewSMSSenderFromEnv builds the SMS sender selected by SMS_PROVIDER. Only the
+// local "log" stub is built in; real gateways implement SMSSender.
+func NewSMSSenderFromEnv() (SMSSender, error) {
+	switch strings.ToLower(os.Getenv("SMS_PROVIDER")) {
+	case "", "log":
+		return &LogSMSSender{Path: os.Getenv("SMS_LOG_FILE")}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q", os.Getenv("SMS_PROVIDER"))
+	}
+}
+
+// LogSMSSender is a stub that appends messages to a file, or to the
+// application log when no path is configured.
+type LogSMSSender struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (s *LogSMSSender) SendSMS(to, text string) error {
+	if s.Path == "" {
+		log.Printf("SMS to %s: %s", to, text)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open SMS log: %w", err)
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), to, text)
+	return err
+}
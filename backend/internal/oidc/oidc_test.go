@@ -0,0 +1,149 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/khidmat/backend/internal/oidc/oidctest"
+)
+
+const testClientID = "khidmat"
+const testRedirectURL = "http://localhost:8080/api/auth/oidc/callback"
+
+// startMockProvider runs the mock identity provider and returns a relying
+// party configured for it.
+func startMockProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	t.Helper()
+	server := httptest.NewUnstartedServer(nil)
+	mock, err := oidctest.New("http://"+server.Listener.Addr().String(), testClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Config.Handler = mock.Handler()
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return NewProvider(Config{
+		IssuerURL:   mock.Issuer,
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+		Scopes:      []string{"openid", "email", "profile"},
+	}), mock
+}
+
+func TestExchange(t *testing.T) {
+	provider, _ := startMockProvider(t)
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := sha256.Sum256([]byte("verifier-1"))
+	if got := parsed.Query().Get("code_challenge"); got != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		t.Errorf("code_challenge = %q, want the S256 challenge of the verifier", got)
+	}
+
+	// Sign in on the mock's form without following the redirect back.
+	form := parsed.Query()
+	form.Set("email", "Aisha@example.org")
+	form.Set("name", "Aisha Khan")
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	signIn := func() url.Values {
+		resp, err := client.PostForm(parsed.Scheme+"://"+parsed.Host+parsed.Path, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return callback.Query()
+	}
+
+	callback := signIn()
+	if callback.Get("state") != "state-1" {
+		t.Errorf("callback state = %q, want state-1", callback.Get("state"))
+	}
+	if _, err := provider.Exchange(callback.Get("code"), "wrong-verifier", "nonce-1"); err == nil {
+		t.Error("Exchange accepted the wrong PKCE verifier")
+	}
+
+	identity, err := provider.Exchange(signIn().Get("code"), "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	want := Identity{Subject: "Aisha@example.org", Email: "Aisha@example.org", Name: "Aisha Khan", PreferredUsername: "Aisha"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	provider, mock := startMockProvider(t)
+
+	sign := func(change func(jwt.MapClaims)) string {
+		claims := mock.Claims("aisha@example.org", "Aisha Khan", "nonce-1")
+		if change != nil {
+			change(claims)
+		}
+		signed, err := mock.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, mock.Claims("aisha@example.org", "", "nonce-1"))
+	hmacToken.Header["kid"] = oidctest.KeyID
+	hmacSigned, err := hmacToken.SignedString([]byte("a-secret-the-provider-never-had"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		nonce   string
+		wantErr string
+	}{
+		{"valid", sign(nil), "nonce-1", ""},
+		{"nonce mismatch", sign(nil), "nonce-2", "nonce does not match"},
+		{"nonce missing", sign(func(c jwt.MapClaims) { delete(c, "nonce") }), "nonce-1", "nonce does not match"},
+		{"wrong audience", sign(func(c jwt.MapClaims) { c["aud"] = "another-client" }), "nonce-1", "aud"},
+		{"wrong issuer", sign(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.org" }), "nonce-1", "iss"},
+		{"expired", sign(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }), "nonce-1", "expired"},
+		{"disallowed alg", hmacSigned, "nonce-1", "signing method"},
+		{"email not verified", sign(func(c jwt.MapClaims) { c["email_verified"] = false }), "nonce-1", "not verified"},
+		{"no email", sign(func(c jwt.MapClaims) { delete(c, "email") }), "nonce-1", "no email claim"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := provider.verifyIDToken(tt.token, tt.nonce)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifyIDToken: %v", err)
+				}
+				if identity.Email != "aisha@example.org" {
+					t.Errorf("email = %q, want aisha@example.org", identity.Email)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verifyIDToken error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const (
+	testWorkbook = `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Members" sheetId="1" r:id="rId2"/><sheet name="Notes" sheetId="2" r:id="rId1"/></sheets>
+</workbook>`
+	testRels = `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+	testSharedStrings = `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>name</t></si><si><t>mobile</t></si><si><r><t>Aisha </t></r><r><t>Khan</t></r></si>
+</sst>`
+	// Row 3 is empty and omitted, and B4 has no value.
+	testSheet = `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+<row r="2"><c r="A2" t="s"><v>2</v></c><c r="B2"><v>9812345678</v></c></row>
+<row r="4"><c r="A4" t="inlineStr"><is><t>Bilal</t></is></c><c r="C4"><v>1500.5</v></c></row>
+</sheetData></worksheet>`
+)
+
+func buildXLSX(t *testing.T, parts map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadXLSX(t *testing.T) {
+	data := buildXLSX(t, map[string]string{
+		"xl/workbook.xml":            testWorkbook,
+		"xl/_rels/workbook.xml.rels": testRels,
+		"xl/sharedStrings.xml":       testSharedStrings,
+		"xl/worksheets/sheet1.xml":   testSheet,
+		"xl/worksheets/sheet2.xml":   `<worksheet><sheetData/></worksheet>`,
+	})
+
+	// Neither the name nor an unknown extension hides an XLSX file.
+	for _, filename := range []string{"members.xlsx", "members.upload"} {
+		rows, err := Read(filename, data)
+		if err != nil {
+			t.Fatalf("Read(%q): %v", filename, err)
+		}
+		want := [][]string{
+			{"name", "mobile"},
+			{"Aisha Khan", "9812345678"},
+			nil,
+			{"Bilal", "", "1500.5"},
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("Read(%q) = %q, want %q", filename, rows, want)
+		}
+	}
+}
+
+func TestReadXLSXErrors(t *testing.T) {
+	base := map[string]string{
+		"xl/workbook.xml":            testWorkbook,
+		"xl/_rels/workbook.xml.rels": testRels,
+		"xl/sharedStrings.xml":       testSharedStrings,
+	}
+	with := func(name, content string) map[string]string {
+		parts := map[string]string{name: content}
+		for k, v := range base {
+			if k != name {
+				parts[k] = v
+			}
+		}
+		return parts
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a zip", []byte("PK\x03\x04garbage")},
+		{"missing sheet", buildXLSX(t, base)},
+		{"no sheets", buildXLSX(t, with("xl/workbook.xml", `<workbook><sheets/></workbook>`))},
+		{"shared string out of range", buildXLSX(t, with("xl/worksheets/sheet1.xml",
+			`<worksheet><sheetData><row r="1"><c r="A1" t="s"><v>7</v></c></row></sheetData></worksheet>`))},
+		{"bad cell reference", buildXLSX(t, with("xl/worksheets/sheet1.xml",
+			`<worksheet><sheetData><row r="1"><c r="1A"><v>x</v></c></row></sheetData></worksheet>`))},
+	}
+
+	for _, tt := range tests {
+		if _, err := ReadXLSX(tt.data); err == nil {
+			t.Errorf("%s: ReadXLSX succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	data := []byte("\xef\xbb\xbfname, mobile\n\"Khan, Aisha\",9812345678,extra\nBilal\n")
+
+	rows, err := Read("members.csv", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"name", "mobile"},
+		{"Khan, Aisha", "9812345678", "extra"},
+		{"Bilal"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Read = %q, want %q", rows, want)
+	}
+
+	if _, err := ReadCSV(bytes.NewReader([]byte("name\n\"unterminated\n"))); err == nil {
+		t.Error("ReadCSV accepted an unterminated quote")
+	}
+}
+
+func TestReadXLS(t *testing.T) {
+	if _, err := Read("members.xls", []byte("anything")); err != ErrUnsupportedFormat {
+		t.Errorf("Read(.xls) error = %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want int
+	}{
+		{"A1", 0},
+		{"Z9", 25},
+		{"AA10", 26},
+		{"AB12", 27},
+		{"XFD1", 16383},
+		{"ABCD1", -1},
+		{"12", -1},
+		{"a1", -1},
+	}
+
+	for _, tt := range tests {
+		if got := columnIndex(tt.ref); got != tt.want {
+			t.Errorf("columnIndex(%q) = %d, want %d", tt.ref, got, tt.want)
+		}
+	}
+}
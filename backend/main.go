@@ -10,6 +10,8 @@ import (
 	"github.com/khidmat/backend/internal/database"
 	"github.com/khidmat/backend/internal/handlers"
 	"github.com/khidmat/backend/internal/middleware"
+	"github.com/khidmat/backend/internal/notify"
+	"github.com/khidmat/backend/internal/oidc"
 )
 
 func main() {
@@ -18,6 +20,11 @@ func main() {
 		log.Println("No .env file found")
 	}
 
+	// Load JWT signing keys; refuses the default secret outside development
+	if err := middleware.LoadSigningKeys(); err != nil {
+		log.Fatal("Failed to configure JWT signing keys:", err)
+	}
+
 	// Initialize database
 	db, err := database.InitDB()
 	if err != nil {
@@ -25,8 +32,26 @@ func main() {
 	}
 	defer db.Close()
 
+	// Initialize notifier used for password reset and other user messages
+	notifier, err := notify.NewFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure notifier:", err)
+	}
+
+	// Initialize SMS sender used for member login codes
+	smsSender, err := notify.NewSMSSenderFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure SMS sender:", err)
+	}
+
+	// Initialize the OpenID Connect provider used for single sign-on, if any
+	oidcProvider, err := oidc.NewFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure single sign-on:", err)
+	}
+
 	// Initialize handlers
-	h := handlers.NewHandlers(db)
+	h := handlers.NewHandlers(db, notifier, smsSender, oidcProvider)
 
 	// Setup routes
 	r := mux.NewRouter()
@@ -37,33 +62,134 @@ func main() {
 	// Auth routes
 	r.HandleFunc("/api/auth/login", h.Login).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/auth/signup", h.Signup).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/refresh", h.RefreshToken).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/bootstrap", h.Bootstrap).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/password/forgot", h.ForgotPassword).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/password/reset", h.ResetPassword).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/2fa/verify", h.VerifyTwoFactor).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/2fa/enroll", h.EnrollTwoFactor).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/2fa/enroll/confirm", h.ConfirmTwoFactorEnrollment).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/oidc/login", h.OIDCLogin).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/auth/oidc/callback", h.OIDCCallback).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/auth/oidc/exchange", h.OIDCExchange).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/member/otp/request", h.RequestMemberOTP).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/member/otp/verify", h.VerifyMemberOTP).Methods("POST", "OPTIONS")
 
 	// Protected routes
 	api := r.PathPrefix("/api").Subrouter()
 	api.Use(middleware.AuthMiddleware)
 
+	// Every protected route declares the capability it needs. Callers without
+	// records.all are further restricted to their own rows inside the handlers.
+
+	// Session routes
+	api.HandleFunc("/auth/logout", middleware.RequireLogin(h.Logout)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/logout-all", middleware.RequireLogin(h.LogoutAll)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/password/change", middleware.RequireLogin(h.ChangePassword)).Methods("POST", "OPTIONS")
+
+	// Two-factor routes
+	api.HandleFunc("/auth/2fa/setup", middleware.RequireLogin(h.SetupTwoFactor)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/2fa/enable", middleware.RequireLogin(h.EnableTwoFactor)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/2fa/disable", middleware.RequireLogin(h.DisableTwoFactor)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/2fa/recovery-codes", middleware.RequireLogin(h.RegenerateRecoveryCodes)).Methods("POST", "OPTIONS")
+
+	// Settings routes
+	api.HandleFunc("/settings", middleware.RequireCapability(h.GetSettings, middleware.CapSettingsManage)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/settings/{key}", middleware.RequireCapability(h.UpdateSetting, middleware.CapSettingsManage)).Methods("PUT", "OPTIONS")
+
+	// User routes
+	api.HandleFunc("/users", middleware.RequireCapability(h.GetUsers, middleware.CapUsersManage)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/users", middleware.RequireCapability(h.CreateUser, middleware.CapUsersManage)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/users/{id}", middleware.RequireCapability(h.GetUser, middleware.CapUsersManage)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/users/{id}", middleware.RequireCapability(h.UpdateUser, middleware.CapUsersManage)).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/users/{id}/disable", middleware.RequireCapability(h.DisableUser, middleware.CapUsersManage)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/users/{id}/enable", middleware.RequireCapability(h.EnableUser, middleware.CapUsersManage)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/users/{id}/unlock", middleware.RequireCapability(h.UnlockUser, middleware.CapUsersManage)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/users/{id}/login-history", middleware.RequireUser(h.GetLoginHistory)).Methods("GET", "OPTIONS")
+
+	// Role routes
+	api.HandleFunc("/capabilities", middleware.RequireCapability(h.GetCapabilities, middleware.CapRolesManage)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/roles", middleware.RequireCapability(h.GetRoles, middleware.CapRolesManage)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/roles", middleware.RequireCapability(h.CreateRole, middleware.CapRolesManage)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/roles/{name}", middleware.RequireCapability(h.UpdateRole, middleware.CapRolesManage)).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/roles/{name}", middleware.RequireCapability(h.DeleteRole, middleware.CapRolesManage)).Methods("DELETE", "OPTIONS")
+
+	// API key routes
+	api.HandleFunc("/api-keys", middleware.RequireCapability(h.CreateAPIKey, middleware.CapAPIKeysManage)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/api-keys", middleware.RequireCapability(h.GetAPIKeys, middleware.CapAPIKeysManage)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/api-keys/{id}", middleware.RequireCapability(h.RevokeAPIKey, middleware.CapAPIKeysManage)).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/api-keys/{id}/usage", middleware.RequireCapability(h.GetAPIKeyUsage, middleware.CapAPIKeysManage)).Methods("GET", "OPTIONS")
+
+	// Invitation routes
+	api.HandleFunc("/invitations", middleware.RequireCapability(h.CreateInvitation, middleware.CapUsersManage)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/invitations", middleware.RequireCapability(h.GetInvitations, middleware.CapUsersManage)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/invitations/{id}", middleware.RequireCapability(h.RevokeInvitation, middleware.CapUsersManage)).Methods("DELETE", "OPTIONS")
+
+	// Member self-service portal routes
+	api.HandleFunc("/me/logout", middleware.RequireRole(h.MemberLogout, middleware.RoleMember)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/me/profile", middleware.RequireRole(h.GetMyProfile, middleware.RoleMember)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/me/payments", middleware.RequireRole(h.GetMyPayments, middleware.RoleMember)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/me/payments/{id}/receipt", middleware.RequireRole(h.GetMyReceipt, middleware.RoleMember)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/me/dues", middleware.RequireRole(h.GetMyDues, middleware.RoleMember)).Methods("GET", "OPTIONS")
+
 	// Member routes
-	api.HandleFunc("/members", h.CreateMember).Methods("POST", "OPTIONS")
-	api.HandleFunc("/members", h.GetMembers).Methods("GET", "OPTIONS")
-	api.HandleFunc("/members/{id}/toggle-status", h.ToggleMemberStatus).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/members", middleware.RequireCapability(h.CreateMember, middleware.CapMembersWrite)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/members", middleware.RequireCapability(h.GetMembers, middleware.CapMembersRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/members/export", middleware.RequireCapability(h.ExportMembers, middleware.CapMembersRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/members/import", middleware.RequireCapability(h.ImportMembers, middleware.CapMembersWrite)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/members/transfer", middleware.RequireCapability(h.TransferMembers, middleware.CapMembersWrite, middleware.CapRecordsAll)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/members/duplicates", middleware.RequireCapability(h.GetMemberDuplicates, middleware.CapMembersRead, middleware.CapRecordsAll)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/members/{id}", middleware.RequireCapability(h.GetMember, middleware.CapMembersRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/members/{id}", middleware.RequireCapability(h.UpdateMember, middleware.CapMembersWrite)).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/members/{id}", middleware.RequireCapability(h.DeleteMember, middleware.CapMembersWrite)).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/members/{id}/merge", middleware.RequireCapability(h.MergeMembers, middleware.CapMembersWrite, middleware.CapRecordsAll)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/members/{id}/transfer", middleware.RequireCapability(h.TransferMember, middleware.CapMembersWrite, middleware.CapRecordsAll)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/members/{id}/transfers", middleware.RequireCapability(h.GetMemberTransfers, middleware.CapMembersRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/members/{id}/statement", middleware.RequireCapability(h.GetMemberStatement, middleware.CapMembersRead, middleware.CapPaymentsRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/members/{id}/plans", middleware.RequireCapability(h.GetMemberPlans, middleware.CapMembersRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/members/{id}/plans", middleware.RequireCapability(h.AssignMemberPlan, middleware.CapMembersWrite)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/members/{id}/status", middleware.RequireCapability(h.ChangeMemberStatus, middleware.CapMembersWrite)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/members/{id}/status-history", middleware.RequireCapability(h.GetMemberStatusHistory, middleware.CapMembersRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/members/{id}/toggle-status", middleware.RequireCapability(h.ToggleMemberStatus, middleware.CapMembersWrite)).Methods("PUT", "OPTIONS")
+
+	// Custom member field routes
+	api.HandleFunc("/member-fields", middleware.RequireCapability(h.GetMemberFields, middleware.CapMembersRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/member-fields", middleware.RequireCapability(h.CreateMemberField, middleware.CapSettingsManage)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/member-fields/{id}", middleware.RequireCapability(h.UpdateMemberField, middleware.CapSettingsManage)).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/member-fields/{id}", middleware.RequireCapability(h.DeleteMemberField, middleware.CapSettingsManage)).Methods("DELETE", "OPTIONS")
+
+	// Contribution plan routes
+	api.HandleFunc("/plans", middleware.RequireCapability(h.GetPlans, middleware.CapMembersRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/plans", middleware.RequireCapability(h.CreatePlan, middleware.CapSettingsManage)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/plans/{id}", middleware.RequireCapability(h.UpdatePlan, middleware.CapSettingsManage)).Methods("PUT", "OPTIONS")
 
 	// Payment routes
-	api.HandleFunc("/payments", h.CreatePayment).Methods("POST", "OPTIONS")
-	api.HandleFunc("/payments", h.GetPayments).Methods("GET", "OPTIONS")
+	api.HandleFunc("/payments", middleware.RequireCapability(h.CreatePayment, middleware.CapPaymentsWrite)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/payments", middleware.RequireCapability(h.GetPayments, middleware.CapPaymentsRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/payments/{id}/receipt", middleware.RequireCapability(h.GetPaymentReceipt, middleware.CapPaymentsRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/payments/{id}/allocations", middleware.RequireCapability(h.UpdatePaymentAllocations, middleware.CapPaymentsWrite)).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/payments/{id}/cheque-status", middleware.RequireCapability(h.UpdateChequeStatus, middleware.CapPaymentsWrite)).Methods("PUT", "OPTIONS")
+
+	// Household routes
+	api.HandleFunc("/households", middleware.RequireCapability(h.GetHouseholds, middleware.CapMembersRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/households", middleware.RequireCapability(h.CreateHousehold, middleware.CapMembersWrite)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/households/{id}", middleware.RequireCapability(h.GetHousehold, middleware.CapMembersRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/households/{id}", middleware.RequireCapability(h.UpdateHousehold, middleware.CapMembersWrite)).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/households/{id}", middleware.RequireCapability(h.DeleteHousehold, middleware.CapMembersWrite)).Methods("DELETE", "OPTIONS")
 
 	// Donation routes
-	api.HandleFunc("/donations", h.CreateDonation).Methods("POST", "OPTIONS")
-	api.HandleFunc("/donations", h.GetDonations).Methods("GET", "OPTIONS")
+	api.HandleFunc("/donations", middleware.RequireCapability(h.CreateDonation, middleware.CapDonationsWrite)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/donations", middleware.RequireCapability(h.GetDonations, middleware.CapDonationsRead)).Methods("GET", "OPTIONS")
 
 	// Report routes
-	api.HandleFunc("/reports/admin-payments", h.GetAdminPaymentsReport).Methods("GET", "OPTIONS")
-	api.HandleFunc("/reports/paid-members", h.GetPaidMembersReport).Methods("GET", "OPTIONS")
-	api.HandleFunc("/reports/unpaid-members", h.GetUnpaidMembersReport).Methods("GET", "OPTIONS")
-	api.HandleFunc("/reports/monthly-collection", h.GetMonthlyCollection).Methods("GET", "OPTIONS")
-	api.HandleFunc("/reports/monthly-collection-details", h.GetMonthlyCollectionDetails).Methods("GET", "OPTIONS")
-	api.HandleFunc("/reports/monthly-donations", h.GetMonthlyDonations).Methods("GET", "OPTIONS")
-	api.HandleFunc("/reports/monthly-donation-details", h.GetMonthlyDonationDetails).Methods("GET", "OPTIONS")
-	api.HandleFunc("/reports/pool-balance", h.GetPoolBalance).Methods("GET", "OPTIONS")
+	api.HandleFunc("/reports/admin-payments", middleware.RequireCapability(h.GetAdminPaymentsReport, middleware.CapReportsFinancial)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/reports/paid-members", middleware.RequireCapability(h.GetPaidMembersReport, middleware.CapReportsRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/reports/unpaid-members", middleware.RequireCapability(h.GetUnpaidMembersReport, middleware.CapReportsRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/reports/monthly-collection", middleware.RequireCapability(h.GetMonthlyCollection, middleware.CapReportsRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/reports/monthly-collection-details", middleware.RequireCapability(h.GetMonthlyCollectionDetails, middleware.CapReportsRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/reports/monthly-donations", middleware.RequireCapability(h.GetMonthlyDonations, middleware.CapReportsRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/reports/monthly-donation-details", middleware.RequireCapability(h.GetMonthlyDonationDetails, middleware.CapReportsRead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/reports/pool-balance", middleware.RequireCapability(h.GetPoolBalance, middleware.CapReportsFinancial)).Methods("GET", "OPTIONS")
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -4,6 +4,9 @@ import { ToastContainer } from 'react-toastify';
 import 'react-toastify/dist/ReactToastify.css';
 import Login from './components/Auth/Login';
 import Signup from './components/Auth/Signup';
+import ForgotPassword from './components/Auth/ForgotPassword';
+import ResetPassword from './components/Auth/ResetPassword';
+import SsoCallback from './components/Auth/SsoCallback';
 import Dashboard from './components/Dashboard/Dashboard';
 import MemberRegistration from './components/Members/MemberRegistration';
 import MemberList from './components/Members/MemberList';
@@ -20,6 +23,9 @@ function App() {
         <Routes>
           <Route path="/login" element={<Login />} />
           <Route path="/signup" element={<Signup />} />
+          <Route path="/forgot-password" element={<ForgotPassword />} />
+          <Route path="/reset-password" element={<ResetPassword />} />
+          <Route path="/sso-callback" element={<SsoCallback />} />
           <Route
             path="/dashboard"
             element={
@@ -0,0 +1,65 @@
+import React, { useState } from 'react';
+import { Link } from 'react-router-dom';
+import { toast } from 'react-toastify';
+import api from '../../services/api';
+import logoIcon from '../../assets/logo-icon.svg';
+import './Auth.css';
+
+const ForgotPassword = () => {
+  const [email, setEmail] = useState('');
+  const [loading, setLoading] = useState(false);
+  const [submitted, setSubmitted] = useState(false);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setLoading(true);
+
+    try {
+      await api.post('/auth/password/forgot', { email });
+      setSubmitted(true);
+    } catch (error) {
+      toast.error(error.response?.data?.error || 'Failed to request password reset');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="auth-container">
+      <div className="auth-card">
+        <div className="auth-logo-container">
+          <img src={logoIcon} alt="Khidmat Logo" className="auth-logo" />
+          <h2>Khidmat</h2>
+        </div>
+        <p className="auth-subtitle">Reset your password</p>
+        {submitted ? (
+          <p className="auth-subtitle">
+            If an account exists for that email, a password reset link has been sent.
+          </p>
+        ) : (
+          <form onSubmit={handleSubmit}>
+            <div className="form-group">
+              <label>Email</label>
+              <input
+                type="email"
+                name="email"
+                className="form-control"
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                required
+              />
+            </div>
+            <button type="submit" className="btn btn-primary" disabled={loading}>
+              {loading ? 'Sending...' : 'Send Reset Link'}
+            </button>
+          </form>
+        )}
+        <p className="auth-link">
+          Remembered it? <Link to="/login">Login</Link>
+        </p>
+      </div>
+    </div>
+  );
+};
+
+export default ForgotPassword;
@@ -2,15 +2,20 @@ import React, { useState } from 'react';
 import { useNavigate, Link } from 'react-router-dom';
 import { toast } from 'react-toastify';
 import api from '../../services/api';
+import { storeSession } from '../../services/session';
+import TwoFactorStep from './TwoFactorStep';
 import logoIcon from '../../assets/logo-icon.svg';
 import './Auth.css';
 
+const ssoEnabled = process.env.REACT_APP_SSO_ENABLED === 'true';
+
 const Login = () => {
   const [formData, setFormData] = useState({
     username: '',
     password: '',
   });
   const [loading, setLoading] = useState(false);
+  const [challenge, setChallenge] = useState(null);
   const navigate = useNavigate();
 
   const handleChange = (e) => {
@@ -20,19 +25,33 @@ const Login = () => {
     });
   };
 
+  const completeLogin = (session) => {
+    storeSession(session);
+    toast.success('Login successful!');
+    navigate('/dashboard');
+  };
+
   const handleSubmit = async (e) => {
     e.preventDefault();
     setLoading(true);
 
     try {
       const response = await api.post('/auth/login', formData);
-      localStorage.setItem('token', response.data.token);
-      localStorage.setItem('user', JSON.stringify({
-        id: response.data.user_id,
-        type: response.data.user_type,
-      }));
-      toast.success('Login successful!');
-      navigate('/dashboard');
+
+      if (response.data.two_factor_required) {
+        setChallenge({ token: response.data.challenge_token });
+        return;
+      }
+
+      if (response.data.two_factor_setup_required) {
+        const setup = await api.post('/auth/2fa/enroll', {
+          challenge_token: response.data.challenge_token,
+        });
+        setChallenge({ token: response.data.challenge_token, setup: setup.data });
+        return;
+      }
+
+      completeLogin(response.data);
     } catch (error) {
       // Handle different error scenarios
       let errorMessage = 'Login failed';
@@ -67,6 +86,14 @@ const Login = () => {
           <h2>Khidmat</h2>
         </div>
         <p className="auth-subtitle">Sign In to your account</p>
+        {challenge ? (
+          <TwoFactorStep
+            challengeToken={challenge.token}
+            setup={challenge.setup}
+            onComplete={completeLogin}
+            onCancel={() => setChallenge(null)}
+          />
+        ) : (
         <form onSubmit={handleSubmit}>
           <div className="form-group">
             <label>Username</label>
@@ -94,6 +121,15 @@ const Login = () => {
             {loading ? 'Logging in...' : 'Login'}
           </button>
         </form>
+        )}
+        {!challenge && ssoEnabled && (
+          <a className="btn btn-secondary sso-btn" href={`${api.defaults.baseURL}/auth/oidc/login`}>
+            Sign in with SSO
+          </a>
+        )}
+        <p className="auth-link">
+          <Link to="/forgot-password">Forgot password?</Link>
+        </p>
         <p className="auth-link">
           Don't have an account? <Link to="/signup">Sign up</Link>
         </p>
@@ -2,6 +2,7 @@ import React, { useState } from 'react';
 import { useNavigate, Link } from 'react-router-dom';
 import { toast } from 'react-toastify';
 import api from '../../services/api';
+import { storeSession } from '../../services/session';
 import logoIcon from '../../assets/logo-icon.svg';
 import './Auth.css';
 
@@ -10,7 +11,7 @@ const Signup = () => {
     username: '',
     email: '',
     password: '',
-    user_type: 'account_admin',
+    invitation_code: '',
   });
   const [loading, setLoading] = useState(false);
   const navigate = useNavigate();
@@ -28,11 +29,7 @@ const Signup = () => {
 
     try {
       const response = await api.post('/auth/signup', formData);
-      localStorage.setItem('token', response.data.token);
-      localStorage.setItem('user', JSON.stringify({
-        id: response.data.user_id,
-        type: response.data.user_type,
-      }));
+      storeSession(response.data);
       toast.success('Signup successful!');
       navigate('/dashboard');
     } catch (error) {
@@ -85,17 +82,15 @@ const Signup = () => {
             />
           </div>
           <div className="form-group">
-            <label>User Type</label>
-            <select
-              name="user_type"
+            <label>Invitation Code</label>
+            <input
+              type="text"
+              name="invitation_code"
               className="form-control"
-              value={formData.user_type}
+              value={formData.invitation_code}
               onChange={handleChange}
               required
-            >
-              <option value="master_admin">Master Admin</option>
-              <option value="account_admin">Account Admin</option>
-            </select>
+            />
           </div>
           <button type="submit" className="btn btn-primary" disabled={loading}>
             {loading ? 'Signing up...' : 'Sign Up'}
@@ -0,0 +1,82 @@
+import React, { useCallback, useEffect, useRef, useState } from 'react';
+import { useNavigate } from 'react-router-dom';
+import { toast } from 'react-toastify';
+import api from '../../services/api';
+import { storeSession } from '../../services/session';
+import TwoFactorStep from './TwoFactorStep';
+import logoIcon from '../../assets/logo-icon.svg';
+import './Auth.css';
+
+// Landing page after single sign-on. The backend puts a one-time login code
+// (or an error) in the URL fragment, which is exchanged here for a session
+// or, for accounts with two-factor authentication, a login challenge.
+const SsoCallback = () => {
+  const navigate = useNavigate();
+  const exchanged = useRef(false);
+  const [challenge, setChallenge] = useState(null);
+
+  const completeLogin = useCallback((session) => {
+    storeSession(session);
+    toast.success('Login successful!');
+    navigate('/dashboard');
+  }, [navigate]);
+
+  useEffect(() => {
+    if (exchanged.current) {
+      return;
+    }
+    exchanged.current = true;
+
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    window.history.replaceState(null, '', window.location.pathname);
+
+    if (params.get('error')) {
+      toast.error(params.get('error'));
+      navigate('/login');
+      return;
+    }
+
+    api.post('/auth/oidc/exchange', { code: params.get('code') })
+      .then(async (response) => {
+        if (response.data.two_factor_required) {
+          setChallenge({ token: response.data.challenge_token });
+          return;
+        }
+        if (response.data.two_factor_setup_required) {
+          const setup = await api.post('/auth/2fa/enroll', {
+            challenge_token: response.data.challenge_token,
+          });
+          setChallenge({ token: response.data.challenge_token, setup: setup.data });
+          return;
+        }
+        completeLogin(response.data);
+      })
+      .catch((error) => {
+        toast.error(error.response?.data?.error || 'Single sign-on failed');
+        navigate('/login');
+      });
+  }, [navigate, completeLogin]);
+
+  return (
+    <div className="auth-container">
+      <div className="auth-card">
+        <div className="auth-logo-container">
+          <img src={logoIcon} alt="Khidmat Logo" className="auth-logo" />
+          <h2>Khidmat</h2>
+        </div>
+        {challenge ? (
+          <TwoFactorStep
+            challengeToken={challenge.token}
+            setup={challenge.setup}
+            onComplete={completeLogin}
+            onCancel={() => navigate('/login')}
+          />
+        ) : (
+          <p className="auth-subtitle">Signing you in...</p>
+        )}
+      </div>
+    </div>
+  );
+};
+
+export default SsoCallback;
@@ -0,0 +1,107 @@
+import React, { useState } from 'react';
+import { toast } from 'react-toastify';
+import api from '../../services/api';
+
+// Second login step for accounts with two-factor authentication. When
+// enrollment is required, `setup` holds the secret to add to the app.
+const TwoFactorStep = ({ challengeToken, setup, onComplete, onCancel }) => {
+  const [code, setCode] = useState('');
+  const [useRecoveryCode, setUseRecoveryCode] = useState(false);
+  const [recoveryCodes, setRecoveryCodes] = useState(null);
+  const [session, setSession] = useState(null);
+  const [loading, setLoading] = useState(false);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setLoading(true);
+
+    try {
+      if (setup) {
+        const response = await api.post('/auth/2fa/enroll/confirm', {
+          challenge_token: challengeToken,
+          code,
+        });
+        // Show the recovery codes once before continuing
+        setRecoveryCodes(response.data.recovery_codes);
+        setSession(response.data);
+      } else {
+        const response = await api.post('/auth/2fa/verify', {
+          challenge_token: challengeToken,
+          ...(useRecoveryCode ? { recovery_code: code } : { code }),
+        });
+        onComplete(response.data);
+      }
+    } catch (error) {
+      toast.error(error.response?.data?.error || 'Verification failed');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  if (recoveryCodes) {
+    return (
+      <div>
+        <p className="auth-subtitle">
+          Save these recovery codes somewhere safe. Each can be used once if you lose your phone.
+        </p>
+        <pre className="recovery-codes">{recoveryCodes.join('\n')}</pre>
+        <button type="button" className="btn btn-primary" onClick={() => onComplete(session)}>
+          Continue
+        </button>
+      </div>
+    );
+  }
+
+  return (
+    <form onSubmit={handleSubmit}>
+      {setup ? (
+        <>
+          <p className="auth-subtitle">
+            Your organisation requires two-factor authentication. Add this key to your authenticator app, then enter the code it shows.
+          </p>
+          <div className="form-group">
+            <label>Secret Key</label>
+            <input type="text" className="form-control" value={setup.secret} readOnly />
+          </div>
+          <p className="auth-link">
+            <a href={setup.provisioning_uri}>Open in authenticator app</a>
+          </p>
+        </>
+      ) : (
+        <p className="auth-subtitle">
+          {useRecoveryCode ? 'Enter one of your recovery codes' : 'Enter the code from your authenticator app'}
+        </p>
+      )}
+      <div className="form-group">
+        <label>{useRecoveryCode ? 'Recovery Code' : 'Authentication Code'}</label>
+        <input
+          type="text"
+          name="code"
+          className="form-control"
+          value={code}
+          onChange={(e) => setCode(e.target.value)}
+          autoComplete="one-time-code"
+          autoFocus
+          required
+        />
+      </div>
+      <button type="submit" className="btn btn-primary" disabled={loading}>
+        {loading ? 'Verifying...' : 'Verify'}
+      </button>
+      {!setup && (
+        <p className="auth-link">
+          <button type="button" className="btn-link" onClick={() => setUseRecoveryCode(!useRecoveryCode)}>
+            {useRecoveryCode ? 'Use authenticator code' : 'Use a recovery code'}
+          </button>
+        </p>
+      )}
+      <p className="auth-link">
+        <button type="button" className="btn-link" onClick={onCancel}>
+          Back to login
+        </button>
+      </p>
+    </form>
+  );
+};
+
+export default TwoFactorStep;
@@ -15,12 +15,14 @@ import {
   ResponsiveContainer,
 } from 'recharts';
 import api from '../../services/api';
+import { formatRole } from '../../services/roles';
 import Layout from '../Layout/Layout';
 import './Dashboard.css';
 
 const Dashboard = () => {
   const navigate = useNavigate();
   const user = JSON.parse(localStorage.getItem('user') || '{}');
+  const canViewFinancials = (user.capabilities || []).includes('reports.financial');
   const [loading, setLoading] = useState(true);
   const [monthlyCollection, setMonthlyCollection] = useState([]);
   const [monthlyDonations, setMonthlyDonations] = useState([]);
@@ -45,8 +47,9 @@ const Dashboard = () => {
       ] = await Promise.all([
         api.get('/reports/monthly-collection'),
         api.get('/reports/monthly-donations'),
-        api.get('/reports/admin-payments'),
-        api.get('/reports/pool-balance'),
+        // Organisation-wide reports need the reports.financial capability
+        canViewFinancials ? api.get('/reports/admin-payments') : Promise.resolve({ data: [] }),
+        canViewFinancials ? api.get('/reports/pool-balance') : Promise.resolve({ data: null }),
         api.get('/reports/paid-members'),
         api.get('/reports/unpaid-members'),
       ]);
@@ -122,7 +125,7 @@ const Dashboard = () => {
       <div className="dashboard">
         <h1>Dashboard</h1>
         <p className="welcome-message">
-          Welcome, {user.type === 'master_admin' ? 'Master Admin' : 'Account Admin'}!
+          Welcome, {formatRole(user.type)}!
         </p>
 
         {/* Summary Statistics Cards */}
@@ -228,7 +231,7 @@ const Dashboard = () => {
           </div>
 
           {/* Pie Chart - Account Admin Payment Distribution */}
-          {user.type === 'master_admin' && adminPaymentsData.length > 0 && (
+          {canViewFinancials && adminPaymentsData.length > 0 && (
             <div className="chart-card">
               <h3>Account Admin Payment Distribution (Current Month)</h3>
               {loading ? (
@@ -1,5 +1,7 @@
 import React from 'react';
 import { useNavigate } from 'react-router-dom';
+import api from '../../services/api';
+import { formatRole } from '../../services/roles';
 import Footer from './Footer';
 import logoIcon from '../../assets/logo-icon.svg';
 import './Layout.css';
@@ -8,8 +10,14 @@ const Layout = ({ children }) => {
   const navigate = useNavigate();
   const user = JSON.parse(localStorage.getItem('user') || '{}');
 
-  const handleLogout = () => {
+  const handleLogout = async () => {
+    try {
+      await api.post('/auth/logout', { refresh_token: localStorage.getItem('refreshToken') });
+    } catch (error) {
+      // The session is cleared locally even if the server call fails
+    }
     localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
     localStorage.removeItem('user');
     navigate('/login');
   };
@@ -39,7 +47,7 @@ const Layout = ({ children }) => {
               Reports
             </button>
             <div className="user-info">
-              <span>{user.type === 'master_admin' ? 'Master Admin' : 'Account Admin'}</span>
+              <span>{formatRole(user.type)}</span>
               <button className="btn btn-secondary logout-btn" onClick={handleLogout}>
                 Logout
               </button>
@@ -6,20 +6,44 @@ import api from '../../services/api';
 import Layout from '../Layout/Layout';
 import './Members.css';
 
+const statusLabels = {
+  active: 'Active',
+  paused: 'Paused',
+  exited: 'Exited',
+  deceased: 'Deceased',
+};
+
 const MemberList = () => {
   const [members, setMembers] = useState([]);
+  const [total, setTotal] = useState(0);
+  const [nextCursor, setNextCursor] = useState(null);
   const [loading, setLoading] = useState(true);
   const [filterText, setFilterText] = useState('');
+  const [tagFilter, setTagFilter] = useState('');
   const navigate = useNavigate();
 
   useEffect(() => {
-    fetchMembers();
-  }, []);
+    // Search on the server, waiting for the user to stop typing.
+    const timer = setTimeout(() => fetchMembers(), 300);
+    return () => clearTimeout(timer);
+  }, [filterText, tagFilter]);
 
-  const fetchMembers = async () => {
+  const listParams = () => ({
+    q: filterText.trim() || undefined,
+    tag: tagFilter.trim() || undefined,
+  });
+
+  // fetchMembers loads the first page, or appends the page at cursor.
+  const fetchMembers = async (cursor) => {
+    setLoading(true);
     try {
-      const response = await api.get('/members');
-      setMembers(response.data);
+      const response = await api.get('/members', {
+        params: { ...listParams(), cursor, limit: 100 },
+      });
+      const page = response.data;
+      setMembers((current) => (cursor ? [...current, ...page.items] : page.items));
+      setTotal(page.total);
+      setNextCursor(page.next_cursor || null);
     } catch (error) {
       toast.error('Failed to fetch members');
     } finally {
@@ -27,13 +51,54 @@ const MemberList = () => {
     }
   };
 
-  const handleToggleStatus = async (memberId, currentStatus) => {
+  const handleStatusChange = async (member, status) => {
+    const reason = window.prompt(`Reason for marking ${member.name} as ${status}:`);
+    if (!reason || !reason.trim()) {
+      return;
+    }
     try {
-      await api.put(`/members/${memberId}/toggle-status`);
-      toast.success(`Member ${!currentStatus ? 'activated' : 'deactivated'} successfully`);
-      fetchMembers();
+      const response = await api.post(`/members/${member.id}/status`, { status, reason });
+      toast.success('Member status updated successfully');
+      setMembers((current) =>
+        current.map((m) =>
+          m.id === member.id
+            ? { ...m, status: response.data.status, is_active: response.data.is_active }
+            : m
+        )
+      );
     } catch (error) {
-      toast.error('Failed to update member status');
+      toast.error(error.response?.data?.error || 'Failed to update member status');
+    }
+  };
+
+  const handleStatement = async (memberId) => {
+    try {
+      const response = await api.get(`/members/${memberId}/statement`, {
+        params: { format: 'pdf' },
+        responseType: 'blob',
+      });
+      const url = URL.createObjectURL(response.data);
+      window.open(url, '_blank');
+      setTimeout(() => URL.revokeObjectURL(url), 60000);
+    } catch (error) {
+      toast.error('Failed to fetch statement');
+    }
+  };
+
+  const handleExport = async () => {
+    try {
+      const response = await api.get('/members/export', {
+        params: listParams(),
+        responseType: 'blob',
+      });
+      const url = URL.createObjectURL(response.data);
+      const link = document.createElement('a');
+      link.href = url;
+      link.download = 'members.csv';
+      link.click();
+      URL.revokeObjectURL(url);
+    } catch (error) {
+      toast.error('Failed to export members');
     }
   };
 
@@ -58,62 +123,90 @@ const MemberList = () => {
       selector: (row) => row.admin_name || 'N/A',
       sortable: true,
     },
+    {
+      name: 'Tags',
+      selector: (row) => (row.tags || []).join(', '),
+      wrap: true,
+    },
     {
       name: 'Status',
-      selector: (row) => row.is_active ? 'Active' : 'Inactive',
+      selector: (row) => row.status,
       sortable: true,
       cell: (row) => (
         <span className={row.is_active ? 'status-active' : 'status-inactive'}>
-          {row.is_active ? 'Active' : 'Inactive'}
+          {statusLabels[row.status] || row.status}
         </span>
       ),
     },
     {
       name: 'Actions',
       cell: (row) => (
-        <button
-          className={`btn ${row.is_active ? 'btn-danger' : 'btn-success'}`}
-          onClick={() => handleToggleStatus(row.id, row.is_active)}
-          style={{ padding: '5px 10px', fontSize: '14px' }}
-        >
-          {row.is_active ? 'Deactivate' : 'Activate'}
-        </button>
+        <div className="row-actions">
+          <select
+            className="form-control status-select"
+            value=""
+            onChange={(e) => handleStatusChange(row, e.target.value)}
+            disabled={row.status === 'deceased'}
+          >
+            <option value="">Change status</option>
+            {Object.keys(statusLabels)
+              .filter((status) => status !== row.status)
+              .map((status) => (
+                <option key={status} value={status}>
+                  {statusLabels[status]}
+                </option>
+              ))}
+          </select>
+          <button
+            className="btn btn-secondary"
+            onClick={() => handleStatement(row.id)}
+            style={{ padding: '5px 10px', fontSize: '14px' }}
+          >
+            Statement
+          </button>
+        </div>
       ),
+      minWidth: '280px',
     },
   ];
 
-  const filteredMembers = members.filter(
-    (member) =>
-      member.name.toLowerCase().includes(filterText.toLowerCase()) ||
-      member.mobile_no.includes(filterText) ||
-      (member.admin_name && member.admin_name.toLowerCase().includes(filterText.toLowerCase()))
-  );
-
   return (
     <Layout>
       <div className="container">
         <div className="card">
           <div className="table-header">
             <h2>Member List</h2>
-            <button
-              className="btn btn-primary"
-              onClick={() => navigate('/members/register')}
-            >
-              Add New Member
-            </button>
+            <div className="row-actions">
+              <button className="btn btn-secondary" onClick={handleExport}>
+                Export CSV
+              </button>
+              <button
+                className="btn btn-primary"
+                onClick={() => navigate('/members/register')}
+              >
+                Add New Member
+              </button>
+            </div>
           </div>
           <div className="search-box">
             <input
               type="text"
-              placeholder="Search by name, mobile, or admin..."
+              placeholder="Search by name, mobile, address or admin..."
               className="form-control"
               value={filterText}
               onChange={(e) => setFilterText(e.target.value)}
             />
+            <input
+              type="text"
+              placeholder="Filter by tag..."
+              className="form-control"
+              value={tagFilter}
+              onChange={(e) => setTagFilter(e.target.value)}
+            />
           </div>
           <DataTable
             columns={columns}
-            data={filteredMembers}
+            data={members}
             progressPending={loading}
             pagination
             highlightOnHover
@@ -127,6 +220,20 @@ const MemberList = () => {
               },
             }}
           />
+          <div className="list-footer">
+            <span>
+              Showing {members.length} of {total} members
+            </span>
+            {nextCursor && (
+              <button
+                className="btn btn-secondary"
+                onClick={() => fetchMembers(nextCursor)}
+                disabled={loading}
+              >
+                Load more
+              </button>
+            )}
+          </div>
         </div>
       </div>
     </Layout>
@@ -1,4 +1,4 @@
-import React, { useState } from 'react';
+import React, { useState, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
 import { toast } from 'react-toastify';
 import api from '../../services/api';
@@ -11,9 +11,25 @@ const MemberRegistration = () => {
     mobile_no: '',
     address: '',
   });
+  const [fields, setFields] = useState([]);
+  const [customFields, setCustomFields] = useState({});
+  const [tags, setTags] = useState('');
   const [loading, setLoading] = useState(false);
   const navigate = useNavigate();
 
+  useEffect(() => {
+    fetchFields();
+  }, []);
+
+  const fetchFields = async () => {
+    try {
+      const response = await api.get('/member-fields');
+      setFields(response.data);
+    } catch (error) {
+      toast.error('Failed to fetch custom fields');
+    }
+  };
+
   const handleChange = (e) => {
     setFormData({
       ...formData,
@@ -26,9 +42,20 @@ const MemberRegistration = () => {
     setLoading(true);
 
     try {
-      await api.post('/members', formData);
+      const response = await api.post('/members', {
+        ...formData,
+        custom_fields: customFields,
+        tags: tags.split(',').map((tag) => tag.trim()).filter(Boolean),
+      });
       toast.success('Member registered successfully!');
+      const duplicates = response.data.possible_duplicates || [];
+      if (duplicates.length > 0) {
+        const owners = [...new Set(duplicates.map((d) => d.admin_name))].join(', ');
+        toast.warning(`This mobile number is also registered for ${duplicates.length} other member(s) (admin: ${owners})`);
+      }
       setFormData({ name: '', mobile_no: '', address: '' });
+      setCustomFields({});
+      setTags('');
       navigate('/members');
     } catch (error) {
       toast.error(error.response?.data?.error || 'Failed to register member');
@@ -76,6 +103,42 @@ const MemberRegistration = () => {
                 required
               />
             </div>
+            {fields.map((field) => (
+              <div className="form-group" key={field.id}>
+                <label>{field.label}</label>
+                {field.field_type === 'enum' ? (
+                  <select
+                    className="form-control"
+                    value={customFields[field.key] || ''}
+                    onChange={(e) => setCustomFields({ ...customFields, [field.key]: e.target.value })}
+                  >
+                    <option value="">-</option>
+                    {field.options.map((option) => (
+                      <option key={option} value={option}>
+                        {option}
+                      </option>
+                    ))}
+                  </select>
+                ) : (
+                  <input
+                    type={field.field_type === 'number' ? 'number' : field.field_type === 'date' ? 'date' : 'text'}
+                    className="form-control"
+                    value={customFields[field.key] || ''}
+                    onChange={(e) => setCustomFields({ ...customFields, [field.key]: e.target.value })}
+                  />
+                )}
+              </div>
+            ))}
+            <div className="form-group">
+              <label>Tags</label>
+              <input
+                type="text"
+                className="form-control"
+                placeholder="Comma-separated, e.g. volunteer, senior"
+                value={tags}
+                onChange={(e) => setTags(e.target.value)}
+              />
+            </div>
             <div className="form-actions">
               <button type="submit" className="btn btn-primary" disabled={loading}>
                 {loading ? 'Registering...' : 'Register Member'}
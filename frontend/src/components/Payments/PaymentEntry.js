@@ -9,8 +9,13 @@ const PaymentEntry = () => {
     member_id: '',
     member_name: '',
     contact_no: '',
-    amount: '200',
+    amount: '',
+    payment_mode: 'cash',
+    reference_no: '',
   });
+  const [plan, setPlan] = useState(null);
+  const [household, setHousehold] = useState(null);
+  const [splitAmounts, setSplitAmounts] = useState(null);
   const [members, setMembers] = useState([]);
   const [memberSearch, setMemberSearch] = useState('');
   const [isDropdownOpen, setIsDropdownOpen] = useState(false);
@@ -19,13 +24,17 @@ const PaymentEntry = () => {
   const inputRef = useRef(null);
 
   useEffect(() => {
-    fetchMembers();
-  }, []);
+    // Search on the server, waiting for the user to stop typing.
+    const timer = setTimeout(() => fetchMembers(memberSearch.trim()), 300);
+    return () => clearTimeout(timer);
+  }, [memberSearch]);
 
-  const fetchMembers = async () => {
+  const fetchMembers = async (search) => {
     try {
-      const response = await api.get('/members');
-      setMembers(response.data.filter(m => m.is_active));
+      const response = await api.get('/members', {
+        params: { status: 'active', q: search || undefined, sort: 'name', limit: 20 },
+      });
+      setMembers(response.data.items);
     } catch (error) {
       toast.error('Failed to fetch members');
     }
@@ -61,6 +70,52 @@ const PaymentEntry = () => {
     });
     setMemberSearch(`${member.name} - ${member.mobile_no}`);
     setIsDropdownOpen(false);
+    fetchMemberPlan(member.id);
+    setSplitAmounts(null);
+    setHousehold(null);
+    if (member.household_id) {
+      fetchHousehold(member.household_id);
+    }
+  };
+
+  const fetchHousehold = async (householdId) => {
+    try {
+      const response = await api.get(`/households/${householdId}`);
+      setHousehold(response.data);
+    } catch (error) {
+      setHousehold(null);
+    }
+  };
+
+  // Splitting pays for every household member, recorded as paid by the payer.
+  const handleSplitToggle = (e) => {
+    if (!e.target.checked) {
+      setSplitAmounts(null);
+      return;
+    }
+    const amounts = {};
+    household.members.forEach((m) => {
+      amounts[m.id] = '';
+    });
+    setSplitAmounts(amounts);
+  };
+
+  const splitTotal = splitAmounts
+    ? Object.values(splitAmounts).reduce((sum, value) => sum + (parseFloat(value) || 0), 0)
+    : 0;
+
+  // Prefill the amount from the member's contribution plan.
+  const fetchMemberPlan = async (memberId) => {
+    try {
+      const response = await api.get(`/members/${memberId}/plans`);
+      const current = response.data.current;
+      setPlan(current);
+      if (current) {
+        setFormData((data) => ({ ...data, amount: current.amount.toString() }));
+      }
+    } catch (error) {
+      setPlan(null);
+    }
   };
 
   const handleSearchChange = (e) => {
@@ -70,14 +125,16 @@ const PaymentEntry = () => {
     
     // Clear selection if search doesn't match selected member
     if (formData.member_id) {
-      const selectedMember = members.find(m => m.id === parseInt(formData.member_id));
-      if (!selectedMember || !value.toLowerCase().includes(selectedMember.name.toLowerCase())) {
+      if (!value.toLowerCase().includes(formData.member_name.toLowerCase())) {
         setFormData({
           ...formData,
           member_id: '',
           member_name: '',
           contact_no: '',
         });
+        setPlan(null);
+        setHousehold(null);
+        setSplitAmounts(null);
       }
     }
   };
@@ -86,35 +143,54 @@ const PaymentEntry = () => {
     setIsDropdownOpen(true);
   };
 
-  const filteredMembers = members.filter((member) => {
-    if (!memberSearch.trim()) return true;
-    const query = memberSearch.toLowerCase();
-    return (
-      member.name.toLowerCase().includes(query) ||
-      member.mobile_no.toLowerCase().includes(query)
-    );
-  });
-
   const handleSubmit = async (e) => {
     e.preventDefault();
     setLoading(true);
 
+    const method = {
+      payment_mode: formData.payment_mode,
+      reference_no: formData.reference_no.trim() || undefined,
+    };
+
     try {
-      await api.post('/payments', {
-        ...formData,
-        member_id: parseInt(formData.member_id),
-        amount: parseFloat(formData.amount),
-      });
+      if (splitAmounts) {
+        await api.post('/payments', {
+          ...method,
+          payer_member_id: household.payer_member_id,
+          splits: Object.entries(splitAmounts)
+            .filter(([, amount]) => parseFloat(amount) > 0)
+            .map(([memberId, amount]) => ({
+              member_id: parseInt(memberId),
+              amount: parseFloat(amount),
+            })),
+        });
+      } else {
+        await api.post('/payments', {
+          ...method,
+          member_id: parseInt(formData.member_id),
+          amount: parseFloat(formData.amount),
+        });
+      }
       toast.success('Payment recorded successfully!');
       setFormData({
         member_id: '',
         member_name: '',
         contact_no: '',
-        amount: '200',
+        amount: '',
+        payment_mode: 'cash',
+        reference_no: '',
       });
+      setPlan(null);
+      setHousehold(null);
+      setSplitAmounts(null);
       setMemberSearch('');
     } catch (error) {
-      toast.error(error.response?.data?.error || 'Failed to record payment');
+      const fields = error.response?.data?.fields;
+      if (fields) {
+        Object.values(fields).forEach((message) => toast.error(message));
+      } else {
+        toast.error(error.response?.data?.error || 'Failed to record payment');
+      }
     } finally {
       setLoading(false);
     }
@@ -148,12 +224,12 @@ const PaymentEntry = () => {
                 />
                 {isDropdownOpen && (
                   <div className="searchable-select-dropdown">
-                    {filteredMembers.length === 0 ? (
+                    {members.length === 0 ? (
                       <div className="searchable-select-no-results">
                         No members found matching "{memberSearch}"
                       </div>
                     ) : (
-                      filteredMembers.map((member) => (
+                      members.map((member) => (
                         <div
                           key={member.id}
                           className={`searchable-select-option ${
@@ -171,28 +247,90 @@ const PaymentEntry = () => {
               </div>
             </div>
             <div className="form-group">
-              <label>Contact No *</label>
+              <label>Contact No</label>
               <input
                 type="text"
                 name="contact_no"
                 className="form-control"
                 value={formData.contact_no}
-                onChange={handleChange}
-                required
+                readOnly
               />
             </div>
+            {household && household.members.length > 1 && (
+              <div className="form-group">
+                <label>
+                  <input
+                    type="checkbox"
+                    checked={splitAmounts !== null}
+                    onChange={handleSplitToggle}
+                  />{' '}
+                  Pay for the {household.name} household (paid by {household.payer_name})
+                </label>
+              </div>
+            )}
+            {splitAmounts ? (
+              <div className="form-group">
+                <label>Amount per member * (total: ₹{splitTotal.toFixed(2)})</label>
+                {household.members.map((m) => (
+                  <div key={m.id} className="split-row">
+                    <span>{m.name}</span>
+                    <input
+                      type="number"
+                      className="form-control"
+                      value={splitAmounts[m.id]}
+                      onChange={(e) => setSplitAmounts({ ...splitAmounts, [m.id]: e.target.value })}
+                      step="0.01"
+                      min="0"
+                    />
+                  </div>
+                ))}
+              </div>
+            ) : (
+              <div className="form-group">
+                <label>
+                  Amount *
+                  {plan && ` (${plan.name}: ₹${plan.amount} ${plan.frequency})`}
+                </label>
+                <input
+                  type="number"
+                  name="amount"
+                  className="form-control"
+                  value={formData.amount}
+                  onChange={handleChange}
+                  step="0.01"
+                  min="0.01"
+                  required
+                />
+              </div>
+            )}
+            <div className="form-group">
+              <label>Payment Mode *</label>
+              <select
+                name="payment_mode"
+                className="form-control"
+                value={formData.payment_mode}
+                onChange={handleChange}
+              >
+                <option value="cash">Cash</option>
+                <option value="upi">UPI</option>
+                <option value="bank_transfer">Bank Transfer</option>
+                <option value="cheque">Cheque</option>
+              </select>
+            </div>
             <div className="form-group">
-              <label>Amount * (Monthly contribution: ₹200)</label>
+              <label>
+                {formData.payment_mode === 'cheque' ? 'Cheque No' : 'Reference No'}
+                {formData.payment_mode !== 'cash' && ' *'}
+              </label>
               <input
-                type="number"
-                name="amount"
+                type="text"
+                name="reference_no"
                 className="form-control"
-                value={formData.amount}
+                value={formData.reference_no}
                 onChange={handleChange}
-                step="0.01"
-                min="0"
-                placeholder="200"
-                required
+                placeholder={formData.payment_mode === 'cash' ? 'Optional, e.g. receipt book number' : 'Transaction reference'}
+                maxLength={100}
+                required={formData.payment_mode !== 'cash'}
               />
             </div>
             <div className="form-actions">
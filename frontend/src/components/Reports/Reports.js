@@ -8,6 +8,8 @@ import autoTable from 'jspdf-autotable';
 import './Reports.css';
 
 const Reports = () => {
+  const user = JSON.parse(localStorage.getItem('user') || '{}');
+  const canViewFinancials = (user.capabilities || []).includes('reports.financial');
   const [paidMembers, setPaidMembers] = useState([]);
   const [unpaidMembers, setUnpaidMembers] = useState([]);
   const [monthlyCollection, setMonthlyCollection] = useState([]);
@@ -89,7 +91,8 @@ const Reports = () => {
       const [monthlyCollectionRes, monthlyDonationsRes, poolBalanceRes] = await Promise.all([
         api.get('/reports/monthly-collection'),
         api.get('/reports/monthly-donations'),
-        api.get('/reports/pool-balance'),
+        // Pool balance needs the reports.financial capability
+        canViewFinancials ? api.get('/reports/pool-balance') : Promise.resolve({ data: null }),
       ]);
 
       setMonthlyCollection(monthlyCollectionRes.data || []);
@@ -160,6 +163,11 @@ const Reports = () => {
       selector: (row) => row.admin_name,
       sortable: true,
     },
+    {
+      name: 'Tags',
+      selector: (row) => (row.tags || []).join(', '),
+      wrap: true,
+    },
   ];
 
   const unpaidMembersColumns = [
@@ -174,11 +182,20 @@ const Reports = () => {
       selector: (row) => row.mobile_no,
       sortable: true,
     },
+    {
+      name: 'Paid / Expected',
+      selector: (row) => `₹${(row.paid_amount || 0).toFixed(2)} / ₹${(row.expected_amount || 0).toFixed(2)}`,
+    },
     {
       name: 'Account Admin',
       selector: (row) => row.admin_name,
       sortable: true,
     },
+    {
+      name: 'Tags',
+      selector: (row) => (row.tags || []).join(', '),
+      wrap: true,
+    },
   ];
 
   const monthlyCollectionColumns = [
@@ -225,6 +242,11 @@ const Reports = () => {
       sortable: true,
       right: true,
     },
+    {
+      name: 'Mode',
+      selector: (row) => (row.cheque_status ? `cheque (${row.cheque_status})` : (row.payment_mode || '').replace('_', ' ')),
+      sortable: true,
+    },
     {
       name: 'Account Admin',
       selector: (row) => row.admin_name,
@@ -357,7 +379,8 @@ const Reports = () => {
     const filteredData = paidMembers.filter(item => 
       item.member_name?.toLowerCase().includes(paidFilterText.toLowerCase()) ||
       item.mobile_no?.toLowerCase().includes(paidFilterText.toLowerCase()) ||
-      item.admin_name?.toLowerCase().includes(paidFilterText.toLowerCase())
+      item.admin_name?.toLowerCase().includes(paidFilterText.toLowerCase()) ||
+      (item.tags || []).some((tag) => tag.includes(paidFilterText.toLowerCase()))
     );
     
     // Title
@@ -378,11 +401,12 @@ const Reports = () => {
       `Rs. ${item.paid_amount.toFixed(2)}`,
       item.payment_date,
       item.admin_name,
+      (item.tags || []).join(', '),
     ]);
 
     // Add table
     autoTable(doc, {
-      head: [['Member Name', 'Mobile No', 'Paid Amount', 'Payment Date', 'Account Admin']],
+      head: [['Member Name', 'Mobile No', 'Paid Amount', 'Payment Date', 'Account Admin', 'Tags']],
       body: tableData,
       startY: 52,
       styles: { fontSize: 8 },
@@ -406,7 +430,8 @@ const Reports = () => {
     const filteredData = unpaidMembers.filter(item => 
       item.member_name?.toLowerCase().includes(unpaidFilterText.toLowerCase()) ||
       item.mobile_no?.toLowerCase().includes(unpaidFilterText.toLowerCase()) ||
-      item.admin_name?.toLowerCase().includes(unpaidFilterText.toLowerCase())
+      item.admin_name?.toLowerCase().includes(unpaidFilterText.toLowerCase()) ||
+      (item.tags || []).some((tag) => tag.includes(unpaidFilterText.toLowerCase()))
     );
     
     // Title
@@ -420,12 +445,14 @@ const Reports = () => {
     const tableData = filteredData.map(item => [
       item.member_name,
       item.mobile_no,
+      `Rs. ${(item.paid_amount || 0).toFixed(2)} / Rs. ${(item.expected_amount || 0).toFixed(2)}`,
       item.admin_name,
+      (item.tags || []).join(', '),
     ]);
 
     // Add table
     autoTable(doc, {
-      head: [['Member Name', 'Mobile No', 'Account Admin']],
+      head: [['Member Name', 'Mobile No', 'Paid / Expected', 'Account Admin', 'Tags']],
       body: tableData,
       startY: 45,
       styles: { fontSize: 8 },
@@ -493,7 +520,8 @@ const Reports = () => {
             data={paidMembers.filter(item => 
               item.member_name?.toLowerCase().includes(paidFilterText.toLowerCase()) ||
               item.mobile_no?.toLowerCase().includes(paidFilterText.toLowerCase()) ||
-              item.admin_name?.toLowerCase().includes(paidFilterText.toLowerCase())
+              item.admin_name?.toLowerCase().includes(paidFilterText.toLowerCase()) ||
+              (item.tags || []).some((tag) => tag.includes(paidFilterText.toLowerCase()))
             )}
             progressPending={paidMembersLoading}
             pagination
@@ -503,7 +531,7 @@ const Reports = () => {
             subHeaderComponent={
               <input
                 type="text"
-                placeholder="Search by member name, mobile, or admin..."
+                placeholder="Search by member name, mobile, admin or tag..."
                 value={paidFilterText}
                 onChange={(e) => setPaidFilterText(e.target.value)}
                 style={{
@@ -537,7 +565,8 @@ const Reports = () => {
             data={unpaidMembers.filter(item => 
               item.member_name?.toLowerCase().includes(unpaidFilterText.toLowerCase()) ||
               item.mobile_no?.toLowerCase().includes(unpaidFilterText.toLowerCase()) ||
-              item.admin_name?.toLowerCase().includes(unpaidFilterText.toLowerCase())
+              item.admin_name?.toLowerCase().includes(unpaidFilterText.toLowerCase()) ||
+              (item.tags || []).some((tag) => tag.includes(unpaidFilterText.toLowerCase()))
             )}
             progressPending={unpaidMembersLoading}
             pagination
@@ -547,7 +576,7 @@ const Reports = () => {
             subHeaderComponent={
               <input
                 type="text"
-                placeholder="Search by member name, mobile, or admin..."
+                placeholder="Search by member name, mobile, admin or tag..."
                 value={unpaidFilterText}
                 onChange={(e) => setUnpaidFilterText(e.target.value)}
                 style={{
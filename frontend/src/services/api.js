@@ -24,16 +24,49 @@ api.interceptors.request.use(
 );
 
 // Handle response errors
+let refreshPromise = null;
+
+const clearSession = () => {
+  localStorage.removeItem('token');
+  localStorage.removeItem('refreshToken');
+  localStorage.removeItem('user');
+  window.location.href = '/login';
+};
+
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
-    // Don't redirect on 401 for login/signup endpoints - let them handle the error
-    const isAuthEndpoint = error.config?.url?.includes('/auth/login') || error.config?.url?.includes('/auth/signup');
-    
+  async (error) => {
+    // Don't redirect on 401 for login/signup/refresh endpoints - let them handle the error
+    const isAuthEndpoint = error.config?.url?.includes('/auth/login') ||
+      error.config?.url?.includes('/auth/signup') ||
+      error.config?.url?.includes('/auth/refresh') ||
+      error.config?.url?.includes('/auth/password/') ||
+      error.config?.url?.includes('/auth/2fa/');
+
     if (error.response?.status === 401 && !isAuthEndpoint) {
-      localStorage.removeItem('token');
-      localStorage.removeItem('user');
-      window.location.href = '/login';
+      const refreshToken = localStorage.getItem('refreshToken');
+      if (!refreshToken || error.config._retried) {
+        clearSession();
+        return Promise.reject(error);
+      }
+
+      try {
+        // Share a single refresh call between concurrent failed requests
+        if (!refreshPromise) {
+          refreshPromise = axios.post(`${API_BASE_URL}/auth/refresh`, { refresh_token: refreshToken })
+            .finally(() => { refreshPromise = null; });
+        }
+        const response = await refreshPromise;
+        localStorage.setItem('token', response.data.token);
+        localStorage.setItem('refreshToken', response.data.refresh_token);
+
+        error.config._retried = true;
+        error.config.headers.Authorization = `Bearer ${response.data.token}`;
+        return api(error.config);
+      } catch (refreshError) {
+        clearSession();
+        return Promise.reject(error);
+      }
     }
     return Promise.reject(error);
   }
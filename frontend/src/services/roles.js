@@ -0,0 +1,7 @@
+// Turns a role name such as "account_admin" into "Account Admin".
+export const formatRole = (role = '') =>
+  role
+    .split('_')
+    .filter(Boolean)
+    .map((word) => word.charAt(0).toUpperCase() + word.slice(1))
+    .join(' ');
@@ -0,0 +1,10 @@
+// Stores the tokens and user details returned by any login endpoint.
+export const storeSession = (session) => {
+  localStorage.setItem('token', session.token);
+  localStorage.setItem('refreshToken', session.refresh_token);
+  localStorage.setItem('user', JSON.stringify({
+    id: session.user_id,
+    type: session.user_type,
+    capabilities: session.capabilities || [],
+  }));
+};